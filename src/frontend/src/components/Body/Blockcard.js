@@ -4,26 +4,37 @@ import { toast } from "react-toastify";
 import loader from "../../assets/loader.gif";
 import { apiBaseUrl } from "../../Utils/Apis";
 
+const BLOCKS_PAGE_LIMIT = 100;
+
 function Blockcard({ reload }) {
   const [data, setdata] = useState([]);
   const [loading, setLoading] = useState(false);
 
   useEffect(() => {
     FetchBlocks();
-    // const intervalId = setInterval(() => {
-    //   FetchBlocks();
-    // }, 3000); // Fetches new blockchain data every 3 seconds
+    // Fetches new blockchain data every time a block is added
+    const events = new EventSource(
+      apiBaseUrl + "/api/events?types=block_added"
+    );
+    events.addEventListener("block_added", () => FetchBlocks());
 
-    // // Cleanup function to clear the interval
-    // return () => clearInterval(intervalId);
-  }, [reload]); // Dependency array ensures the interval is reset when 'reload' changes
+    // Cleanup function to close the event stream
+    return () => events.close();
+  }, [reload]); // Dependency array ensures the stream is reopened when 'reload' changes
 
   const FetchBlocks = async () => {
     setLoading(true);
+    // only the latest page of blocks is displayed
     await axios
-      .get(apiBaseUrl + "/api/get_blockchain")
+      .get(apiBaseUrl + "/api/head")
+      .then((head) => {
+        const from = Math.max(0, head.data.height - BLOCKS_PAGE_LIMIT + 1);
+        return axios.get(apiBaseUrl + "/api/blocks", {
+          params: { from: from, limit: BLOCKS_PAGE_LIMIT },
+        });
+      })
       .then((res) => {
-        setdata(res?.data);
+        setdata(res?.data?.blocks);
         setLoading(false);
       })
       .catch((error) => {
@@ -59,6 +70,9 @@ function Blockcard({ reload }) {
               case 2:
                 backgroundColor = "green";
                 break;
+              case 3:
+                backgroundColor = "orange";
+                break;
               default:
                 backgroundColor = "black";
             }
@@ -74,9 +88,15 @@ function Blockcard({ reload }) {
                 key={i}
               >
                 <div style={bannerStyle}></div>
-                <p>Block ID: {bc.index} </p>
+                <p>Block ID: {bc.height} </p>
+                {bc.timestamp && (
+                  <p>Time: {new Date(bc.timestamp).toLocaleString()}</p>
+                )}
                 <p className="break-all">Previous Hash: {bc.prevhash}</p>
                 <p className="break-all">Block Hash :{bc.hash}</p>
+                {bc.proposer && (
+                  <p className="break-all">Proposer: {bc.proposer}</p>
+                )}
                 {bc.data.type === 0 && (
                   <div>
                     <p>Transaction</p>
@@ -96,6 +116,9 @@ function Blockcard({ reload }) {
                     <p>Capacity: {bc.data.problem.capacity}</p>
                     <p>Bounty: {bc.data.problem.bounty}</p>
                     <p>Address: {bc.data.problem.address}</p>
+                    {bc.data.problem.expiry_seconds && (
+                      <p>Expiry: {bc.data.problem.expiry_seconds}s</p>
+                    )}
                   </div>
                 )}
                 {bc.data.type === 2 && (
@@ -112,6 +135,20 @@ function Blockcard({ reload }) {
                     <p>Address: {bc.data.proposed_solution.address}</p>
                   </div>
                 )}
+                {bc.data.type === 3 && (
+                  <div>
+                    <p>Bounty Payout</p>
+                    <p>
+                      ProblemBlockHeight: {bc.data.payout.problem_block_height}
+                    </p>
+                    <p>Policy: {bc.data.payout.policy}</p>
+                    {bc.data.payout.transactions.map((tx, j) => (
+                      <p key={j}>
+                        {tx.from} -&gt; {tx.to}: {tx.amount}
+                      </p>
+                    ))}
+                  </div>
+                )}
                 <br />
               </div>
             );
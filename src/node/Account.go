@@ -0,0 +1,137 @@
+package main
+
+import "sort"
+
+// AddressIndex maps every address to the heights of the blocks touching it.
+// It is kept up to date by Blockchain.addBlock
+type AddressIndex struct {
+	addressToHeights map[string][]int
+}
+
+// Account is the state of an address along with a page of its transaction history
+type Account struct {
+	Address            string  `json:"address"`
+	Balance            float64 `json:"balance"`
+	Nonce              int     `json:"nonce"`
+	PendingNonce       int     `json:"pending_nonce"`       // nonce counting the submissions pending in the mempool
+	EscrowedBounty     float64 `json:"escrowed_bounty"`     // bounties of the open problems posted by the address
+	ProblemsPosted     []int   `json:"problems_posted"`     // heights of the problem blocks
+	SolutionsSubmitted []int   `json:"solutions_submitted"` // heights of the proposed solution blocks
+	History            []Block `json:"history"`             // most recent first
+	HistoryTotal       int     `json:"history_total"`
+	NextOffset         *int    `json:"next_offset,omitempty"`
+}
+
+func NewAddressIndex() *AddressIndex {
+	return &AddressIndex{
+		addressToHeights: make(map[string][]int),
+	}
+}
+
+// blockSender returns the address whose nonce counts a block: the sender of a transfer, or
+// the submitter of a problem or proposed solution. Payouts count for no address
+func blockSender(block Block) string {
+	switch block.Data.Type {
+	case MonetaryTransaction:
+		return block.Data.Transaction.From
+	case KnapsackProblemSubmission:
+		return block.Data.Problem.Address
+	case KnapsackProposedSolutionSubmission:
+		return block.Data.Solution.Address
+	}
+	return ""
+}
+
+// submissionNonce returns the nonce a submission is signed with, 0 for payouts
+func submissionNonce(data BlockData) int {
+	switch data.Type {
+	case MonetaryTransaction:
+		return data.Transaction.Nonce
+	case KnapsackProblemSubmission:
+		return data.Problem.Nonce
+	case KnapsackProposedSolutionSubmission:
+		return data.Solution.Nonce
+	}
+	return 0
+}
+
+// addHeights indexes the addresses of the block at height
+func (index *AddressIndex) addHeights(height int, addresses []string) {
+	for _, address := range addresses {
+		heights := index.addressToHeights[address]
+		// the same address may appear more than once in a block
+		if len(heights) > 0 && heights[len(heights)-1] == height {
+			continue
+		}
+		index.addressToHeights[address] = append(heights, height)
+	}
+}
+
+// Heights returns the heights of the blocks touching the address, in chain order
+func (index *AddressIndex) Heights(address string) []int {
+	return index.addressToHeights[address]
+}
+
+// GetAccount returns the state of an address and up to limit entries of its
+// history, skipping the offset most recent ones
+func (bc *Blockchain) GetAccount(address string, ledger *Ledger, offset int, limit int) *Account {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	account := &Account{
+		Address:            address,
+		Balance:            ledger.GetBalance(address),
+		Nonce:              bc.store.Nonce(address),
+		PendingNonce:       bc.store.Nonce(address),
+		ProblemsPosted:     make([]int, 0),
+		SolutionsSubmitted: make([]int, 0),
+		History:            make([]Block, 0),
+	}
+
+	if bc.producer != nil {
+		account.PendingNonce += bc.producer.mempool.pendingCount(address, account.Nonce)
+	}
+
+	currentHeight := bc.getLastBlock().Height
+	heights := bc.addressIndex.Heights(address)
+	for _, height := range heights {
+		block, exists := bc.blockAt(height)
+		if !exists {
+			// problem and solution blocks only touch the address submitting them
+			header, _ := bc.headerAt(height)
+			if header.Type == KnapsackProblemSubmission {
+				account.ProblemsPosted = append(account.ProblemsPosted, height)
+			} else if header.Type == KnapsackProposedSolutionSubmission {
+				account.SolutionsSubmitted = append(account.SolutionsSubmitted, height)
+			}
+			continue
+		}
+		switch block.Data.Type {
+		case KnapsackProblemSubmission:
+			if block.Data.Problem.Address != address {
+				continue
+			}
+			account.ProblemsPosted = append(account.ProblemsPosted, height)
+			if problemExpiryHeight(height) > currentHeight && bc.findPayout(height) == nil {
+				account.EscrowedBounty += block.Data.Problem.Bounty
+			}
+		case KnapsackProposedSolutionSubmission:
+			if block.Data.Solution.Address == address {
+				account.SolutionsSubmitted = append(account.SolutionsSubmitted, height)
+			}
+		}
+	}
+
+	// the history only holds the blocks whose bodies the chain holds
+	heights = heights[sort.SearchInts(heights, bc.store.Base()):]
+	account.HistoryTotal = len(heights)
+	for i := len(heights) - 1 - offset; i >= 0 && len(account.History) < limit; i-- {
+		block, _ := bc.blockAt(heights[i])
+		account.History = append(account.History, block)
+	}
+	if next := offset + len(account.History); next < len(heights) {
+		account.NextOffset = &next
+	}
+
+	return account
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
 )
 
 func HomeLink(w http.ResponseWriter, r *http.Request) {
@@ -13,9 +19,9 @@ func HomeLink(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleGetBlockchain(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
-	bytes, err := json.MarshalIndent(bc.Blocks, "", "  ")
+	bytes, err := json.MarshalIndent(bc.GetAllBlocks(), "", "  ")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondWithError(w, err)
 		return
 	}
 	io.WriteString(w, string(bytes))
@@ -24,12 +30,169 @@ func HandleGetBlockchain(w http.ResponseWriter, r *http.Request, bc *Blockchain)
 func HandleGetLedger(w http.ResponseWriter, r *http.Request, ledger *Ledger) {
 	bytes, err := json.MarshalIndent(ledger, "", "  ")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondWithError(w, err)
 		return
 	}
 	io.WriteString(w, string(bytes))
 }
 
+func HandleGetHead(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	head := bc.GetHead()
+	respondWithETag(w, r, head.Hash, head)
+}
+
+func HandleGetBlocks(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	from, limit, err := queryPage(r, "from")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	page, err := bc.GetBlocks(from, limit)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	// blocks never change once added, so a page only changes when the chain grows
+	respondWithETag(w, r, fmt.Sprintf("%v-%v-%v", page.Head.Hash, from, limit), page)
+}
+
+func HandleGetHeaders(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	from, limit, err := queryPage(r, "from")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	page, err := bc.GetHeaders(from, limit)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithETag(w, r, fmt.Sprintf("%v-%v-%v", page.Head.Hash, from, limit), page)
+}
+
+func HandleGetBlockByHeight(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	height, err := strconv.Atoi(mux.Vars(r)["height"])
+	if err != nil {
+		respondWithError(w, fmt.Errorf("%w: invalid block height", ErrInvalidRequest))
+		return
+	}
+
+	block, err := bc.LookupBlock(height)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithETag(w, r, block.Hash, block)
+}
+
+func HandleGetBlockByHash(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	block, err := bc.LookupBlockByHash(mux.Vars(r)["hash"])
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithETag(w, r, block.Hash, block)
+}
+
+func HandleGetAccount(w http.ResponseWriter, r *http.Request, bc *Blockchain, ledger *Ledger) {
+	offset, limit, err := queryPage(r, "offset")
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, bc.GetAccount(mux.Vars(r)["address"], ledger, offset, limit))
+}
+
+// HandleEventStream streams the blockchain events as server-sent events.
+// Events can be filtered with ?types=block_added,leader_changed and ?address=
+func HandleEventStream(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	types := make([]string, 0)
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = strings.Split(value, ",")
+	}
+	streamEvents(w, r, bc, newEventFilter(types, r.URL.Query().Get("address")), func(event Event) interface{} {
+		return event
+	})
+}
+
+// streamEvents writes the events matching filter as server-sent events until the client goes away.
+// encode turns each event into the payload sent to the client
+func streamEvents(w http.ResponseWriter, r *http.Request, bc *Blockchain, filter EventFilter, encode func(Event) interface{}) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, errors.New("streaming not supported"))
+		return
+	}
+
+	subscription := bc.Events().Subscribe(filter)
+	defer bc.Events().Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(EVENT_STREAM_KEEP_ALIVE)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case event := <-subscription.Events:
+			data, err := json.Marshal(encode(event))
+			if err != nil {
+				logWarnf("Failed to encode event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func HandleGetOpenProblems(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	respondWithJSON(w, http.StatusOK, bc.GetOpenProblems())
+}
+
+func HandleGetProblem(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	height, err := strconv.Atoi(mux.Vars(r)["height"])
+	if err != nil {
+		respondWithError(w, fmt.Errorf("%w: invalid problem block height", ErrInvalidRequest))
+		return
+	}
+
+	history, err := bc.GetProblemHistory(height)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, history)
+}
+
+func HandleValidateProblem(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	var problem KnapsackProblem
+	if err := decodeJSONBody(r, &problem); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, bc.DryRunProblem(problem))
+}
+
+func HandleValidateProposedSolution(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	var proposedSolution KnapsackProposedSolution
+	if err := decodeJSONBody(r, &proposedSolution); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, bc.DryRunProposedSolution(proposedSolution))
+}
+
 func HandleWriteProposedSolutionBlock(w http.ResponseWriter, r *http.Request, bc *Blockchain, ledger *Ledger) {
 	log.Println("Received proposed solution block")
 	WriteNewBlockData[KnapsackProposedSolution](w, r, bc.GenerateProposedSolutionBlock, bc, ledger)
@@ -40,37 +203,124 @@ func HandleWriteProblemBlock(w http.ResponseWriter, r *http.Request, bc *Blockch
 	WriteNewBlockData[KnapsackProblem](w, r, bc.GenerateProblemBlock, bc, ledger)
 }
 
+func HandleWriteTransactionBlock(w http.ResponseWriter, r *http.Request, bc *Blockchain, ledger *Ledger) {
+	log.Println("Received transaction block")
+	WriteNewBlockData[Transaction](w, r, bc.GenerateTransactionBlock, bc, ledger)
+}
+
 func WriteNewBlockData[T any](w http.ResponseWriter, r *http.Request, generateBlock func(T) (Block, error), bc *Blockchain, ledger *Ledger) {
-	w.Header().Set("Content-Type", "application/json")
 	var data T
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&data); err != nil {
+	if err := decodeJSONBody(r, &data); err != nil {
 		log.Println("Invalid decoded json")
-		respondWithJSON(w, http.StatusBadRequest, r.Body)
+		respondWithError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
-	newBlock, err := generateBlock(data)
+	newBlock, pending, err := SubmitBlockData(data, generateBlock, bc, ledger)
 	if err != nil {
-		log.Println("Invalid generated")
-		respondWithJSON(w, http.StatusInternalServerError, r.Body)
+		respondWithError(w, err)
 		return
 	}
-
-	if !bc.isNewBlockCorrectlyChained(newBlock) {
-		log.Println("Invalid block")
-		respondWithJSON(w, http.StatusInternalServerError, "Invalid block")
+	if pending != nil {
+		respondWithJSON(w, http.StatusAccepted, pending.Entry)
 		return
 	}
 
+	respondWithJSON(w, http.StatusCreated, newBlock)
+}
+
+// SubmitBlockData generates a block holding data at the current tip and adds it to the blockchain.
+// With a validator set, the data goes through the mempool instead, and a PendingSubmission is
+// returned when no block holds it after MEMPOOL_WAIT. It is shared by the REST and JSON-RPC APIs
+func SubmitBlockData[T any](data T, generateBlock func(T) (Block, error), bc *Blockchain, ledger *Ledger) (Block, *PendingSubmission, error) {
+	newBlock, err := generateBlock(data)
+	if err != nil {
+		log.Println("Invalid generated")
+		return Block{}, nil, err
+	}
+	if err := bc.checkLimits(newBlock.Data); err != nil {
+		return Block{}, nil, err
+	}
+	if producer, err := bc.Producer(); err == nil {
+		return producer.SubmitAndWait(newBlock.Data, MEMPOOL_WAIT)
+	}
+
+	// AddBlock checks the block is chained to the current tip
 	if err := bc.AddBlock(newBlock, ledger); err != nil {
 		log.Println("New block has invalid data:", err)
-		respondWithJSON(w, http.StatusInternalServerError, err.Error())
-		return
+		return Block{}, nil, err
 	}
 
-	respondWithJSON(w, http.StatusCreated, newBlock)
+	return newBlock, nil, nil
+}
+
+// newEventFilter creates the filter of an event subscription
+func newEventFilter(types []string, address string) EventFilter {
+	filter := EventFilter{
+		Types:   make(map[EventType]bool),
+		Address: address,
+	}
+	for _, eventType := range types {
+		filter.Types[EventType(eventType)] = true
+	}
+	return filter
+}
+
+// decodeJSONBody decodes the request body into data
+func decodeJSONBody(r *http.Request, data interface{}) error {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("%w: at most %v bytes", ErrBodyTooLarge, maxBytesError.Limit)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	return nil
+}
+
+// queryPage reads the start (named startName) and limit query parameters of a paginated request
+func queryPage(r *http.Request, startName string) (int, int, error) {
+	start, err := queryInt(r, startName, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid %v", ErrInvalidRequest, startName)
+	}
+	limit, err := queryInt(r, "limit", DEFAULT_BLOCKS_PAGE_LIMIT)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid limit", ErrInvalidRequest)
+	}
+	return start, limit, checkPage(startName, start, limit)
+}
+
+// checkPage checks the start (named startName) and limit of a paginated request
+func checkPage(startName string, start int, limit int) error {
+	if start < 0 {
+		return fmt.Errorf("%w: invalid %v", ErrInvalidRequest, startName)
+	}
+	if limit < 1 || limit > MAX_BLOCKS_PAGE_LIMIT {
+		return fmt.Errorf("%w: invalid limit. Must be between 1 and %v", ErrInvalidRequest, MAX_BLOCKS_PAGE_LIMIT)
+	}
+	return nil
+}
+
+// queryInt reads an integer query parameter, returning defaultValue when it is missing
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// respondWithETag responds with the payload unless the client already has the version identified by tag
+func respondWithETag(w http.ResponseWriter, r *http.Request, tag string, payload interface{}) {
+	etag := `"` + tag + `"`
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match == etag || match == "*" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, payload)
 }
 
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -80,6 +330,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 		w.Write([]byte("HTTP 500: Internal Server Error"))
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	w.Write(response)
 }
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+
+	"solvernet/client"
+)
+
+// A chain archive is the magic bytes followed by records: the header, then every
+// block from genesis. A record is the big endian uint32 length of its JSON payload,
+// the payload and the big endian CRC-32 (IEEE) of the payload.
+// A compressed archive is the gzip of an archive
+
+// ArchiveHeader identifies the chain of an archive
+type ArchiveHeader struct {
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	TipHash     string `json:"tip_hash"`
+	Height      int    `json:"height"` // height of the tip
+}
+
+// ImportResult tells what an import did
+type ImportResult struct {
+	Imported int    `json:"imported"` // blocks added, payouts included
+	Skipped  int    `json:"skipped"`  // blocks the chain already had
+	Height   int    `json:"height"`   // height of the chain after the import
+	TipHash  string `json:"tip_hash"`
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// WriteArchive writes the blocks of the chain up to the current tip as an archive, compressed
+// if asked. The blocks are read from the store as they are written
+func (bc *Blockchain) WriteArchive(w io.Writer, compress bool) error {
+	bc.mutex.Lock()
+	base := bc.store.Base()
+	genesis, _ := bc.blockAt(0)
+	tip := bc.getLastBlock()
+	bc.mutex.Unlock()
+	if base != 0 {
+		return fmt.Errorf("%w: the first block held is %v", ErrHistoryNotHeld, base)
+	}
+
+	header := ArchiveHeader{
+		ChainID:     CHAIN_ID,
+		GenesisHash: genesis.Hash,
+		TipHash:     tip.Hash,
+		Height:      tip.Height,
+	}
+	if compress {
+		compressed := gzip.NewWriter(w)
+		if err := bc.writeArchive(compressed, header); err != nil {
+			return err
+		}
+		return compressed.Close()
+	}
+	return bc.writeArchive(w, header)
+}
+
+func (bc *Blockchain) writeArchive(w io.Writer, header ArchiveHeader) error {
+	buffered := bufio.NewWriter(w)
+	if _, err := buffered.WriteString(ARCHIVE_MAGIC); err != nil {
+		return err
+	}
+	if err := writeArchiveRecord(buffered, header); err != nil {
+		return err
+	}
+	err := bc.store.Range(0, header.Height, func(block Block) error {
+		return writeArchiveRecord(buffered, block)
+	})
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func writeArchiveRecord(w io.Writer, record interface{}) error {
+	encoded, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(encoded)
+	return err
+}
+
+// encodeRecord frames the JSON of record as a record of archives and chain logs
+func encodeRecord(record interface{}) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	encoded := binary.BigEndian.AppendUint32(make([]byte, 0, len(payload)+8), uint32(len(payload)))
+	encoded = append(encoded, payload...)
+	return binary.BigEndian.AppendUint32(encoded, crc32.ChecksumIEEE(payload)), nil
+}
+
+// ArchiveReader reads the records of an archive, compressed or not
+type ArchiveReader struct {
+	reader *bufio.Reader
+	Header ArchiveHeader
+}
+
+// NewArchiveReader reads the magic bytes and header of an archive
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	reader := bufio.NewReader(r)
+	if start, err := reader.Peek(len(gzipMagic)); err == nil && bytes.Equal(start, gzipMagic) {
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		reader = bufio.NewReader(decompressed)
+	}
+
+	magic := make([]byte, len(ARCHIVE_MAGIC))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != ARCHIVE_MAGIC {
+		return nil, fmt.Errorf("%w: not a chain archive", ErrInvalidArchive)
+	}
+
+	archive := &ArchiveReader{reader: reader}
+	if err := archive.readRecord(&archive.Header); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing header", ErrInvalidArchive)
+		}
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Next returns the next block of the archive, or io.EOF after the last one
+func (archive *ArchiveReader) Next() (Block, error) {
+	var block Block
+	err := archive.readRecord(&block)
+	return block, err
+}
+
+// readRecord decodes the next record into record. It returns io.EOF when the archive ends between records
+func (archive *ArchiveReader) readRecord(record interface{}) error {
+	var length uint32
+	if err := binary.Read(archive.reader, binary.BigEndian, &length); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("%w: truncated record", ErrInvalidArchive)
+	}
+	if length > MAX_ARCHIVE_RECORD_SIZE {
+		return fmt.Errorf("%w: record of %v bytes is too large", ErrInvalidArchive, length)
+	}
+
+	payload := make([]byte, length)
+	var checksum uint32
+	if _, err := io.ReadFull(archive.reader, payload); err != nil {
+		return fmt.Errorf("%w: truncated record", ErrInvalidArchive)
+	}
+	if err := binary.Read(archive.reader, binary.BigEndian, &checksum); err != nil {
+		return fmt.Errorf("%w: truncated record", ErrInvalidArchive)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return fmt.Errorf("%w: record checksum does not match", ErrInvalidArchive)
+	}
+	if err := json.Unmarshal(payload, record); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+// ImportArchive adds the blocks of an archive to the chain through AddBlock.
+// The blocks the chain already has must be the same. Payouts are generated by the
+// chain, as when replaying a chain file, and must match the ones of the archive
+func (bc *Blockchain) ImportArchive(r io.Reader, ledger *Ledger) (ImportResult, error) {
+	return bc.importArchive(r, ledger, 0)
+}
+
+// importArchive is ImportArchive, rejecting archives of more than maxBlocks blocks, unless 0
+func (bc *Blockchain) importArchive(r io.Reader, ledger *Ledger, maxBlocks int) (ImportResult, error) {
+	result := ImportResult{}
+	archive, err := NewArchiveReader(r)
+	if err != nil {
+		return result, err
+	}
+	if archive.Header.ChainID != CHAIN_ID {
+		return result, fmt.Errorf("%w: archive of chain %q, not %q", ErrArchiveMismatch, archive.Header.ChainID, CHAIN_ID)
+	}
+	if archive.Header.GenesisHash != GenesisHash() {
+		return result, fmt.Errorf("%w: genesis block does not match", ErrArchiveMismatch)
+	}
+
+	startHeight := bc.GetHead().Height
+	var last Block
+	for height := 0; ; height++ {
+		block, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		last = block
+		if maxBlocks > 0 && height >= maxBlocks {
+			return result, fmt.Errorf("%w: archives of at most %v blocks are imported", ErrBodyTooLarge, maxBlocks)
+		}
+		if block.Height != height {
+			return result, fmt.Errorf("%w: block %v found at height %v", ErrInvalidArchive, block.Height, height)
+		}
+		if calculatedHash, err := calculateHash(block); err != nil || calculatedHash != block.Hash {
+			return result, fmt.Errorf("%w: block %v: hash does not match the block", ErrInvalidArchive, height)
+		}
+
+		if height < bc.GetBaseHeight() {
+			// below the snapshot a chain started from, there is nothing to check the block against
+			result.Skipped++
+			continue
+		}
+		if existing, exists := bc.GetBlockByHeight(height); exists {
+			if existing.Hash != block.Hash {
+				return result, fmt.Errorf("%w: block %v differs from the chain", ErrArchiveMismatch, height)
+			}
+			if height > startHeight {
+				// payout generated when adding a previous block of the archive
+				result.Imported++
+			} else {
+				result.Skipped++
+			}
+			continue
+		}
+		if block.Data.Type == BountyPayoutSubmission {
+			return result, fmt.Errorf("%w: payout %v was not generated by the chain", ErrArchiveMismatch, height)
+		}
+		if err := bc.AddBlock(block, ledger); err != nil {
+			return result, fmt.Errorf("block %v: %w", height, err)
+		}
+		result.Imported++
+	}
+
+	if last.Hash != archive.Header.TipHash {
+		return result, fmt.Errorf("%w: archive ends before its tip", ErrInvalidArchive)
+	}
+	head := bc.GetHead()
+	result.Height = head.Height
+	result.TipHash = head.Hash
+	return result, nil
+}
+
+func HandleExportArchive(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	if bc.GetBaseHeight() != 0 {
+		respondWithError(w, ErrHistoryNotHeld)
+		return
+	}
+	compress := r.URL.Query().Get("gzip") == "true"
+	name := "solvernet.snar"
+	if compress {
+		name += ".gz"
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if err := bc.WriteArchive(w, compress); err != nil {
+		// the status is already sent, so the client sees a truncated archive
+		logWarnf("Failed to export the chain: %v", err)
+	}
+}
+
+// HandleImportArchive imports an archive sent from localhost, of up to MaxImportBlocks blocks
+func HandleImportArchive(w http.ResponseWriter, r *http.Request, bc *Blockchain, ledger *Ledger) {
+	defer r.Body.Close()
+	if !isLoopback(r.RemoteAddr) {
+		respondWithError(w, ErrImportForbidden)
+		return
+	}
+	result, err := bc.importArchive(r.Body, ledger, bc.getLimits().MaxImportBlocks)
+	if err != nil {
+		logWarnf("Import failed after %v blocks: %v", result.Imported, err)
+		respondWithError(w, err)
+		return
+	}
+	logDebugf("Imported %v blocks, skipped %v", result.Imported, result.Skipped)
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// ImportArchiveFile imports an archive file into the chain persisted in dataDir,
+// creating it if needed. The node of dataDir must not be running
+func ImportArchiveFile(path string, dataDir string) (ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer file.Close()
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return ImportResult{}, err
+	}
+	bc, ledger, err := OpenBlockchain(dataDir, false)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer bc.Close()
+	return bc.ImportArchive(file, ledger)
+}
+
+func runChainExport(command *command, args []string) error {
+	flags := newFlags(command)
+	nodeURL := flags.String("node", envOrDefault("SOLVERNET_NODE", DEFAULT_NODE_URL), "URL of the node to export the chain of")
+	dataDir := flags.String("data-dir", "", "export the chain persisted in this data dir instead of asking a node")
+	compress := flags.Bool("gzip", false, "compress the archive")
+	force := flags.Bool("force", false, "overwrite an existing file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	if path == "" {
+		flags.Usage()
+		return errors.New("the archive file is required")
+	}
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%v already exists. Use -force to overwrite it", path)
+	}
+	SetLogLevel("warn")
+
+	temporaryPath := path + ".tmp"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryPath)
+
+	if *dataDir != "" {
+		bc, _, err := OpenBlockchain(*dataDir, true)
+		if err == nil {
+			err = bc.WriteArchive(file, *compress)
+			bc.Close()
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	} else if err := client.New(*nodeURL).ExportChain(context.Background(), file, *compress); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// the archive is read back, so a broken transfer is not kept
+	exported, err := os.Open(temporaryPath)
+	if err != nil {
+		return err
+	}
+	defer exported.Close()
+	archive, err := NewArchiveReader(exported)
+	if err != nil {
+		return err
+	}
+	blocks := 0
+	for {
+		if _, err := archive.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		blocks++
+	}
+	if err := os.Rename(temporaryPath, path); err != nil {
+		return err
+	}
+	fmt.Printf("exported %v blocks to %v, tip %v %v\n", blocks, path, archive.Header.Height, archive.Header.TipHash)
+	return nil
+}
+
+func runChainImport(command *command, args []string) error {
+	flags := newFlags(command)
+	nodeURL := flags.String("node", envOrDefault("SOLVERNET_NODE", DEFAULT_NODE_URL), "URL of the node to import the chain into")
+	dataDir := flags.String("data-dir", "", "import into the chain persisted in this data dir, of a node that is not running, instead of a node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := flags.Arg(0)
+	if path == "" {
+		flags.Usage()
+		return errors.New("the archive file is required")
+	}
+	SetLogLevel("warn")
+
+	var result ImportResult
+	if *dataDir != "" {
+		imported, err := ImportArchiveFile(path, *dataDir)
+		if err != nil {
+			return fmt.Errorf("import failed after %v blocks: %w", imported.Imported, err)
+		}
+		result = imported
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		imported, err := client.New(*nodeURL).ImportChain(context.Background(), file)
+		if err != nil {
+			return err
+		}
+		result = ImportResult(*imported)
+	}
+	fmt.Printf("imported %v blocks, skipped %v. The chain is at height %v, tip %v\n", result.Imported, result.Skipped, result.Height, result.TipHash)
+	return nil
+}
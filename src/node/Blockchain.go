@@ -4,21 +4,33 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"strconv"
 	"sync"
 
+	"solvernet/client"
+
 	"github.com/davecgh/go-spew/spew"
 )
 
 // *** Types ***
 
 type Block struct {
-	Height   int       `json:"height"`
-	Data     BlockData `json:"data"`
-	Hash     string    `json:"hash"`
-	PrevHash string    `json:"prevhash"`
+	Height    int       `json:"height"`
+	Data      BlockData `json:"data"`
+	Hash      string    `json:"hash"`
+	PrevHash  string    `json:"prevhash"`
+	StateRoot string    `json:"state_root,omitempty"` // root of the snapshot taken at the previous block, set every SNAPSHOT_INTERVAL blocks
+	Timestamp int64     `json:"timestamp,omitempty"`  // Unix time in milliseconds the block was produced at. 0 for the genesis block
+	// address of the validator that proposed the block, its signature, and the precommits that
+	// made it final. Empty for the blocks of chains without a validator set, and for payout
+	// blocks, see Validators.go and Consensus.go
+	Proposer  string  `json:"proposer,omitempty"`
+	PublicKey string  `json:"public_key,omitempty"`
+	Signature string  `json:"signature,omitempty"`
+	Commit    *Commit `json:"commit,omitempty"`
 }
 
 type BlockDataType int
@@ -27,6 +39,7 @@ const (
 	MonetaryTransaction BlockDataType = iota
 	KnapsackProblemSubmission
 	KnapsackProposedSolutionSubmission
+	BountyPayoutSubmission
 )
 
 type BlockData struct {
@@ -34,6 +47,7 @@ type BlockData struct {
 	Transaction *Transaction              `json:"transaction,omitempty"`
 	Problem     *KnapsackProblem          `json:"problem,omitempty"`
 	Solution    *KnapsackProposedSolution `json:"proposed_solution,omitempty"`
+	Payout      *BountyPayout             `json:"payout,omitempty"`
 }
 
 type Transaction struct {
@@ -41,6 +55,10 @@ type Transaction struct {
 	To                 string  `json:"to"`
 	Amount             float64 `json:"amount"`
 	ProblemBlockHeight int     `json:"problem_block_height"` // Identifies the block where the problem was submitted in
+	// transfers are signed by the sender, see Signature.go. Payout transactions are not signed
+	Nonce     int    `json:"nonce,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 type Item struct {
@@ -49,15 +67,26 @@ type Item struct {
 }
 
 type Blockchain struct {
-	Blocks []Block
-	mutex  sync.Mutex
+	store        Store // shared with the ledger. Pruned chains and chains started from a snapshot do not hold the oldest bodies
+	pruneDepth   int   // blocks below the tip whose bodies are kept. 0 keeps every body
+	addressIndex *AddressIndex
+	work         *WorkIndex // useful work of the chain, which the fork choice prefers the most of
+	events       *EventHub
+	clock        Clock             // timestamps the blocks produced, and bounds the timestamps of the blocks added
+	validators   *ValidatorSet     // nil when every node adds the submissions it receives
+	producer     *Producer         // makes the blocks of a chain with a validator set
+	limits       Limits            // of the submissions received through the API
+	certificates *certificateCache // DP solutions of the problems certificates are checked against
+	mutex        sync.Mutex
 }
 
 // *** Functions ***
 
+// CreateNewBlockchain creates a blockchain holding the genesis block, in the store of the
+// ledger, which must be empty
 func CreateNewBlockchain(ledger *Ledger) *Blockchain {
 
-	blockchain := &Blockchain{Blocks: make([]Block, 0)}
+	blockchain := newBlockchain(ledger)
 
 	// Create a genesis transaction
 	genesisProblem := KnapsackProblem{
@@ -85,82 +114,243 @@ func CreateNewBlockchain(ledger *Ledger) *Blockchain {
 		panic("Failed to generate genesis block")
 	}
 
-	spew.Dump(genesisBlock)
+	logDebugf("Genesis block: %v", spew.Sdump(genesisBlock))
 
 	blockchain.AddBlock(genesisBlock, ledger)
 
 	return blockchain
 }
 
+// newBlockchain creates a blockchain holding the blocks in the store of the ledger, and indexes them
+func newBlockchain(ledger *Ledger) *Blockchain {
+	bc := &Blockchain{
+		store:        ledger.store,
+		addressIndex: NewAddressIndex(),
+		work:         NewWorkIndex(),
+		events:       NewEventHub(),
+		clock:        SystemClock{},
+		certificates: newCertificateCache(),
+	}
+	for height := bc.store.HeaderBase(); height < bc.store.Base(); height++ {
+		header, _ := bc.store.Header(height)
+		bc.addressIndex.addHeights(height, header.Addresses)
+	}
+	bc.store.Range(bc.store.Base(), bc.store.Tip(), func(block Block) error {
+		bc.addressIndex.addHeights(block.Height, blockAddresses(block))
+		bc.work.add(block, bc.solvedProblem(block))
+		return nil
+	})
+	return bc
+}
+
+// SetClock sets the clock timestamping the blocks, as the simulated one of the simulator
+func (bc *Blockchain) SetClock(clock Clock) {
+	bc.clock = clock
+}
+
+// AddBlock validates and appends a block. ledger must be the ledger the chain was created with
 func (bc *Blockchain) AddBlock(newBlock Block, ledger *Ledger) error {
+	bc.solveAhead(newBlock.Data, MAX_DP_CERTIFICATE_CELLS)
+
 	bc.mutex.Lock()
 	defer bc.mutex.Unlock()
 
-	//switch on the type of block
-	switch newBlock.Data.Type {
-	case MonetaryTransaction:
-		// check is valid transaction
-		if err := bc.validateTransaction(*newBlock.Data.Transaction); err != nil {
-			return err
+	return bc.addBlock(newBlock, ledger)
+}
+
+// addBlock validates and appends a block. The caller must hold bc.mutex
+func (bc *Blockchain) addBlock(newBlock Block, ledger *Ledger) error {
+	if err := bc.checkBlock(newBlock, ledger); err != nil {
+		return err
+	}
+	if err := bc.validateCommit(newBlock); err != nil {
+		return err
+	}
+	// the block is written along with the state it leads to
+	batch, err := bc.blockBatch(newBlock, ledger)
+	if err != nil {
+		logWarnf("Failed to update blockchain state: %v", err)
+		return fmt.Errorf("%w: %v", ErrInvalidLedgerUpdate, err)
+	}
+	if err := bc.writeBlock(batch); err != nil {
+		logErrorf("Failed to write block %v: %v", newBlock.Height, err)
+		return err
+	}
+	bc.publishBlockEvents(newBlock)
+	bc.takeSnapshotIfDue(ledger)
+	bc.settleAfter(newBlock, ledger)
+	bc.prune()
+	return nil
+}
+
+// checkBlock validates a block to be appended at the tip, except for its commit.
+// The caller must hold bc.mutex
+func (bc *Blockchain) checkBlock(newBlock Block, ledger *Ledger) error {
+	if !bc.isNewBlockCorrectlyChained(newBlock) {
+		return ErrBlockNotChained
+	}
+	if err := bc.validateTimestamp(newBlock); err != nil {
+		return err
+	}
+	if err := bc.validateProposer(newBlock); err != nil {
+		return err
+	}
+
+	if newBlock.StateRoot != bc.expectedStateRoot(newBlock.Height) {
+		return ErrInvalidStateRoot
+	}
+	return bc.validateBlockData(newBlock, ledger, 0)
+}
+
+// settleAfter settles the problems the block just appended expires or solves optimally.
+// The caller must hold bc.mutex
+func (bc *Blockchain) settleAfter(newBlock Block, ledger *Ledger) {
+	// check for expired problem and add the rewarding transactions if there are solutions.
+	// This must run before any other block is appended, as it looks at the current height
+	expiredProblemBlock := bc.CheckForExpiredProblem()
+
+	// the new block is already part of the chain, so a failed settlement is not its fault
+	if expiredProblemBlock != nil {
+		if err := bc.settleProblem(expiredProblemBlock.Height, ledger, false); err != nil {
+			logWarnf("Failed to settle expired problem: %v", err)
 		}
-		// update state
-		err := ledger.Update(newBlock)
-		if err != nil {
-			log.Println("Failed to update blockchain state:", err)
-			return errors.New("invalid ledger update")
+	}
+
+	// a solution proven optimal cannot be improved, so its problem is settled right away
+	if newBlock.Data.Type == KnapsackProposedSolutionSubmission && IsCertifiedOptimal(*newBlock.Data.Solution) {
+		if err := bc.settleProblem(newBlock.Data.Solution.ProblemBlockHeight, ledger, true); err != nil {
+			logWarnf("Failed to settle certified problem: %v", err)
 		}
+	}
+}
+
+// validateBlockData checks the data of a block against the chain and ledger it is added to,
+// once the pending submissions of its sender are
+func (bc *Blockchain) validateBlockData(block Block, ledger *Ledger, pending int) error {
+	if blockDataMissing(block) {
+		return fmt.Errorf("%w: missing block data", ErrInvalidBlockType)
+	}
+	switch block.Data.Type {
+	case MonetaryTransaction:
+		return bc.validateTransfer(*block.Data.Transaction, ledger, pending)
 	case KnapsackProblemSubmission:
-		// check if the problem is valid
-		if err := ValidateProblem(*newBlock.Data.Problem, bc); err != nil {
-			return err
-		}
+		return validateProblem(*block.Data.Problem, bc, pending)
 	case KnapsackProposedSolutionSubmission:
-		// check if the solution is valid
-		if err := ValidateProposedSolution(*newBlock.Data.Solution, bc); err != nil {
-			return err
-		}
+		return validateProposedSolutionAt(*block.Data.Solution, bc, block.Timestamp, MAX_DP_CERTIFICATE_CELLS, pending)
+	case BountyPayoutSubmission:
+		// check the payout matches the solutions submitted for the problem
+		return bc.validatePayout(*block.Data.Payout)
 	default:
-		return errors.New("invalid block type")
+		return ErrInvalidBlockType
 	}
+}
 
-	bc.Blocks = append(bc.Blocks, newBlock)
-
-	// check for expired problem and add a rewarding transaction if there is a solution
-	problemSolutionPair := bc.CheckForExpiredProblem()
-
-	if problemSolutionPair == nil {
-		return nil
+// blockDataMissing tells whether a block lacks the data of its type
+func blockDataMissing(block Block) bool {
+	switch block.Data.Type {
+	case MonetaryTransaction:
+		return block.Data.Transaction == nil
+	case KnapsackProblemSubmission:
+		return block.Data.Problem == nil
+	case KnapsackProposedSolutionSubmission:
+		return block.Data.Solution == nil
+	case BountyPayoutSubmission:
+		return block.Data.Payout == nil
 	}
+	return false
+}
 
-	// Add a rewarding transaction
-	tx := Transaction{
-		From:               problemSolutionPair.Problem.Address,
-		To:                 problemSolutionPair.Solution.Address,
-		Amount:             problemSolutionPair.Problem.Bounty,
-		ProblemBlockHeight: problemSolutionPair.Solution.ProblemBlockHeight,
+// blockBatch returns the batch writing a block along with the balances and nonce it changes.
+// The caller must hold bc.mutex
+func (bc *Blockchain) blockBatch(block Block, ledger *Ledger) (Batch, error) {
+	batch := Batch{Blocks: []Block{block}}
+	if block.Data.Type == MonetaryTransaction || block.Data.Type == BountyPayoutSubmission {
+		balances, err := ledger.balancesAfter(block)
+		if err != nil {
+			return batch, err
+		}
+		batch.Balances = balances
 	}
-	newTransactionBlock, err := bc.GenerateTransactionBlock(tx)
-	if err != nil {
-		log.Println("Failed to generate rewarding transaction block")
-		return errors.New("failed to generate rewarding transaction block")
+	if sender := blockSender(block); sender != "" {
+		batch.Nonces = map[string]int{sender: bc.store.Nonce(sender) + 1}
 	}
+	return batch, nil
+}
 
-	bc.AddBlock(newTransactionBlock, ledger)
-
+// writeBlock writes the batch of a block and indexes the block, without checking it. The
+// caller must hold bc.mutex
+func (bc *Blockchain) writeBlock(batch Batch) error {
+	if err := bc.store.Write(batch); err != nil {
+		return err
+	}
+	block := batch.Blocks[0]
+	bc.addressIndex.addHeights(block.Height, blockAddresses(block))
+	bc.work.add(block, bc.solvedProblem(block))
 	return nil
 }
 
+// Close closes the store of the chain. No block can be added after
+func (bc *Blockchain) Close() error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.store.Close()
+}
+
+// Events returns the hub publishing what happens on the blockchain
+func (bc *Blockchain) Events() *EventHub {
+	return bc.events
+}
+
 func (bc *Blockchain) GetBlock(blockHeight int) Block {
 	// try to get the block from the blockchain
 	// if it fails, spew the blockchain and blockchain state and panic
-	if blockHeight < 0 || blockHeight >= len(bc.Blocks) {
+	block, exists := bc.blockAt(blockHeight)
+	if !exists {
 		log.Println("Block height out of range")
-		log.Println("Blockchain:")
-		spew.Dump(bc.Blocks)
+		log.Println("Blockchain head:")
+		spew.Dump(bc.getHead())
 		panic("Block height out of range")
 	}
-	return bc.Blocks[blockHeight]
+	return block
+
+}
+
+// blockAt returns the block at the given height, if the chain holds it. The caller must hold bc.mutex
+func (bc *Blockchain) blockAt(height int) (Block, bool) {
+	return bc.store.Block(height)
+}
+
+// blockRange returns the blocks the chain holds from height from to height to. The caller must hold bc.mutex
+func (bc *Blockchain) blockRange(from int, to int) []Block {
+	blocks := make([]Block, 0)
+	err := bc.store.Range(from, to, func(block Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	if err != nil {
+		logErrorf("Failed to read blocks %v to %v: %v", from, to, err)
+	}
+	return blocks
+}
 
+// blockAddresses returns the addresses touched by a block
+func blockAddresses(block Block) []string {
+	switch block.Data.Type {
+	case MonetaryTransaction:
+		return []string{block.Data.Transaction.From, block.Data.Transaction.To}
+	case KnapsackProblemSubmission:
+		return []string{block.Data.Problem.Address}
+	case KnapsackProposedSolutionSubmission:
+		return []string{block.Data.Solution.Address}
+	case BountyPayoutSubmission:
+		addresses := make([]string, 0)
+		for _, tx := range block.Data.Payout.Transactions {
+			addresses = append(addresses, tx.From, tx.To)
+		}
+		return addresses
+	}
+	return []string{}
 }
 
 func (bc *Blockchain) isNewBlockCorrectlyChained(newBlock Block) bool {
@@ -185,7 +375,13 @@ func (bc *Blockchain) isNewBlockCorrectlyChained(newBlock Block) bool {
 }
 
 func calculateHash(block Block) (string, error) {
-	record := strconv.Itoa(block.Height) + block.PrevHash
+	// the state root is empty for most blocks, which keeps their hashes as they were before it existed.
+	// So does a timestamp of 0, as the one of the genesis block, and the proposer of unsigned blocks
+	record := strconv.Itoa(block.Height) + block.PrevHash + block.StateRoot
+	if block.Timestamp != 0 {
+		record += strconv.FormatInt(block.Timestamp, 10)
+	}
+	record += block.Proposer
 	blockBytes, err := json.Marshal(block.Data)
 	blockBytes = append(blockBytes, []byte(record)...)
 	if err != nil {
@@ -203,12 +399,19 @@ func (bc *Blockchain) generateNewBlock(data BlockData) (Block, error) {
 	var newBlock Block
 
 	// print type of block that is being created
-	log.Printf("Generating new block of type: %v", data.Type)
+	logDebugf("Generating new block of type: %v", data.Type)
 
 	newBlock.Height = oldBlock.Height + 1
 
 	newBlock.Data = data
 	newBlock.PrevHash = oldBlock.Hash
+	newBlock.StateRoot = bc.expectedStateRoot(newBlock.Height)
+	if data.Type == BountyPayoutSubmission {
+		// payouts are produced by every node as it settles a problem, so they must not depend on its clock
+		newBlock.Timestamp = oldBlock.Timestamp
+	} else if newBlock.Height > 0 {
+		newBlock.Timestamp = bc.nextTimestamp()
+	}
 
 	calculatedHash, err := calculateHash(newBlock)
 	if err != nil {
@@ -219,6 +422,40 @@ func (bc *Blockchain) generateNewBlock(data BlockData) (Block, error) {
 	return newBlock, nil
 }
 
+// nextTimestamp returns the timestamp of a block produced now: the time of the clock, unless
+// it is behind the median of the last blocks
+func (bc *Blockchain) nextTimestamp() int64 {
+	return max(bc.clock.Now().UnixMilli(), bc.medianTimestamp())
+}
+
+// medianTimestamp returns the median timestamp of the last MEDIAN_TIME_BLOCKS blocks, 0 when
+// the chain is empty. A few blocks from nodes with a wrong clock do not move it
+func (bc *Blockchain) medianTimestamp() int64 {
+	tip := bc.store.Tip()
+	if tip < 0 {
+		return 0
+	}
+	timestamps := make([]int64, 0, MEDIAN_TIME_BLOCKS)
+	for height := max(tip-MEDIAN_TIME_BLOCKS+1, bc.store.HeaderBase()); height <= tip; height++ {
+		header, _ := bc.store.Header(height)
+		timestamps = append(timestamps, header.Timestamp)
+	}
+	slices.Sort(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// validateTimestamp checks the timestamp of a block is not behind the median of the last
+// blocks, nor more than MAX_BLOCK_TIME_DRIFT ahead of the clock. The caller must hold bc.mutex
+func (bc *Blockchain) validateTimestamp(block Block) error {
+	if median := bc.medianTimestamp(); block.Timestamp < median {
+		return fmt.Errorf("%w: %v is behind the median %v of the last blocks", ErrInvalidTimestamp, block.Timestamp, median)
+	}
+	if limit := bc.clock.Now().Add(MAX_BLOCK_TIME_DRIFT).UnixMilli(); block.Timestamp > limit {
+		return fmt.Errorf("%w: %v is more than %v in the future", ErrInvalidTimestamp, block.Timestamp, MAX_BLOCK_TIME_DRIFT)
+	}
+	return nil
+}
+
 func (bc *Blockchain) GenerateProblemBlock(problem KnapsackProblem) (Block, error) {
 	data := BlockData{
 		Type:    KnapsackProblemSubmission,
@@ -236,13 +473,22 @@ func (bc *Blockchain) GenerateProposedSolutionBlock(proposedSolution KnapsackPro
 	return bc.generateNewBlock(data)
 }
 
+func (bc *Blockchain) GeneratePayoutBlock(payout BountyPayout) (Block, error) {
+	data := BlockData{
+		Type:   BountyPayoutSubmission,
+		Payout: &payout,
+	}
+	return bc.generateNewBlock(data)
+}
+
 func (bc *Blockchain) getLastBlock() Block {
-	if len(bc.Blocks) == 0 { // if the blockchain is empty, return a block with -1 height
+	block, exists := bc.store.Block(bc.store.Tip())
+	if !exists { // if the blockchain is empty, return a block with -1 height
 		return Block{
 			Height: -1,
 		}
 	}
-	return bc.Blocks[len(bc.Blocks)-1]
+	return block
 }
 
 func (bc *Blockchain) GenerateTransactionBlock(tx Transaction) (Block, error) {
@@ -258,9 +504,9 @@ func (bc *Blockchain) GenerateTransactionBlock(tx Transaction) (Block, error) {
 }
 
 func (bc *Blockchain) getLastValidBlocks() []Block {
-	currentHeight := len(bc.Blocks) - 1
-	minBlockIndex := max(0, currentHeight-NUMBER_OF_BLOCKS_TO_SOLUTION)
-	lastBlocksToCheck := bc.Blocks[minBlockIndex:]
+	currentHeight := bc.getLastBlock().Height
+	minBlockHeight := max(0, currentHeight-NUMBER_OF_BLOCKS_TO_SOLUTION)
+	lastBlocksToCheck := bc.blockRange(minBlockHeight, currentHeight)
 	return lastBlocksToCheck
 }
 
@@ -280,35 +526,20 @@ func (bc *Blockchain) checkIfIsBestProposedSolution(proposedSolution *KnapsackPr
 }
 
 // After adding a new block to the blockchain,
-// check if an old problem was solved at current block height
-func (bc *Blockchain) CheckForExpiredProblem() *ProblemSolutionPair {
+// check if an old problem expired at current block height.
+// Every problem block is returned exactly once, when its solution window closes
+func (bc *Blockchain) CheckForExpiredProblem() *Block {
 
-	if len(bc.Blocks) < NUMBER_OF_BLOCKS_TO_SOLUTION {
+	if bc.getLastBlock().Height < NUMBER_OF_BLOCKS_TO_SOLUTION {
 		return nil
 	}
 
-	lastBlocksToCheck := bc.getLastValidBlocks()
-	block := lastBlocksToCheck[0]
+	block := bc.getLastValidBlocks()[0]
 	if block.Data.Type != KnapsackProblemSubmission {
 		return nil
 	}
 
-	problem := block.Data.Problem
-	problemHeight := block.Height
-
-	// Check if there is a solution for this problem in the subsequent blocks
-	for j := 1; j < len(lastBlocksToCheck); j++ {
-		solutionBlock := lastBlocksToCheck[j]
-		if solutionBlock.Data.Type == KnapsackProposedSolutionSubmission &&
-			solutionBlock.Data.Solution.ProblemBlockHeight == problemHeight {
-			return &ProblemSolutionPair{
-				Problem:  problem,
-				Solution: solutionBlock.Data.Solution,
-			}
-		}
-	}
-
-	return nil
+	return &block
 }
 
 func (bc *Blockchain) FindValidProblemsBlocks() []Block {
@@ -317,44 +548,67 @@ func (bc *Blockchain) FindValidProblemsBlocks() []Block {
 	lastBlocksToCheck := bc.getLastValidBlocks()
 
 	for _, block := range lastBlocksToCheck {
-		if block.Data.Type == KnapsackProblemSubmission {
+		if block.Data.Type == KnapsackProblemSubmission && bc.findPayout(block.Height) == nil {
 			problems = append(problems, block)
 		}
 	}
 
 	// print problems found
-	log.Printf("Found %v valid problems", len(problems))
+	logDebugf("Found %v valid problems", len(problems))
 
 	return problems
 }
 
+// validateTransfer checks a transfer between two addresses, once the pending submissions of the
+// sender are. Transfers must be signed by the sender
+func (bc *Blockchain) validateTransfer(tx Transaction, ledger *Ledger, pending int) error {
+	if tx.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if tx.From == "" || tx.To == "" || tx.From == tx.To {
+		return ErrInvalidAddress
+	}
+	if !isKeyAddress(tx.From) {
+		return fmt.Errorf("%w: transfers must be sent from an address derived from a public key", ErrInvalidSignature)
+	}
+	if err := bc.verifySignature(client.TransactionSignature, tx.From, tx.Nonce, pending, tx.PublicKey, tx.Signature, tx); err != nil {
+		return err
+	}
+	if ledger.GetBalance(tx.From) < tx.Amount {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// validateTransaction checks a rewarding transaction of a payout
 func (bc *Blockchain) validateTransaction(tx Transaction) error {
 	if tx.Amount <= 0 {
-		return errors.New("invalid transaction amount")
+		return ErrInvalidAmount
 	}
 	if tx.From == "" || tx.To == "" {
-		return errors.New("invalid transaction address")
+		return ErrInvalidAddress
 	}
 
 	validProblemBlocks := bc.FindValidProblemsBlocks()
 	if len(validProblemBlocks) == 0 {
-		return errors.New("no valid problems to solve")
+		return ErrNoValidProblems
 	}
 
 	// check if the problem block height is valid
-	if tx.ProblemBlockHeight < 0 || tx.ProblemBlockHeight >= len(bc.Blocks) {
-		return errors.New("invalid problem block height")
+	problemBlock, exists := bc.blockAt(tx.ProblemBlockHeight)
+	if !exists {
+		return ErrProblemNotFound
 	}
 
 	// check if the problem block is a problem
-	if bc.Blocks[tx.ProblemBlockHeight].Data.Type != KnapsackProblemSubmission {
-		return errors.New("block at height does not contain a problem")
+	if problemBlock.Data.Type != KnapsackProblemSubmission {
+		return ErrProblemNotFound
 	}
 
 	// check if problem block height is not expired
 	validBlocks := bc.getLastValidBlocks()
 	if tx.ProblemBlockHeight < validBlocks[0].Height {
-		return errors.New("problem at block height is expired")
+		return ErrProblemExpired
 	}
 
 	return nil
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CertificateType identifies how an optimality certificate is checked
+type CertificateType string
+
+const (
+	// DantzigCertificate claims the LP relaxation (Dantzig) upper bound of the problem
+	DantzigCertificate CertificateType = "dantzig"
+	// DPCertificate claims the dynamic programming optimum and the digest of the last DP row
+	DPCertificate CertificateType = "dp"
+)
+
+// OptimalityCertificate proves an upper bound for the value of a knapsack problem.
+// When the bound is equal to the value of a proposed solution, the solution is optimal
+type OptimalityCertificate struct {
+	Type       CertificateType `json:"type"`
+	UpperBound int             `json:"upper_bound"`
+	Digest     string          `json:"digest,omitempty"` // only used by DP certificates
+}
+
+// DantzigBound returns the floor of the LP relaxation optimum of the problem:
+// items are taken greedily by value/weight ratio and the first one that does
+// not fit is taken fractionally
+func DantzigBound(problem KnapsackProblem) int {
+	order := make([]int, len(problem.Items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		itemA := problem.Items[order[a]]
+		itemB := problem.Items[order[b]]
+		return itemA.Value*itemB.Weight > itemB.Value*itemA.Weight
+	})
+
+	bound := 0
+	remaining := problem.Capacity
+	for _, i := range order {
+		item := problem.Items[i]
+		if item.Weight <= remaining {
+			bound += item.Value
+			remaining -= item.Weight
+			continue
+		}
+		bound += remaining * item.Value / item.Weight
+		break
+	}
+	return bound
+}
+
+// SolveDP solves the problem exactly and returns the optimum along with the
+// digest of the last row of the dynamic programming table
+func SolveDP(problem KnapsackProblem) (int, string) {
+	row := make([]int, problem.Capacity+1)
+	for _, item := range problem.Items {
+		for c := problem.Capacity; c >= item.Weight; c-- {
+			if row[c-item.Weight]+item.Value > row[c] {
+				row[c] = row[c-item.Weight] + item.Value
+			}
+		}
+	}
+
+	h := sha256.New()
+	cell := make([]byte, 8)
+	for _, value := range row {
+		binary.BigEndian.PutUint64(cell, uint64(value))
+		h.Write(cell)
+	}
+	return row[problem.Capacity], hex.EncodeToString(h.Sum(nil))
+}
+
+// dpCells returns the size of the DP table of a problem
+func dpCells(problem KnapsackProblem) int {
+	return len(problem.Items) * (problem.Capacity + 1)
+}
+
+// VerifyCertificate checks the certificate claims against the problem
+func VerifyCertificate(problem KnapsackProblem, certificate OptimalityCertificate) error {
+	return verifyCertificate(problem, certificate, func() dpSolution {
+		optimum, digest := SolveDP(problem)
+		return dpSolution{optimum, digest}
+	})
+}
+
+// verifyCertificate checks the certificate claims against the problem, with solve returning
+// its DP solution
+func verifyCertificate(problem KnapsackProblem, certificate OptimalityCertificate, solve func() dpSolution) error {
+	switch certificate.Type {
+	case DantzigCertificate:
+		if DantzigBound(problem) != certificate.UpperBound {
+			return fmt.Errorf("%w: upper bound does not match the Dantzig bound", ErrInvalidCertificate)
+		}
+	case DPCertificate:
+		if dpCells(problem) > MAX_DP_CERTIFICATE_CELLS {
+			return fmt.Errorf("%w: problem too large for a DP certificate", ErrInvalidCertificate)
+		}
+		if solution := solve(); solution.optimum != certificate.UpperBound || solution.digest != certificate.Digest {
+			return fmt.Errorf("%w: does not match the DP table", ErrInvalidCertificate)
+		}
+	default:
+		return fmt.Errorf("%w: invalid type", ErrInvalidCertificate)
+	}
+	return nil
+}
+
+// dpSolution is the optimum of a problem and the digest of the last row of its DP table
+type dpSolution struct {
+	optimum int
+	digest  string
+}
+
+// certificateCache keeps the DP solutions of the last CERTIFICATE_CACHE_SIZE problems DP
+// certificates were checked against, by the hash of their block. The chain solves them ahead,
+// without holding bc.mutex, so that checking the certificates under it takes no time
+type certificateCache struct {
+	mutex     sync.Mutex
+	solutions map[string]dpSolution
+	order     []string // hashes, oldest first
+}
+
+func newCertificateCache() *certificateCache {
+	return &certificateCache{solutions: make(map[string]dpSolution)}
+}
+
+func (cache *certificateCache) get(hash string) (dpSolution, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	solution, exists := cache.solutions[hash]
+	return solution, exists
+}
+
+// solve returns the DP solution of the problem of the block with the given hash, solving it
+// unless cached
+func (cache *certificateCache) solve(hash string, problem KnapsackProblem) dpSolution {
+	if solution, exists := cache.get(hash); exists {
+		return solution
+	}
+	optimum, digest := SolveDP(problem)
+	solution := dpSolution{optimum, digest}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if _, exists := cache.solutions[hash]; !exists {
+		if len(cache.order) == CERTIFICATE_CACHE_SIZE {
+			delete(cache.solutions, cache.order[0])
+			cache.order = cache.order[1:]
+		}
+		cache.solutions[hash] = solution
+		cache.order = append(cache.order, hash)
+	}
+	return solution
+}
+
+// solveAhead solves the problem a DP certificate of the submission is checked against, when
+// its DP table has at most maxCells cells, so that the check finds it cached. It does not
+// hold bc.mutex while solving
+func (bc *Blockchain) solveAhead(data BlockData, maxCells int) {
+	solution := data.Solution
+	if data.Type != KnapsackProposedSolutionSubmission || solution == nil || solution.Certificate == nil || solution.Certificate.Type != DPCertificate {
+		return
+	}
+	block, exists := bc.GetBlockByHeight(solution.ProblemBlockHeight)
+	if !exists || block.Data.Type != KnapsackProblemSubmission || block.Data.Problem == nil || dpCells(*block.Data.Problem) > maxCells {
+		return
+	}
+	bc.certificates.solve(block.Hash, *block.Data.Problem)
+}
+
+// verifySolutionCertificate checks a certificate against the problem of a block, with its DP
+// solution cached. DP tables of more than maxCells cells are only checked when already solved.
+// The caller must hold bc.mutex
+func (bc *Blockchain) verifySolutionCertificate(problemBlock Block, certificate OptimalityCertificate, maxCells int) error {
+	problem := *problemBlock.Data.Problem
+	cells := dpCells(problem)
+	if certificate.Type == DPCertificate && cells > maxCells && cells <= MAX_DP_CERTIFICATE_CELLS {
+		if _, cached := bc.certificates.get(problemBlock.Hash); !cached {
+			return fmt.Errorf("%w: the DP table of the problem has %v cells, up to %v are checked", ErrCertificateUnchecked, cells, maxCells)
+		}
+	}
+	return verifyCertificate(problem, certificate, func() dpSolution {
+		return bc.certificates.solve(problemBlock.Hash, problem)
+	})
+}
+
+// IsCertifiedOptimal tells if the solution carries a certificate proving it is optimal.
+// The certificate must have been verified by ValidateProposedSolution
+func IsCertifiedOptimal(solution KnapsackProposedSolution) bool {
+	return solution.Certificate != nil && solution.Certificate.UpperBound == solution.Value
+}
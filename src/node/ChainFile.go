@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Nodes keep the chain in the log of their data dir, see LogStore.go. Older nodes saved it as
+// the JSON array of its blocks. Such a chain file is moved into the log by replaying every
+// block through AddBlock, so a tampered file is rejected instead of trusted
+
+// ReadBlocksFile reads the blocks saved in path
+func ReadBlocksFile(path string) ([]Block, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []Block
+	if err := json.Unmarshal(bytes, &blocks); err != nil {
+		return nil, fmt.Errorf("invalid chain file %v: %w", path, err)
+	}
+	return blocks, nil
+}
+
+// ChainDivergence is the first block where a chain differs from its replay
+type ChainDivergence struct {
+	Height int
+	Reason error
+}
+
+func (d *ChainDivergence) Error() string {
+	return fmt.Sprintf("block %v: %v", d.Height, d.Reason)
+}
+
+// ReplayBlocks creates a blockchain by adding the given blocks to a new one.
+// Payout blocks are not added but generated by the chain, then compared with the given ones.
+// A chain that cannot be replayed is reported with a *ChainDivergence
+func ReplayBlocks(blocks []Block, ledger *Ledger) (*Blockchain, error) {
+	return replayBlocks(blocks, ledger, SystemClock{})
+}
+
+// replayBlocks is ReplayBlocks, bounding the timestamps of the blocks with clock
+func replayBlocks(blocks []Block, ledger *Ledger, clock Clock) (*Blockchain, error) {
+	bc := CreateNewBlockchain(ledger)
+	bc.SetClock(clock)
+	if len(blocks) == 0 {
+		return bc, nil
+	}
+
+	for height, block := range blocks {
+		if calculatedHash, err := calculateHash(block); err != nil || calculatedHash != block.Hash {
+			return nil, &ChainDivergence{height, errors.New("hash does not match the block")}
+		}
+		if height == 0 {
+			if block.Hash != bc.GetBlock(0).Hash {
+				return nil, &ChainDivergence{height, errors.New("genesis block does not match")}
+			}
+			continue
+		}
+		replayed, exists := bc.GetBlockByHeight(height)
+		if block.Data.Type == BountyPayoutSubmission {
+			if !exists || replayed.Hash != block.Hash {
+				return nil, &ChainDivergence{height, errors.New("payout does not match the replayed chain")}
+			}
+			continue
+		}
+		if exists {
+			return nil, &ChainDivergence{height, errors.New("the replayed chain settles a problem here")}
+		}
+		if err := bc.AddBlock(block, ledger); err != nil {
+			return nil, &ChainDivergence{height, err}
+		}
+	}
+
+	if bc.GetHead().Height != len(blocks)-1 {
+		return nil, &ChainDivergence{len(blocks), errors.New("the replayed chain settles a problem after the last block")}
+	}
+	return bc, nil
+}
+
+// OpenBlockchain opens the chain kept in the log of dataDir, or creates it. A chain file left
+// by an older node is moved into the log. A read-only chain is not recovered nor created, and
+// a chain file is then replayed in memory
+func OpenBlockchain(dataDir string, readOnly bool) (*Blockchain, *Ledger, error) {
+	path := filepath.Join(dataDir, LOG_STORE_FILE)
+	if _, err := os.Stat(path); err != nil && readOnly {
+		ledger := NewLedger()
+		bc, err := openChainFile(dataDir, ledger)
+		return bc, ledger, err
+	}
+
+	store, err := OpenLogStore(path, readOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	ledger := NewStoreLedger(store)
+	if store.Tip() >= 0 {
+		log.Printf("Opened %v at height %v", path, store.Tip())
+		bc := newBlockchain(ledger)
+		if !readOnly {
+			bc.recover(ledger)
+		}
+		return bc, ledger, nil
+	}
+
+	bc, err := openChainFile(dataDir, ledger)
+	if err != nil {
+		// the log is removed, so the chain file is moved again on the next start
+		store.Close()
+		os.Remove(path)
+		return nil, nil, err
+	}
+	return bc, ledger, nil
+}
+
+// openChainFile replays the chain file of dataDir into the store of the ledger, or creates a new chain
+func openChainFile(dataDir string, ledger *Ledger) (*Blockchain, error) {
+	path := filepath.Join(dataDir, PERSISTED_BLOCKCHAIN_FILE)
+	blocks, err := ReadBlocksFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println("No chain found in", dataDir, "- starting from genesis")
+		return CreateNewBlockchain(ledger), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loading %v blocks from %v", len(blocks), path)
+	bc, err := ReplayBlocks(blocks, ledger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chain file %v: %w", path, err)
+	}
+	return bc, nil
+}
+
+// recover completes the adding of the tip, when the node stopped between the batches it
+// writes: the snapshot due after it and the settlements it triggers. Both are skipped when done
+func (bc *Blockchain) recover(ledger *Ledger) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	tip := bc.getLastBlock()
+	if snapshot := bc.store.Snapshot(); snapshot == nil || snapshot.Height != tip.Height {
+		bc.takeSnapshotIfDue(ledger)
+	}
+	bc.settleAfter(tip, ledger)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"solvernet/client"
+)
+
+// Auditing an exported chain. Every block is checked against the chain before it, as it
+// was when the block was added, so one bad block does not hide the ones after it. The blocks
+// of a network with validators are also checked to be proposed and committed by them.
+// The chain is then replayed from genesis, which regenerates the payouts
+
+// InvalidBlock is a block that should not have been added to the chain
+type InvalidBlock struct {
+	Height int
+	Reason error
+}
+
+// ChainVerification is the outcome of verifying a chain
+type ChainVerification struct {
+	Blocks        int
+	InvalidBlocks []InvalidBlock
+	Divergence    *ChainDivergence // first block where the replay differs from the chain, nil when it matches
+	Ledger        *Ledger          // rebuilt from the chain, nil when it cannot be
+}
+
+// BalanceMismatch is an address whose balance differs between two ledgers
+type BalanceMismatch struct {
+	Address  string
+	Expected float64
+	Actual   float64
+}
+
+// VerifyBlocks checks the links and data of every block and replays the chain. With
+// validators, every block but the payouts must be proposed and committed by them
+func VerifyBlocks(blocks []Block, validators *ValidatorSet) ChainVerification {
+	verification := ChainVerification{Blocks: len(blocks), InvalidBlocks: make([]InvalidBlock, 0)}
+
+	// history holds the blocks as they are in the chain, valid or not
+	ledger := NewLedger()
+	history := newBlockchain(ledger)
+	history.validators = validators
+	genesisHash := GenesisHash()
+	for height, block := range blocks {
+		if blockDataMissing(block) {
+			// the blocks after it cannot be checked against a chain holding a block without data
+			verification.InvalidBlocks = append(verification.InvalidBlocks, InvalidBlock{height, errors.New("missing block data. The blocks after it were not checked")})
+			break
+		}
+
+		var err error
+		if !history.isNewBlockCorrectlyChained(block) {
+			err = fmt.Errorf("%w to block %v", ErrBlockNotChained, height-1)
+		} else if height == 0 && block.Hash != genesisHash {
+			err = errors.New("genesis block does not match")
+		} else if timestampErr := history.validateTimestamp(block); timestampErr != nil {
+			err = timestampErr
+		} else if block.StateRoot != history.expectedStateRoot(height) {
+			err = ErrInvalidStateRoot
+		} else if height > 0 {
+			err = history.validateProposer(block)
+			if err == nil {
+				err = history.validateCommit(block)
+			}
+			if err == nil {
+				err = history.validateBlockData(block, ledger, 0)
+			}
+		}
+		if err != nil {
+			verification.InvalidBlocks = append(verification.InvalidBlocks, InvalidBlock{height, err})
+		}
+
+		// at its position in the chain, whatever height it claims. A block without data changes no balance
+		block.Height = height
+		batch, _ := history.blockBatch(block, ledger)
+		history.writeBlock(batch)
+		history.takeSnapshotIfDue(ledger)
+	}
+
+	if _, err := ReplayBlocks(blocks, NewLedger()); err != nil {
+		var divergence *ChainDivergence
+		if !errors.As(err, &divergence) {
+			divergence = &ChainDivergence{0, err}
+		}
+		verification.Divergence = divergence
+	}
+
+	ledger, err := CreateLedgerFromBlockchain(history)
+	if err != nil {
+		if verification.Divergence == nil {
+			verification.Divergence = &ChainDivergence{len(blocks) - 1, fmt.Errorf("the ledger cannot be rebuilt: %w", err)}
+		}
+		return verification
+	}
+	verification.Ledger = ledger
+	return verification
+}
+
+// CompareBalances returns the addresses whose balance in actual differs from expected.
+// Addresses missing from a ledger have the initial balance
+func CompareBalances(expected map[string]float64, actual map[string]float64) []BalanceMismatch {
+	addresses := make(map[string]bool)
+	for address := range expected {
+		addresses[address] = true
+	}
+	for address := range actual {
+		addresses[address] = true
+	}
+
+	balance := func(balances map[string]float64, address string) float64 {
+		if value, exists := balances[address]; exists {
+			return value
+		}
+		return ADDRESS_INITIAL_BALANCE
+	}
+	mismatches := make([]BalanceMismatch, 0)
+	for address := range addresses {
+		mismatch := BalanceMismatch{address, balance(expected, address), balance(actual, address)}
+		if math.Abs(mismatch.Expected-mismatch.Actual) > 1e-9 {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Address < mismatches[j].Address })
+	return mismatches
+}
+
+// fromClientBlocks converts the blocks returned by the API client
+func fromClientBlocks(clientBlocks []client.Block) ([]Block, error) {
+	var blocks []Block
+	err := convertJSON(clientBlocks, &blocks)
+	return blocks, err
+}
+
+// convertJSON converts a value of the API client to the matching type of the node
+func convertJSON(from interface{}, to interface{}) error {
+	bytes, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, to)
+}
+
+func runChainVerify(command *command, args []string) error {
+	flags := newFlags(command)
+	nodeURL := flags.String("node", envOrDefault("SOLVERNET_NODE", DEFAULT_NODE_URL), "URL of a node to compare the chain and balances with")
+	validatorList := flags.String("validators", "", "comma separated validator set of the chain, as address@url. Defaults to the validators of the node when its chain is verified")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	SetLogLevel("warn")
+
+	// without a file, the chain of the node is verified
+	path := flags.Arg(0)
+	compare := path == "" || isFlagSet(flags, "node")
+	ctx := context.Background()
+	node := client.New(*nodeURL)
+
+	var blocks []Block
+	var err error
+	if path != "" {
+		blocks, err = ReadBlocksFile(path)
+		if err != nil {
+			return err
+		}
+	} else {
+		clientBlocks, err := node.GetBlockchain(ctx)
+		if err != nil {
+			return err
+		}
+		if blocks, err = fromClientBlocks(clientBlocks); err != nil {
+			return err
+		}
+		path = *nodeURL
+	}
+	if len(blocks) == 0 {
+		return errors.New("the chain has no blocks")
+	}
+
+	validators, err := chainValidators(ctx, node, *validatorList, compare)
+	if err != nil {
+		return err
+	}
+	verification := VerifyBlocks(blocks, validators)
+	failed := false
+	tip := blocks[len(blocks)-1]
+	fmt.Printf("chain:    %v blocks from %v, tip %v %v\n", len(blocks), path, tip.Height, tip.Hash)
+	if validators != nil {
+		fmt.Printf("commits:  checked against %v validators\n", len(validators.Validators()))
+	}
+
+	if len(verification.InvalidBlocks) == 0 {
+		fmt.Println("blocks:   every block is chained and valid at its height")
+	}
+	for _, invalid := range verification.InvalidBlocks {
+		fmt.Printf("blocks:   INVALID block %v: %v\n", invalid.Height, invalid.Reason)
+		failed = true
+	}
+
+	if verification.Divergence == nil {
+		fmt.Println("replay:   the replayed chain and its payouts match")
+	} else {
+		fmt.Printf("replay:   DIVERGES at block %v: %v\n", verification.Divergence.Height, verification.Divergence.Reason)
+		failed = true
+	}
+
+	if compare {
+		nodeFailed, err := compareWithNode(ctx, node, *nodeURL, blocks, verification.Ledger)
+		if err != nil {
+			return err
+		}
+		failed = failed || nodeFailed
+	}
+
+	if failed {
+		return errors.New("the chain did not verify")
+	}
+	return nil
+}
+
+// chainValidators returns the validator set given as address@url, or the one of the node when
+// fromNode. It is nil for a chain without validators
+func chainValidators(ctx context.Context, node *client.Client, list string, fromNode bool) (*ValidatorSet, error) {
+	if list != "" {
+		return ParseValidators(splitList(list))
+	}
+	if !fromNode {
+		return nil, nil
+	}
+	schedule, err := node.GetValidators(ctx)
+	var apiError *client.APIError
+	if errors.As(err, &apiError) && apiError.Code == "no_validators" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, 0, len(schedule.Validators))
+	for _, validator := range schedule.Validators {
+		entries = append(entries, validator.Address+"@"+validator.URL)
+	}
+	return ParseValidators(entries)
+}
+
+// compareWithNode reports where the chain forks from the chain of a node, and the
+// balances of the rebuilt ledger that differ from the ledger of the node
+func compareWithNode(ctx context.Context, node *client.Client, nodeURL string, blocks []Block, ledger *Ledger) (bool, error) {
+	failed := false
+
+	clientBlocks, err := node.GetBlockchain(ctx)
+	if err != nil {
+		return false, err
+	}
+	nodeBlocks, err := fromClientBlocks(clientBlocks)
+	if err != nil {
+		return false, err
+	}
+	common := commonPrefix(blocks, nodeBlocks)
+	switch {
+	case common == len(blocks) && common == len(nodeBlocks):
+		fmt.Printf("node:     %v has the same chain\n", nodeURL)
+	case common == len(blocks) || common == len(nodeBlocks):
+		fmt.Printf("node:     %v has the same blocks up to %v, at height %v\n", nodeURL, common-1, len(nodeBlocks)-1)
+	default:
+		fmt.Printf("node:     DIVERGES from %v at block %v\n", nodeURL, common)
+		failed = true
+	}
+
+	if ledger == nil {
+		fmt.Printf("balances: NOT COMPARED with %v, the ledger cannot be rebuilt from the chain\n", nodeURL)
+		return true, nil
+	}
+	nodeLedger, err := node.GetLedger(ctx)
+	if err != nil {
+		return false, err
+	}
+	mismatches := CompareBalances(ledger.Balances(), nodeLedger.AddressToBalance)
+	if len(mismatches) == 0 {
+		fmt.Printf("balances: the rebuilt ledger matches the ledger of %v\n", nodeURL)
+	}
+	for _, mismatch := range mismatches {
+		fmt.Printf("balances: MISMATCH %v: %v in the chain, %v on the node\n", mismatch.Address, mismatch.Expected, mismatch.Actual)
+		failed = true
+	}
+	return failed, nil
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"solvernet/client"
+)
+
+// Command line interface. The node commands run a node, the wallet commands talk
+// to a node through its API and sign what they send with the key file created by keygen
+
+type command struct {
+	name        string
+	usage       string
+	summary     string
+	run         func(command *command, args []string) error
+	subcommands []command // set for groups of commands, which have no run
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "node", summary: "runs a node", subcommands: []command{
+			{"run", "node run [config flags]", "runs a node", runNodeRun, nil},
+			{"init", "node init [-force] [config flags]", "writes a config file and creates the data dir", runNodeInit, nil},
+		}},
+		{name: "chain", summary: "exports, imports, audits and bootstraps chains", subcommands: []command{
+			{"verify", "chain verify [-node url] [-validators list] [file]", "verifies a chain file, or the chain of the node, and compares it with the node", runChainVerify, nil},
+			{"export", "chain export [-node url | -data-dir dir] [-gzip] [-force] file", "writes the chain of a node, or of a data dir, to an archive", runChainExport, nil},
+			{"import", "chain import [-node url | -data-dir dir] file", "adds the blocks of an archive to the chain of a node, or of a stopped node's data dir", runChainImport, nil},
+			{"bootstrap", "chain bootstrap [-node url] [-data-dir dir]", "sets up the data dir of a new node from the latest snapshot of a node and the blocks after it", runChainBootstrap, nil},
+		}},
+		{"devnet", "devnet [-nodes n] [-base-port port] [-data-dir dir] [-mining] [-log-level level] [-reset]", "runs a local network of n nodes, one child process each", runDevnet, nil},
+		{"sim", "sim [-nodes n] [-seed seed] [-duration d] [-min-latency d] [-max-latency d] [-drop rate] [-partition 0,1|2,3] [-partition-from d] [-partition-until d]", "simulates a network of nodes in process on virtual time", runSim, nil},
+		{"keygen", "keygen [-key file] [-force]", "creates a key pair and prints its address", runKeygen, nil},
+		{"balance", "balance [-node url] [-key file] [address]", "prints the balance and nonce of an address, by default the key address", runBalance, nil},
+		{"transfer", "transfer [-node url] [-key file] -to address -amount amount", "sends tokens from the key address", runTransfer, nil},
+		{"submit-problem", "submit-problem [-node url] [-key file] [-bounty bounty] [-policy policy] file", "submits a problem from a JSON or knapsack instance file", runSubmitProblem, nil},
+		{"submit-solution", "submit-solution [-node url] [-key file] -problem height -items 0,2,5", "submits a proposed solution, computing its value", runSubmitSolution, nil},
+		{"watch-problem", "watch-problem [-node url] height", "follows a problem until it is settled or expires", runWatchProblem, nil},
+		{"blocks", "blocks [-node url] [-from height] [-limit limit]", "lists blocks, by default the most recent ones", runBlocks, nil},
+		{"help", "help", "prints this help", runHelp, nil},
+	}
+}
+
+// findCommand returns the command named by the first args, or nil, along with its arguments
+func findCommand(args []string) (*command, []string) {
+	list := commands
+	for i, name := range args {
+		var found *command
+		for j := range list {
+			if list[j].name == name {
+				found = &list[j]
+			}
+		}
+		if found == nil {
+			return nil, nil
+		}
+		if found.subcommands == nil || i == len(args)-1 {
+			return found, args[i+1:]
+		}
+		list = found.subcommands
+	}
+	return nil, nil
+}
+
+func runHelp(command *command, args []string) error {
+	fmt.Println("usage: solvernet <command> [arguments]")
+	fmt.Println("\ncommands:")
+	for _, command := range commands {
+		if command.subcommands == nil {
+			fmt.Printf("  %-16v %v\n", command.name, command.summary)
+			continue
+		}
+		for _, subcommand := range command.subcommands {
+			fmt.Printf("  %-16v %v\n", command.name+" "+subcommand.name, subcommand.summary)
+		}
+	}
+	fmt.Println("\nRun solvernet <command> -h for the arguments of a command")
+	return nil
+}
+
+// runCommand runs a CLI command and returns the process exit code
+func runCommand(command *command, args []string) int {
+	if command.run == nil {
+		fmt.Fprintf(os.Stderr, "usage: solvernet %v <command>\n\ncommands:\n", command.name)
+		for _, subcommand := range command.subcommands {
+			fmt.Fprintf(os.Stderr, "  %-16v %v\n", subcommand.name, subcommand.summary)
+		}
+		return 2
+	}
+	if err := command.run(command, args); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// commandOptions holds the flags shared by the commands
+type commandOptions struct {
+	nodeURL string
+	keyFile string
+}
+
+func newFlags(command *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: solvernet", command.usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// newWalletFlags creates the flags of a wallet command, with the node and key file flags
+func newWalletFlags(command *command, options *commandOptions) *flag.FlagSet {
+	flags := newFlags(command)
+	flags.StringVar(&options.nodeURL, "node", envOrDefault("SOLVERNET_NODE", DEFAULT_NODE_URL), "URL of the node API")
+	flags.StringVar(&options.keyFile, "key", envOrDefault("SOLVERNET_KEY", DEFAULT_KEY_FILE), "key file")
+	return flags
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (options *commandOptions) client() *client.Client {
+	return client.New(options.nodeURL)
+}
+
+// nextNonce returns the nonce of the next submission of address
+func nextNonce(ctx context.Context, node *client.Client, address string) (int, error) {
+	account, err := node.GetAccount(ctx, address, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	// submissions still in the mempool of a network with validators count too
+	return account.PendingNonce + 1, nil
+}
+
+func runKeygen(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	force := flags.Bool("force", false, "overwrite an existing key file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(options.keyFile); err == nil && !*force {
+		return fmt.Errorf("%v already exists. Use -force to overwrite it", options.keyFile)
+	}
+	key, err := client.GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := key.Save(options.keyFile); err != nil {
+		return err
+	}
+	fmt.Println(key.Address)
+	return nil
+}
+
+func runBalance(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	address := flags.Arg(0)
+	if address == "" {
+		key, err := client.LoadKey(options.keyFile)
+		if err != nil {
+			return err
+		}
+		address = key.Address
+	}
+
+	account, err := options.client().GetAccount(context.Background(), address, 0, 1)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("address:         %v\n", account.Address)
+	fmt.Printf("balance:         %v\n", account.Balance)
+	fmt.Printf("escrowed bounty: %v\n", account.EscrowedBounty)
+	fmt.Printf("nonce:           %v\n", account.Nonce)
+	return nil
+}
+
+func runTransfer(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	to := flags.String("to", "", "recipient address")
+	amount := flags.Float64("amount", 0, "amount to send")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" || *amount <= 0 {
+		flags.Usage()
+		return errors.New("-to and a positive -amount are required")
+	}
+
+	key, err := client.LoadKey(options.keyFile)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	node := options.client()
+	nonce, err := nextNonce(ctx, node, key.Address)
+	if err != nil {
+		return err
+	}
+
+	tx := client.Transaction{To: *to, Amount: *amount, Nonce: nonce}
+	if err := key.SignTransaction(&tx); err != nil {
+		return err
+	}
+	block, err := node.SendTransaction(ctx, tx)
+	if err != nil {
+		return pendingOrError(err, "transfer")
+	}
+	fmt.Printf("sent %v to %v in block %v (%v)\n", tx.Amount, tx.To, block.Height, block.Hash)
+	return nil
+}
+
+func runSubmitProblem(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	bounty := flags.Float64("bounty", 0, "bounty paid to the solvers. Overrides the bounty of a JSON file")
+	policy := flags.String("policy", "", "payout policy: winner_takes_all or proportional")
+	expiry := flags.Int("expiry", 0, "seconds solutions are accepted for, within the window of the problem. Overrides the expiry of a JSON file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a problem file is required")
+	}
+
+	problem, err := ReadProblemFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *bounty > 0 {
+		problem.Bounty = *bounty
+	}
+	if *policy != "" {
+		problem.PayoutPolicy = *policy
+	}
+	if *expiry > 0 {
+		problem.ExpirySeconds = *expiry
+	}
+	if problem.Bounty <= 0 {
+		return errors.New("a bounty is required. Use -bounty")
+	}
+
+	key, err := client.LoadKey(options.keyFile)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	node := options.client()
+	if problem.Nonce, err = nextNonce(ctx, node, key.Address); err != nil {
+		return err
+	}
+	if err := key.SignProblem(problem); err != nil {
+		return err
+	}
+
+	block, err := node.SendProblem(ctx, *problem)
+	if err != nil {
+		return pendingOrError(err, "problem")
+	}
+	fmt.Printf("problem with %v items submitted in block %v. Solutions are accepted until height %v\n",
+		len(problem.Items), block.Height, problemExpiryHeight(block.Height))
+	return nil
+}
+
+func runSubmitSolution(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	problemHeight := flags.Int("problem", -1, "height of the problem block")
+	itemList := flags.String("items", "", "comma separated indexes of the chosen items")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *problemHeight < 0 || *itemList == "" {
+		flags.Usage()
+		return errors.New("-problem and -items are required")
+	}
+
+	solution := client.KnapsackProposedSolution{ProblemBlockHeight: *problemHeight}
+	for _, field := range strings.Split(*itemList, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return fmt.Errorf("invalid item index %q", field)
+		}
+		solution.ItemIndexes = append(solution.ItemIndexes, index)
+	}
+
+	key, err := client.LoadKey(options.keyFile)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	node := options.client()
+	history, err := node.GetProblem(ctx, *problemHeight)
+	if err != nil {
+		return err
+	}
+	weight := 0
+	for _, index := range solution.ItemIndexes {
+		if index < 0 || index >= len(history.Problem.Items) {
+			return fmt.Errorf("item index %v out of range. The problem has %v items", index, len(history.Problem.Items))
+		}
+		weight += history.Problem.Items[index].Weight
+		solution.Value += history.Problem.Items[index].Value
+	}
+	if weight > history.Problem.Capacity {
+		return fmt.Errorf("items weigh %v, over the capacity of %v", weight, history.Problem.Capacity)
+	}
+
+	if solution.Nonce, err = nextNonce(ctx, node, key.Address); err != nil {
+		return err
+	}
+	if err := key.SignProposedSolution(&solution); err != nil {
+		return err
+	}
+	block, err := node.SendProposedSolution(ctx, solution)
+	if err != nil {
+		return pendingOrError(err, "solution")
+	}
+	fmt.Printf("solution with value %v submitted in block %v\n", solution.Value, block.Height)
+	return nil
+}
+
+// pendingOrError reports a submission pending in the mempool of a validator, which is not a failure
+func pendingOrError(err error, submission string) error {
+	var pending *client.PendingError
+	if !errors.As(err, &pending) {
+		return err
+	}
+	fmt.Printf("%v pending in the mempool as entry %v. It is added to a block once its proposer gets it\n", submission, pending.Entry.ID)
+	return nil
+}
+
+func runWatchProblem(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	height, err := strconv.Atoi(flags.Arg(0))
+	if flags.NArg() != 1 || err != nil {
+		flags.Usage()
+		return errors.New("a problem height is required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	node := options.client()
+	// subscribe before fetching the problem, so that it cannot be settled in between unseen
+	types := []string{string(LeaderChangedEvent), string(ProblemSettledEvent), string(ProblemExpiredEvent)}
+	stream, err := node.SubscribeEvents(ctx, types, "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	history, err := node.GetProblem(ctx, height)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("problem %v: %v items, capacity %v, bounty %v, %v until height %v\n",
+		height, len(history.Problem.Items), history.Problem.Capacity, history.Problem.Bounty, history.Status, history.ExpiryHeight)
+	if history.ExpiresAt > 0 {
+		fmt.Printf("solutions accepted until %v\n", formatTimestamp(history.ExpiresAt))
+	}
+	if history.Leader != nil {
+		fmt.Printf("leader: %v with value %v\n", history.Leader.Solution.Address, history.Leader.Solution.Value)
+	}
+	if history.Status != string(ProblemOpen) && history.Status != string(ProblemClosed) {
+		printPayout(history.Payout)
+		return nil
+	}
+
+	for {
+		event, err := stream.Next()
+		if err == io.EOF || errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.ProblemBlockHeight == nil || *event.ProblemBlockHeight != height {
+			continue
+		}
+		switch EventType(event.Type) {
+		case LeaderChangedEvent:
+			var solution client.KnapsackProposedSolution
+			if err := json.Unmarshal(event.Data, &solution); err != nil {
+				return err
+			}
+			fmt.Printf("block %v: new leader %v with value %v\n", event.BlockHeight, solution.Address, solution.Value)
+		case ProblemSettledEvent:
+			var payout client.BountyPayout
+			if err := json.Unmarshal(event.Data, &payout); err != nil {
+				return err
+			}
+			fmt.Printf("block %v: settled\n", event.BlockHeight)
+			printPayout(&payout)
+			return nil
+		case ProblemExpiredEvent:
+			fmt.Printf("block %v: expired without solutions\n", event.BlockHeight)
+			return nil
+		}
+	}
+}
+
+func printPayout(payout *client.BountyPayout) {
+	if payout == nil {
+		return
+	}
+	for _, tx := range payout.Transactions {
+		fmt.Printf("paid %v to %v\n", tx.Amount, tx.To)
+	}
+}
+
+func runBlocks(command *command, args []string) error {
+	var options commandOptions
+	flags := newWalletFlags(command, &options)
+	from := flags.Int("from", -1, "height of the first block. Defaults to the most recent blocks")
+	limit := flags.Int("limit", DEFAULT_BLOCKS_PAGE_LIMIT, "number of blocks")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	node := options.client()
+	if *from < 0 {
+		head, err := node.GetHead(ctx)
+		if err != nil {
+			return err
+		}
+		*from = max(0, head.Height-*limit+1)
+	}
+	page, err := node.GetBlocks(ctx, *from, *limit)
+	if err != nil {
+		return err
+	}
+	for _, block := range page.Blocks {
+		fmt.Printf("%6v  %.12v  %-19v  %v\n", block.Height, block.Hash, formatTimestamp(block.Timestamp), describeBlock(block))
+	}
+	return nil
+}
+
+// formatTimestamp formats a timestamp in Unix milliseconds as a UTC time, or - when unset
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.UnixMilli(timestamp).UTC().Format(time.DateTime)
+}
+
+// describeBlock returns a one line summary of a block
+func describeBlock(block client.Block) string {
+	data := block.Data
+	switch {
+	case data.Transaction != nil:
+		return fmt.Sprintf("transfer   %v -> %v: %v", data.Transaction.From, data.Transaction.To, data.Transaction.Amount)
+	case data.Problem != nil:
+		return fmt.Sprintf("problem    by %v: %v items, capacity %v, bounty %v", data.Problem.Address, len(data.Problem.Items), data.Problem.Capacity, data.Problem.Bounty)
+	case data.Solution != nil:
+		return fmt.Sprintf("solution   by %v to problem %v: value %v", data.Solution.Address, data.Solution.ProblemBlockHeight, data.Solution.Value)
+	case data.Payout != nil:
+		return fmt.Sprintf("payout     of problem %v: %v transactions", data.Payout.ProblemBlockHeight, len(data.Payout.Transactions))
+	}
+	return fmt.Sprintf("type %v", data.Type)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// Clock is the time source of a node, so that the simulator can run it on virtual time
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time        { return time.Now() }
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// SimClock is a virtual clock. It only moves when the simulator advances it or
+// a sleep moves it forward, and never blocks
+type SimClock struct {
+	now time.Time
+}
+
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+func (c *SimClock) Now() time.Time        { return c.now }
+func (c *SimClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+// AdvanceTo moves the clock to t, unless it is already past it
+func (c *SimClock) AdvanceTo(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config is the configuration of a node. It is layered: the defaults, then the
+// config file, then the SOLVERNET_* environment variables, then the command line flags
+type Config struct {
+	Listen      string   `json:"listen"`       // address the API listens on, e.g. ":3001"
+	DataDir     string   `json:"data_dir"`     // where the chain is persisted
+	Peers       []string `json:"peers"`        // API URLs of the other nodes
+	CORSOrigins []string `json:"cors_origins"` // origins allowed to call the API from a browser
+	Mining      bool     `json:"mining"`       // submit random problems and solutions to the peers
+	LogLevel    string   `json:"log_level"`    // debug, info, warn or error
+	PruneDepth  int      `json:"prune_depth"`  // blocks below the tip whose bodies are kept. 0 keeps every body
+	// average seconds between the submissions of a mining node, and the expiry in seconds of
+	// the problems it submits. An expiry of 0 accepts solutions for the whole window
+	BlockInterval int `json:"block_interval"`
+	ProblemExpiry int `json:"problem_expiry"`
+	// validator set of the network, as address@url, and the key file of the node when it is one
+	// of them. Without validators, every node adds the submissions it receives at its own tip
+	Validators   []string `json:"validators"`
+	ValidatorKey string   `json:"validator_key"`
+	// limits of the API, see Limits.go. 0 disables a limit
+	MaxBodyBytes        int64   `json:"max_body_bytes"`
+	MaxProblemItems     int     `json:"max_problem_items"`
+	MaxNumber           int     `json:"max_number"` // largest weight, value or capacity of a problem submitted
+	MaxSolutionItems    int     `json:"max_solution_items"`
+	RateLimit           float64 `json:"rate_limit"` // requests per second of a client IP
+	RateBurst           int     `json:"rate_burst"`
+	MaxConnections      int     `json:"max_connections"`
+	MaxConnectionsPerIP int     `json:"max_connections_per_ip"`
+	MaxImportBlocks     int     `json:"max_import_blocks"` // blocks of an archive imported through the API, from localhost only
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:        DEFAULT_LISTEN_ADDRESS,
+		DataDir:       DEFAULT_DATA_DIR,
+		Peers:         []string{},
+		CORSOrigins:   []string{"*"},
+		Mining:        false,
+		LogLevel:      "info",
+		PruneDepth:    0,
+		BlockInterval: DEFAULT_BLOCK_INTERVAL,
+		ProblemExpiry: 0,
+		Validators:    []string{},
+		ValidatorKey:  "",
+
+		MaxBodyBytes:        DEFAULT_MAX_BODY_BYTES,
+		MaxProblemItems:     DEFAULT_MAX_PROBLEM_ITEMS,
+		MaxNumber:           DEFAULT_MAX_NUMBER,
+		MaxSolutionItems:    DEFAULT_MAX_SOLUTION_ITEMS,
+		RateLimit:           DEFAULT_RATE_LIMIT,
+		RateBurst:           DEFAULT_RATE_BURST,
+		MaxConnections:      DEFAULT_MAX_CONNECTIONS,
+		MaxConnectionsPerIP: DEFAULT_MAX_CONNECTIONS_PER_IP,
+		MaxImportBlocks:     DEFAULT_MAX_IMPORT_BLOCKS,
+	}
+}
+
+// LoadConfigFile overrides config with the fields set in the JSON file at path
+func (config *Config) LoadConfigFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	// an empty file sets nothing
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config file %v: %w", path, err)
+	}
+	return nil
+}
+
+// SaveConfigFile writes config to path
+func (config *Config) SaveConfigFile(path string) error {
+	bytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bytes, '\n'), 0644)
+}
+
+// ApplyEnv overrides config with the SOLVERNET_* environment variables that are set
+func (config *Config) ApplyEnv() error {
+	if value, exists := os.LookupEnv("SOLVERNET_LISTEN"); exists {
+		config.Listen = value
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_DATA_DIR"); exists {
+		config.DataDir = value
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_PEERS"); exists {
+		config.Peers = splitList(value)
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_CORS_ORIGINS"); exists {
+		config.CORSOrigins = splitList(value)
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_MINING"); exists {
+		mining, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_MINING %q", value)
+		}
+		config.Mining = mining
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_LOG_LEVEL"); exists {
+		config.LogLevel = value
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_PRUNE_DEPTH"); exists {
+		depth, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_PRUNE_DEPTH %q", value)
+		}
+		config.PruneDepth = depth
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_BLOCK_INTERVAL"); exists {
+		interval, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_BLOCK_INTERVAL %q", value)
+		}
+		config.BlockInterval = interval
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_PROBLEM_EXPIRY"); exists {
+		expiry, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_PROBLEM_EXPIRY %q", value)
+		}
+		config.ProblemExpiry = expiry
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_VALIDATORS"); exists {
+		config.Validators = splitList(value)
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_VALIDATOR_KEY"); exists {
+		config.ValidatorKey = value
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_MAX_BODY_BYTES"); exists {
+		maxBodyBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_MAX_BODY_BYTES %q", value)
+		}
+		config.MaxBodyBytes = maxBodyBytes
+	}
+	for name, field := range map[string]*int{
+		"SOLVERNET_MAX_PROBLEM_ITEMS":      &config.MaxProblemItems,
+		"SOLVERNET_MAX_NUMBER":             &config.MaxNumber,
+		"SOLVERNET_MAX_SOLUTION_ITEMS":     &config.MaxSolutionItems,
+		"SOLVERNET_RATE_BURST":             &config.RateBurst,
+		"SOLVERNET_MAX_CONNECTIONS":        &config.MaxConnections,
+		"SOLVERNET_MAX_CONNECTIONS_PER_IP": &config.MaxConnectionsPerIP,
+		"SOLVERNET_MAX_IMPORT_BLOCKS":      &config.MaxImportBlocks,
+	} {
+		if value, exists := os.LookupEnv(name); exists {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %v %q", name, value)
+			}
+			*field = number
+		}
+	}
+	if value, exists := os.LookupEnv("SOLVERNET_RATE_LIMIT"); exists {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOLVERNET_RATE_LIMIT %q", value)
+		}
+		config.RateLimit = rate
+	}
+	return nil
+}
+
+// configFlags are the command line flags of the config. Only the flags that are set override it
+type configFlags struct {
+	configFile    *string
+	listen        *string
+	dataDir       *string
+	peers         *string
+	corsOrigins   *string
+	mining        *bool
+	logLevel      *string
+	pruneDepth    *int
+	blockInterval *int
+	problemExpiry *int
+	validators    *string
+	validatorKey  *string
+
+	maxBodyBytes        *int64
+	maxProblemItems     *int
+	maxNumber           *int
+	maxSolutionItems    *int
+	rateLimit           *float64
+	rateBurst           *int
+	maxConnections      *int
+	maxConnectionsPerIP *int
+	maxImportBlocks     *int
+}
+
+func newConfigFlags(flags *flag.FlagSet) *configFlags {
+	return &configFlags{
+		configFile:    flags.String("config", envOrDefault("SOLVERNET_CONFIG", DEFAULT_CONFIG_FILE), "config file. Ignored when missing, unless set explicitly"),
+		listen:        flags.String("listen", "", "address the API listens on (default "+DEFAULT_LISTEN_ADDRESS+")"),
+		dataDir:       flags.String("data-dir", "", "directory the chain is persisted in (default "+DEFAULT_DATA_DIR+")"),
+		peers:         flags.String("peers", "", "comma separated API URLs of the other nodes"),
+		corsOrigins:   flags.String("cors-origins", "", "comma separated origins allowed to call the API (default *)"),
+		mining:        flags.Bool("mining", false, "submit random problems and solutions to the peers"),
+		logLevel:      flags.String("log-level", "", "debug, info, warn or error (default info)"),
+		pruneDepth:    flags.Int("prune-depth", 0, fmt.Sprintf("keep the bodies of this many blocks below the tip, at least %v. 0 keeps every body", NUMBER_OF_BLOCKS_TO_SOLUTION)),
+		blockInterval: flags.Int("block-interval", 0, fmt.Sprintf("average seconds between the submissions of a mining node (default %v)", DEFAULT_BLOCK_INTERVAL)),
+		problemExpiry: flags.Int("problem-expiry", 0, "seconds the problems submitted by a mining node accept solutions for. 0 accepts them for the whole window"),
+		validators:    flags.String("validators", "", "comma separated validator set, as address@url. Blocks are then made by the proposer of their height"),
+		validatorKey:  flags.String("validator-key", "", "key file of the node, when it is a validator"),
+
+		maxBodyBytes:        flags.Int64("max-body-bytes", 0, fmt.Sprintf("largest request body accepted, archives imported aside. 0 accepts any (default %v)", DEFAULT_MAX_BODY_BYTES)),
+		maxProblemItems:     flags.Int("max-problem-items", 0, fmt.Sprintf("most items of a problem submitted, up to %v. 0 accepts up to it (default %v)", MAX_PROBLEM_ITEMS, DEFAULT_MAX_PROBLEM_ITEMS)),
+		maxNumber:           flags.Int("max-number", 0, fmt.Sprintf("largest weight, value or capacity of a problem submitted, up to %v. 0 accepts up to it (default %v)", MAX_PROBLEM_NUMBER, DEFAULT_MAX_NUMBER)),
+		maxSolutionItems:    flags.Int("max-solution-items", 0, fmt.Sprintf("most items of a proposed solution submitted. 0 accepts up to %v (default %v)", MAX_PROBLEM_ITEMS, DEFAULT_MAX_SOLUTION_ITEMS)),
+		rateLimit:           flags.Float64("rate-limit", 0, fmt.Sprintf("requests per second accepted from a client IP. 0 disables rate limiting (default %v)", DEFAULT_RATE_LIMIT)),
+		rateBurst:           flags.Int("rate-burst", 0, fmt.Sprintf("requests a client IP may send at once above the rate (default %v)", DEFAULT_RATE_BURST)),
+		maxConnections:      flags.Int("max-connections", 0, fmt.Sprintf("most connections held. 0 accepts any (default %v)", DEFAULT_MAX_CONNECTIONS)),
+		maxConnectionsPerIP: flags.Int("max-connections-per-ip", 0, fmt.Sprintf("most connections held from a client IP. 0 accepts any (default %v)", DEFAULT_MAX_CONNECTIONS_PER_IP)),
+		maxImportBlocks:     flags.Int("max-import-blocks", 0, fmt.Sprintf("most blocks of an archive imported through the API, from localhost. 0 accepts any (default %v)", DEFAULT_MAX_IMPORT_BLOCKS)),
+	}
+}
+
+// LoadConfig builds the config of the parsed flags: the defaults, the config file
+// (unless readFile is false), the environment and the flags
+func (configFlags *configFlags) LoadConfig(flags *flag.FlagSet, readFile bool) (Config, error) {
+	config := DefaultConfig()
+
+	explicitFile := isFlagSet(flags, "config") || os.Getenv("SOLVERNET_CONFIG") != ""
+	if readFile {
+		if err := config.LoadConfigFile(*configFlags.configFile); err != nil && (explicitFile || !errors.Is(err, os.ErrNotExist)) {
+			return config, err
+		}
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return config, err
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.Listen = *configFlags.listen
+		case "data-dir":
+			config.DataDir = *configFlags.dataDir
+		case "peers":
+			config.Peers = splitList(*configFlags.peers)
+		case "cors-origins":
+			config.CORSOrigins = splitList(*configFlags.corsOrigins)
+		case "mining":
+			config.Mining = *configFlags.mining
+		case "log-level":
+			config.LogLevel = *configFlags.logLevel
+		case "prune-depth":
+			config.PruneDepth = *configFlags.pruneDepth
+		case "block-interval":
+			config.BlockInterval = *configFlags.blockInterval
+		case "problem-expiry":
+			config.ProblemExpiry = *configFlags.problemExpiry
+		case "validators":
+			config.Validators = splitList(*configFlags.validators)
+		case "validator-key":
+			config.ValidatorKey = *configFlags.validatorKey
+		case "max-body-bytes":
+			config.MaxBodyBytes = *configFlags.maxBodyBytes
+		case "max-problem-items":
+			config.MaxProblemItems = *configFlags.maxProblemItems
+		case "max-number":
+			config.MaxNumber = *configFlags.maxNumber
+		case "max-solution-items":
+			config.MaxSolutionItems = *configFlags.maxSolutionItems
+		case "rate-limit":
+			config.RateLimit = *configFlags.rateLimit
+		case "rate-burst":
+			config.RateBurst = *configFlags.rateBurst
+		case "max-connections":
+			config.MaxConnections = *configFlags.maxConnections
+		case "max-connections-per-ip":
+			config.MaxConnectionsPerIP = *configFlags.maxConnectionsPerIP
+		case "max-import-blocks":
+			config.MaxImportBlocks = *configFlags.maxImportBlocks
+		}
+	})
+
+	return config, config.Validate()
+}
+
+// Validate checks every field of the config and reports all the invalid ones
+func (config *Config) Validate() error {
+	problems := make([]string, 0)
+
+	if host, port, err := net.SplitHostPort(config.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid listen address %q: %v", config.Listen, err))
+	} else if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		problems = append(problems, fmt.Sprintf("invalid listen port %q", port))
+	} else if host != "" && net.ParseIP(host) == nil && host != "localhost" {
+		problems = append(problems, fmt.Sprintf("invalid listen host %q", host))
+	}
+
+	if strings.TrimSpace(config.DataDir) == "" {
+		problems = append(problems, "data dir is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, peer := range config.Peers {
+		if !isHTTPURL(peer) {
+			problems = append(problems, fmt.Sprintf("invalid peer %q. Expected an http(s) URL", peer))
+		} else if seen[peer] {
+			problems = append(problems, fmt.Sprintf("duplicate peer %q", peer))
+		}
+		seen[peer] = true
+	}
+
+	for _, origin := range config.CORSOrigins {
+		if origin != "*" && !isHTTPURL(origin) {
+			problems = append(problems, fmt.Sprintf("invalid CORS origin %q. Expected * or an http(s) URL", origin))
+		}
+	}
+
+	if _, exists := logLevels[config.LogLevel]; !exists {
+		problems = append(problems, fmt.Sprintf("invalid log level %q. Expected debug, info, warn or error", config.LogLevel))
+	}
+
+	if config.PruneDepth != 0 && config.PruneDepth < NUMBER_OF_BLOCKS_TO_SOLUTION {
+		problems = append(problems, fmt.Sprintf("invalid prune depth %v. Expected 0 or at least %v, the blocks validation looks at", config.PruneDepth, NUMBER_OF_BLOCKS_TO_SOLUTION))
+	}
+
+	if config.BlockInterval < 1 {
+		problems = append(problems, fmt.Sprintf("invalid block interval %v. Expected at least 1 second", config.BlockInterval))
+	}
+
+	if config.ProblemExpiry < 0 {
+		problems = append(problems, fmt.Sprintf("invalid problem expiry %v. Expected 0 or a number of seconds", config.ProblemExpiry))
+	}
+
+	if _, err := ParseValidators(config.Validators); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if config.ValidatorKey != "" && len(config.Validators) == 0 {
+		problems = append(problems, "a validator key requires validators")
+	}
+
+	if config.MaxBodyBytes < 0 {
+		problems = append(problems, fmt.Sprintf("invalid max body bytes %v. Expected 0 or a number of bytes", config.MaxBodyBytes))
+	}
+	if config.MaxProblemItems < 0 || config.MaxProblemItems > MAX_PROBLEM_ITEMS {
+		problems = append(problems, fmt.Sprintf("invalid max problem items %v. Expected 0 to %v, the most the network accepts", config.MaxProblemItems, MAX_PROBLEM_ITEMS))
+	}
+	if config.MaxNumber < 0 || config.MaxNumber > MAX_PROBLEM_NUMBER {
+		problems = append(problems, fmt.Sprintf("invalid max number %v. Expected 0 to %v, the most the network accepts", config.MaxNumber, MAX_PROBLEM_NUMBER))
+	}
+	if config.MaxSolutionItems < 0 || config.MaxSolutionItems > MAX_PROBLEM_ITEMS {
+		problems = append(problems, fmt.Sprintf("invalid max solution items %v. Expected 0 to %v, the most the network accepts", config.MaxSolutionItems, MAX_PROBLEM_ITEMS))
+	}
+	if config.RateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("invalid rate limit %v. Expected 0 or requests per second", config.RateLimit))
+	} else if config.RateLimit > 0 && config.RateBurst < 1 {
+		problems = append(problems, fmt.Sprintf("invalid rate burst %v. Expected at least 1 with a rate limit", config.RateBurst))
+	}
+	if config.MaxConnections < 0 || config.MaxConnectionsPerIP < 0 {
+		problems = append(problems, fmt.Sprintf("invalid max connections %v and per IP %v. Expected 0 or a number of connections", config.MaxConnections, config.MaxConnectionsPerIP))
+	}
+	if config.MaxImportBlocks < 0 {
+		problems = append(problems, fmt.Sprintf("invalid max import blocks %v. Expected 0 or a number of blocks", config.MaxImportBlocks))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Limits returns the limits of the API of the config
+func (config *Config) Limits() Limits {
+	return Limits{
+		MaxBodyBytes:        config.MaxBodyBytes,
+		MaxProblemItems:     config.MaxProblemItems,
+		MaxNumber:           config.MaxNumber,
+		MaxSolutionItems:    config.MaxSolutionItems,
+		RateLimit:           config.RateLimit,
+		RateBurst:           config.RateBurst,
+		MaxConnections:      config.MaxConnections,
+		MaxConnectionsPerIP: config.MaxConnectionsPerIP,
+		MaxImportBlocks:     config.MaxImportBlocks,
+	}
+}
+
+// ListenPort returns the port of the listen address
+func (config *Config) ListenPort() string {
+	_, port, _ := net.SplitHostPort(config.Listen)
+	return port
+}
+
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
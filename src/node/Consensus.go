@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"solvernet/client"
+)
+
+// The validators decide on each block in rounds, in the style of Tendermint. In every round,
+// the proposer of the round proposes a block. The validators prevote for it, or for no block
+// when it is invalid or they are locked on another block, and precommit for a block once a
+// quorum (more than two thirds) of them prevoted for it, locking on it. A block precommitted
+// by a quorum in any round is final: it is added with the precommits as its commit, and the
+// validators move to the next height. Rounds that fail to decide time out, and the next round
+// has the next proposer, which proposes the block locked on, if any, again. As a validator
+// only precommits another block than the one it locked on after a quorum prevoted for it,
+// two blocks cannot both be final at a height unless more than a third of the validators
+// break the rules. Rounds only run while there are submissions to decide on. A validator logs
+// its lock and its own proposals and votes in its store before sending them, so that after a
+// restart it keeps its lock and sends the same votes again rather than conflicting ones
+
+type VoteType string
+
+const (
+	Prevote   VoteType = "prevote"
+	Precommit VoteType = "precommit"
+)
+
+// Vote is the vote of a validator for a block in a round, or for no block when BlockHash is empty
+type Vote struct {
+	Type      VoteType `json:"type"`
+	Height    int      `json:"height"`
+	Round     int      `json:"round"`
+	BlockHash string   `json:"block_hash,omitempty"`
+	Validator string   `json:"validator"`
+	PublicKey string   `json:"public_key"`
+	Signature string   `json:"signature"`
+}
+
+// Commit is the precommits of a quorum of validators that made a block final
+type Commit struct {
+	Round int    `json:"round"`
+	Votes []Vote `json:"votes"`
+}
+
+// Proposal is a block proposed by the proposer of a round. ValidRound is the round a quorum
+// prevoted for the block in, when it is proposed again, and -1 otherwise. Prevotes are then
+// the prevotes of the quorum, for the validators that missed some of them
+type Proposal struct {
+	Block      Block  `json:"block"`
+	Round      int    `json:"round"`
+	ValidRound int    `json:"valid_round"`
+	Prevotes   []Vote `json:"prevotes,omitempty"`
+	Proposer   string `json:"proposer"`
+	PublicKey  string `json:"public_key"`
+	Signature  string `json:"signature"`
+}
+
+// ConsensusState is the height and round being decided by a validator
+type ConsensusState struct {
+	Height          int       `json:"height"`
+	Round           int       `json:"round"`
+	Step            string    `json:"step"`
+	Proposer        Validator `json:"proposer"`     // of the round
+	LockedRound     int       `json:"locked_round"` // -1 when not locked on a block
+	ValidRound      int       `json:"valid_round"`  // -1 when no block got a quorum of prevotes
+	Prevotes        int       `json:"prevotes"`     // received in the round
+	Precommits      int       `json:"precommits"`
+	FinalizedHeight int       `json:"finalized_height"`
+}
+
+// ConsensusRecord is what a validator logs of the height it decides
+type ConsensusRecord struct {
+	Height      int        `json:"height"`
+	Round       int        `json:"round"`
+	LockedRound int        `json:"locked_round"`
+	LockedBlock *Block     `json:"locked_block,omitempty"`
+	ValidRound  int        `json:"valid_round"`
+	ValidBlock  *Block     `json:"valid_block,omitempty"`
+	Proposals   []Proposal `json:"proposals,omitempty"` // of the validator
+	Votes       []Vote     `json:"votes,omitempty"`     // of the validator
+}
+
+type consensusStep int
+
+const (
+	proposeStep consensusStep = iota
+	prevoteStep
+	precommitStep
+)
+
+var consensusStepNames = []string{"propose", "prevote", "precommit"}
+
+// consensus is the state of a validator deciding on the block of a height
+type consensus struct {
+	height      int
+	round       int
+	step        consensusStep
+	active      bool                                 // rounds run, as there is something to decide on
+	proposals   map[int]Proposal                     // by round
+	votes       map[int]map[VoteType]map[string]Vote // by round, type and validator
+	lockedRound int
+	lockedBlock *Block
+	validRound  int
+	validBlock  *Block
+	checked     map[string]error // validity of the blocks proposed, by hash
+	done        map[string]bool  // rules applied once per round
+}
+
+func newConsensus(height int) consensus {
+	return consensus{
+		height:      height,
+		proposals:   make(map[int]Proposal),
+		votes:       make(map[int]map[VoteType]map[string]Vote),
+		lockedRound: -1,
+		validRound:  -1,
+		checked:     make(map[string]error),
+		done:        make(map[string]bool),
+	}
+}
+
+// record returns what a validator logs of the height it decides
+func (c *consensus) record(address string) ConsensusRecord {
+	record := ConsensusRecord{
+		Height:      c.height,
+		Round:       c.round,
+		LockedRound: c.lockedRound,
+		LockedBlock: c.lockedBlock,
+		ValidRound:  c.validRound,
+		ValidBlock:  c.validBlock,
+	}
+	for _, round := range sortedRounds(c.proposals) {
+		if proposal := c.proposals[round]; proposal.Proposer == address {
+			record.Proposals = append(record.Proposals, proposal)
+		}
+	}
+	for round := 0; round <= c.round; round++ {
+		for _, voteType := range []VoteType{Prevote, Precommit} {
+			if vote, exists := c.votes[round][voteType][address]; exists {
+				record.Votes = append(record.Votes, vote)
+			}
+		}
+	}
+	return record
+}
+
+// restore resumes the height of a record logged before a restart
+func (c *consensus) restore(record ConsensusRecord) {
+	c.round = record.Round
+	c.lockedRound, c.lockedBlock = record.LockedRound, record.LockedBlock
+	c.validRound, c.validBlock = record.ValidRound, record.ValidBlock
+	for _, proposal := range record.Proposals {
+		c.proposals[proposal.Round] = proposal
+	}
+	for _, vote := range record.Votes {
+		c.addVote(vote)
+	}
+}
+
+// persist logs the record of the node before it sends a proposal or vote, or changes the
+// block it saw a quorum prevote for. The caller must hold p.mutex
+func (p *Producer) persist() error {
+	record := p.consensus.record(p.key.Address)
+	return p.bc.store.Write(Batch{Consensus: &record})
+}
+
+// State returns the height and round being decided
+func (p *Producer) State() *ConsensusState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c := &p.consensus
+	return &ConsensusState{
+		Height:          c.height,
+		Round:           c.round,
+		Step:            consensusStepNames[c.step],
+		Proposer:        p.validators.Proposer(c.height, c.round),
+		LockedRound:     c.lockedRound,
+		ValidRound:      c.validRound,
+		Prevotes:        len(c.votes[c.round][Prevote]),
+		Precommits:      len(c.votes[c.round][Precommit]),
+		FinalizedHeight: c.height - 1,
+	}
+}
+
+// Schedule returns the validator set and the proposer of the round being decided
+func (p *Producer) Schedule() *ValidatorSchedule {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return &ValidatorSchedule{
+		Validators:   p.validators.Validators(),
+		NextHeight:   p.consensus.height,
+		NextProposer: p.validators.Proposer(p.consensus.height, p.consensus.round),
+	}
+}
+
+// ReceiveProposal takes part in deciding on a block proposed by the proposer of a round
+func (p *Producer) ReceiveProposal(proposal Proposal) error {
+	p.mutex.Lock()
+	err := p.receiveProposal(proposal)
+	outbox := p.takeOutbox()
+	p.mutex.Unlock()
+
+	p.send(outbox)
+	return err
+}
+
+func (p *Producer) receiveProposal(proposal Proposal) error {
+	height := proposal.Block.Height
+	if proposal.Round < 0 || proposal.ValidRound < -1 || proposal.ValidRound >= proposal.Round {
+		return fmt.Errorf("%w: invalid proposal rounds", ErrInvalidRequest)
+	}
+	proposer := p.validators.Proposer(height, proposal.Round)
+	if proposal.Proposer != proposer.Address {
+		return fmt.Errorf("%w: round %v of block %v is proposed by %v, not %q", ErrWrongProposer, proposal.Round, height, proposer.Address, proposal.Proposer)
+	}
+	// a new block is the one of the proposer, a block proposed again the one of an earlier round
+	if proposal.ValidRound == -1 && proposal.Block.Proposer != proposal.Proposer {
+		return fmt.Errorf("%w: the block of round %v is proposed by %q, not %v", ErrWrongProposer, proposal.Round, proposal.Block.Proposer, proposal.Proposer)
+	}
+	if proposal.ValidRound >= 0 && !p.validators.isScheduled(height, proposal.ValidRound, proposal.Block.Proposer) {
+		return fmt.Errorf("%w: the block of round %v is proposed by %q, not the proposer of a round up to %v", ErrWrongProposer, proposal.Round, proposal.Block.Proposer, proposal.ValidRound)
+	}
+	if err := verifySigner(client.ProposalSignature, proposal.Proposer, proposal.PublicKey, proposal.Signature, proposal); err != nil {
+		return fmt.Errorf("%w: proposal of %v: %v", ErrInvalidSignature, proposal.Proposer, err)
+	}
+	if err := p.reachHeight(height, proposer.URL); err != nil || p.key == nil {
+		return err
+	}
+	if height != p.consensus.height {
+		return nil // already decided
+	}
+
+	if _, exists := p.consensus.proposals[proposal.Round]; !exists {
+		for _, vote := range proposal.Prevotes {
+			if vote.Type != Prevote || vote.Height != height || vote.Round != proposal.ValidRound || vote.BlockHash != proposal.Block.Hash {
+				return fmt.Errorf("%w: vote of %v is not a prevote of the proposed block in round %v", ErrInvalidVote, vote.Validator, proposal.ValidRound)
+			}
+			if err := p.validators.verifyVote(vote); err != nil {
+				return err
+			}
+		}
+		for _, vote := range proposal.Prevotes {
+			p.consensus.addVote(vote)
+		}
+		proposal.Block.Commit = nil
+		p.consensus.proposals[proposal.Round] = proposal
+	}
+	p.activate()
+	p.process()
+	return nil
+}
+
+// ReceiveVote takes part in deciding on a block voted for by a validator
+func (p *Producer) ReceiveVote(vote Vote) error {
+	p.mutex.Lock()
+	err := p.receiveVote(vote)
+	outbox := p.takeOutbox()
+	p.mutex.Unlock()
+
+	p.send(outbox)
+	return err
+}
+
+func (p *Producer) receiveVote(vote Vote) error {
+	if err := p.validators.verifyVote(vote); err != nil {
+		return err
+	}
+	if vote.Round < 0 {
+		return fmt.Errorf("%w: negative round", ErrInvalidVote)
+	}
+	validator, _ := p.validators.Validator(vote.Validator)
+	if err := p.reachHeight(vote.Height, validator.URL); err != nil || p.key == nil {
+		return err
+	}
+	if vote.Height != p.consensus.height {
+		return nil // already decided
+	}
+
+	p.consensus.addVote(vote)
+	p.activate()
+	p.process()
+	return nil
+}
+
+// reachHeight catches up from the node at peer when a message is about a later height than
+// the one being decided. The caller must hold p.mutex
+func (p *Producer) reachHeight(height int, peer string) error {
+	if height <= p.consensus.height {
+		return nil
+	}
+	return p.catchUp(peer, height-1)
+}
+
+// addVote records a vote, unless the validator already voted in the round
+func (c *consensus) addVote(vote Vote) {
+	if c.votes[vote.Round] == nil {
+		c.votes[vote.Round] = make(map[VoteType]map[string]Vote)
+	}
+	if c.votes[vote.Round][vote.Type] == nil {
+		c.votes[vote.Round][vote.Type] = make(map[string]Vote)
+	}
+	if _, exists := c.votes[vote.Round][vote.Type][vote.Validator]; !exists {
+		c.votes[vote.Round][vote.Type][vote.Validator] = vote
+	}
+}
+
+// countVotes returns the number of votes of a type for a block in a round
+func (c *consensus) countVotes(round int, voteType VoteType, blockHash string) int {
+	count := 0
+	for _, vote := range c.votes[round][voteType] {
+		if vote.BlockHash == blockHash {
+			count++
+		}
+	}
+	return count
+}
+
+// sortedVotes returns the votes of a type for a block in a round, by validator
+func (c *consensus) sortedVotes(round int, voteType VoteType, blockHash string) []Vote {
+	votes := make([]Vote, 0)
+	for _, vote := range c.votes[round][voteType] {
+		if vote.BlockHash == blockHash {
+			votes = append(votes, vote)
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Validator < votes[j].Validator })
+	return votes
+}
+
+// enterHeight moves the consensus to the height after the tip. The caller must hold p.mutex
+func (p *Producer) enterHeight() {
+	if height := p.bc.GetHead().Height + 1; height != p.consensus.height {
+		p.consensus = newConsensus(height)
+	}
+}
+
+// activate starts the rounds of the height, for a validator. The caller must hold p.mutex
+func (p *Producer) activate() {
+	if p.key != nil && !p.consensus.active {
+		p.consensus.active = true
+		p.startRound(p.consensus.round)
+	}
+}
+
+// startRound starts a round at the propose step. The caller must hold p.mutex
+func (p *Producer) startRound(round int) {
+	c := &p.consensus
+	c.round = round
+	c.step = proposeStep
+	delay := time.Duration(round) * CONSENSUS_TIMEOUT_DELTA
+	p.scheduleTimeout(proposeStep, round, CONSENSUS_TIMEOUT_PROPOSE+delay)
+	// a round is given up when it does not decide, even if votes were lost
+	p.scheduleTimeout(-1, round, CONSENSUS_TIMEOUT_ROUND+delay)
+	logDebugf("Starting round %v of block %v, proposed by %v", round, c.height, p.validators.Proposer(c.height, round).Address)
+}
+
+// scheduleTimeout schedules the timeout of a step of the current round, or of the whole
+// round for step -1. The caller must hold p.mutex
+func (p *Producer) scheduleTimeout(step consensusStep, round int, delay time.Duration) {
+	height := p.consensus.height
+	p.schedule(delay, func() {
+		p.mutex.Lock()
+		p.onTimeout(step, height, round)
+		outbox := p.takeOutbox()
+		p.mutex.Unlock()
+		p.send(outbox)
+	})
+}
+
+func (p *Producer) onTimeout(step consensusStep, height int, round int) {
+	c := &p.consensus
+	if c.height != height || c.round != round {
+		return
+	}
+	switch {
+	case step == proposeStep && c.step == proposeStep:
+		p.vote(Prevote, "")
+		c.step = prevoteStep
+	case step == prevoteStep && c.step == prevoteStep:
+		p.vote(Precommit, "")
+		c.step = precommitStep
+	case step == precommitStep || step < 0:
+		p.startRound(round + 1)
+	}
+	p.process()
+}
+
+// process applies the rules of the consensus until none applies. The caller must hold p.mutex
+func (p *Producer) process() {
+	for p.consensus.active && p.applyRule() {
+	}
+}
+
+// applyRule applies the first rule of the consensus that applies, and tells whether one did
+func (p *Producer) applyRule() bool {
+	c := &p.consensus
+	quorum := p.validators.Quorum()
+	rounds := make([]int, 0, len(c.votes))
+	for round := range c.votes {
+		rounds = append(rounds, round)
+	}
+	sort.Ints(rounds)
+
+	// a block precommitted by a quorum in any round is final
+	for _, round := range rounds {
+		for _, proposalRound := range sortedRounds(c.proposals) {
+			block := c.proposals[proposalRound].Block
+			if !c.done["commit/"+block.Hash] && c.countVotes(round, Precommit, block.Hash) >= quorum {
+				c.done["commit/"+block.Hash] = true
+				return p.commit(block, round)
+			}
+		}
+	}
+
+	// validators in a later round are joined once enough of them are there
+	for _, round := range rounds {
+		if round <= c.round {
+			continue
+		}
+		voters := make(map[string]bool)
+		for _, votes := range c.votes[round] {
+			for validator := range votes {
+				voters[validator] = true
+			}
+		}
+		if len(voters) >= p.validators.skipQuorum() {
+			p.startRound(round)
+			return true
+		}
+	}
+
+	if c.step == proposeStep && p.propose() {
+		return true
+	}
+
+	proposal, proposed := c.proposals[c.round]
+	block := proposal.Block
+	doneKey := fmt.Sprintf("%v/", c.round)
+	switch {
+	case c.step == proposeStep && proposed && proposal.ValidRound == -1:
+		// a new block is prevoted, unless locked on another one
+		if p.isValid(block) && (c.lockedRound == -1 || c.lockedBlock.Hash == block.Hash) {
+			p.vote(Prevote, block.Hash)
+		} else {
+			p.vote(Prevote, "")
+		}
+		c.step = prevoteStep
+	case c.step == proposeStep && proposed && c.countVotes(proposal.ValidRound, Prevote, block.Hash) >= quorum:
+		// a block proposed again is prevoted when locked on it, or locked before the quorum prevoted for it
+		if p.isValid(block) && (c.lockedRound <= proposal.ValidRound || c.lockedBlock.Hash == block.Hash) {
+			p.vote(Prevote, block.Hash)
+		} else {
+			p.vote(Prevote, "")
+		}
+		c.step = prevoteStep
+	case c.step == prevoteStep && len(c.votes[c.round][Prevote]) >= quorum && !c.done[doneKey+"prevote-wait"]:
+		c.done[doneKey+"prevote-wait"] = true
+		p.scheduleTimeout(prevoteStep, c.round, CONSENSUS_TIMEOUT_VOTE+time.Duration(c.round)*CONSENSUS_TIMEOUT_DELTA)
+	case c.step >= prevoteStep && proposed && !c.done[doneKey+"lock"] && c.countVotes(c.round, Prevote, block.Hash) >= quorum && p.isValid(block):
+		c.done[doneKey+"lock"] = true
+		c.validRound, c.validBlock = c.round, &block
+		if c.step == prevoteStep {
+			// the lock is logged along with the precommit
+			c.lockedRound, c.lockedBlock = c.round, &block
+			p.vote(Precommit, block.Hash)
+			c.step = precommitStep
+		} else if err := p.persist(); err != nil {
+			logErrorf("Failed to log the block prevoted for in round %v: %v", c.round, err)
+		}
+	case c.step == prevoteStep && c.countVotes(c.round, Prevote, "") >= quorum:
+		p.vote(Precommit, "")
+		c.step = precommitStep
+	case len(c.votes[c.round][Precommit]) >= quorum && !c.done[doneKey+"precommit-wait"]:
+		c.done[doneKey+"precommit-wait"] = true
+		p.scheduleTimeout(precommitStep, c.round, CONSENSUS_TIMEOUT_VOTE+time.Duration(c.round)*CONSENSUS_TIMEOUT_DELTA)
+	default:
+		return false
+	}
+	return true
+}
+
+func sortedRounds(proposals map[int]Proposal) []int {
+	rounds := make([]int, 0, len(proposals))
+	for round := range proposals {
+		rounds = append(rounds, round)
+	}
+	sort.Ints(rounds)
+	return rounds
+}
+
+// isValid tells whether a proposed block can be added at the tip. The caller must hold p.mutex
+func (p *Producer) isValid(block Block) bool {
+	err, checked := p.consensus.checked[block.Hash]
+	if !checked {
+		err = p.bc.CheckBlock(block, p.ledger)
+		if err != nil {
+			logDebugf("Proposed block %v is invalid: %v", block.Height, err)
+		}
+		p.consensus.checked[block.Hash] = err
+	}
+	return err == nil
+}
+
+// propose makes the proposal of the round when the node is its proposer: the block a quorum
+// prevoted for in an earlier round, if any, or a new block holding the first valid entry of
+// the mempool. It tells whether it proposed. The caller must hold p.mutex
+func (p *Producer) propose() bool {
+	c := &p.consensus
+	if _, exists := c.proposals[c.round]; exists || !p.isValidator(p.validators.Proposer(c.height, c.round)) {
+		return false
+	}
+
+	proposal := Proposal{Round: c.round, ValidRound: c.validRound}
+	if c.validBlock != nil {
+		proposal.Block = *c.validBlock
+		proposal.Prevotes = c.sortedVotes(c.validRound, Prevote, c.validBlock.Hash)
+	} else {
+		block, found := p.newBlock()
+		if !found {
+			return false
+		}
+		proposal.Block = block
+	}
+	proposal.Proposer = p.key.Address
+	proposal.PublicKey = p.key.PublicKey
+	signature, err := p.key.Sign(client.ProposalSignature, proposal)
+	if err != nil {
+		logErrorf("Failed to sign proposal: %v", err)
+		return false
+	}
+	proposal.Signature = signature
+
+	c.proposals[c.round] = proposal
+	if err := p.persist(); err != nil {
+		logErrorf("Failed to log proposal: %v", err)
+		delete(c.proposals, c.round)
+		return false
+	}
+	logDebugf("Proposing block %v in round %v", c.height, c.round)
+	p.sendProposal(proposal)
+	return true
+}
+
+// sendProposal sends a proposal of the node to the other validators. The caller must hold p.mutex
+func (p *Producer) sendProposal(proposal Proposal) {
+	for _, validator := range p.otherValidators() {
+		peer := validator.URL
+		p.post(func() {
+			if err := p.transport.SendProposal(context.Background(), peer, proposal); err != nil {
+				logDebugf("Failed to send proposal to %v: %v", peer, err)
+			}
+		})
+	}
+}
+
+// newBlock returns a block holding the first valid entry of the mempool, dropping the
+// invalid ones. The caller must hold p.mutex
+func (p *Producer) newBlock() (Block, bool) {
+	for _, entry := range p.mempool.Entries() {
+		block, err := p.bc.newSignedBlock(entry.Data, p.ledger, p.key)
+		if err == nil {
+			return block, true
+		}
+		if errors.Is(err, ErrInvalidNonce) && p.bc.waitsForNonce(entry.Data) {
+			// kept until the submission before it is added
+			continue
+		}
+		// the entry was checked when received, but the chain has changed since
+		log.Printf("Dropping mempool entry %v: %v", entry.ID, err)
+		p.mempool.Remove(entry.ID)
+	}
+	return Block{}, false
+}
+
+// vote signs a vote of the node in the current round, records it, logs it and sends it to the
+// other validators. A vote the node logged before a restart is sent again instead. The caller
+// must hold p.mutex
+func (p *Producer) vote(voteType VoteType, blockHash string) {
+	c := &p.consensus
+	if voted, exists := c.votes[c.round][voteType][p.key.Address]; exists {
+		p.sendVote(voted)
+		return
+	}
+	vote := Vote{
+		Type:      voteType,
+		Height:    p.consensus.height,
+		Round:     p.consensus.round,
+		BlockHash: blockHash,
+		Validator: p.key.Address,
+		PublicKey: p.key.PublicKey,
+	}
+	signature, err := p.key.Sign(client.VoteSignature, vote)
+	if err != nil {
+		logErrorf("Failed to sign vote: %v", err)
+		return
+	}
+	vote.Signature = signature
+
+	c.addVote(vote)
+	if err := p.persist(); err != nil {
+		logErrorf("Failed to log vote: %v", err)
+		delete(c.votes[vote.Round][voteType], vote.Validator)
+		return
+	}
+	p.sendVote(vote)
+}
+
+// sendVote sends a vote of the node to the other validators. The caller must hold p.mutex
+func (p *Producer) sendVote(vote Vote) {
+	for _, validator := range p.otherValidators() {
+		peer := validator.URL
+		p.post(func() {
+			if err := p.transport.SendVote(context.Background(), peer, vote); err != nil {
+				logDebugf("Failed to send vote to %v: %v", peer, err)
+			}
+		})
+	}
+}
+
+// resend sends the proposal and the votes of the node in the current round again, in case
+// they were lost
+func (p *Producer) resend() {
+	p.mutex.Lock()
+	c := &p.consensus
+	if c.active {
+		if proposal, exists := c.proposals[c.round]; exists && proposal.Proposer == p.key.Address {
+			p.sendProposal(proposal)
+		}
+		for _, voteType := range []VoteType{Prevote, Precommit} {
+			if vote, exists := c.votes[c.round][voteType][p.key.Address]; exists {
+				p.sendVote(vote)
+			}
+		}
+	}
+	outbox := p.takeOutbox()
+	p.mutex.Unlock()
+	p.send(outbox)
+}
+
+// commit adds a block precommitted by a quorum in round, with the precommits as its commit,
+// and sends it to the peers. The caller must hold p.mutex
+func (p *Producer) commit(block Block, round int) bool {
+	block.Commit = &Commit{Round: round, Votes: p.consensus.sortedVotes(round, Precommit, block.Hash)}
+
+	if err := p.add(block); err != nil {
+		logErrorf("Failed to add block %v committed in round %v: %v", block.Height, round, err)
+		return false
+	}
+	log.Printf("Block %v committed in round %v", block.Height, round)
+	for _, peer := range p.peers {
+		peer := peer
+		p.post(func() {
+			if err := p.transport.SendBlock(context.Background(), peer, block); err != nil {
+				logWarnf("Failed to send block %v to %v: %v", block.Height, peer, err)
+			}
+		})
+	}
+	if len(p.mempool.Entries()) > 0 {
+		p.activate()
+	}
+	return true
+}
+
+// CheckBlock checks a block could be appended at the tip, except for its commit
+func (bc *Blockchain) CheckBlock(block Block, ledger *Ledger) error {
+	bc.solveAhead(block.Data, MAX_DP_CERTIFICATE_CELLS)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.checkBlock(block, ledger)
+}
+
+// newSignedBlock returns a block holding data at the tip, signed with key
+func (bc *Blockchain) newSignedBlock(data BlockData, ledger *Ledger, key *client.Key) (Block, error) {
+	bc.solveAhead(data, MAX_DP_CERTIFICATE_CELLS)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	block, err := bc.generateNewBlock(data)
+	if err != nil {
+		return Block{}, err
+	}
+	if err := signBlock(&block, key); err != nil {
+		return Block{}, err
+	}
+	return block, bc.checkBlock(block, ledger)
+}
+
+func HandleReceiveProposal(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var proposal Proposal
+	if err := decodeJSONBody(r, &proposal); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := producer.ReceiveProposal(proposal); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func HandleReceiveVote(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var vote Vote
+	if err := decodeJSONBody(r, &vote); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if err := producer.ReceiveVote(vote); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func HandleGetConsensus(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, producer.State())
+}
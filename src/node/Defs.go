@@ -1,11 +1,135 @@
 package main
 
+import "time"
+
 // *** CONSTANTS ***
 
 // NUMBER_OF_BLOCKS_TO_SOLUTION is the number of blocks that must be mined before a solution to the knapsack problem is accepted
 const NUMBER_OF_BLOCKS_TO_SOLUTION = 10
 
+// File of the data dir holding the chain log, and the number of block bodies cached from it
+const LOG_STORE_FILE = "chain.log"
+const LOG_STORE_CACHE_SIZE = 256
+
+// File of the data dir in which older nodes saved the chain. It is moved into the log on start
 const PERSISTED_BLOCKCHAIN_FILE = "blockchain_data.json"
 
+// Number of last blocks whose median timestamp the timestamp of a new block must not be behind,
+// and how far ahead of the clock of a node the timestamp of a block it adds may be
+const MEDIAN_TIME_BLOCKS = 11
+const MAX_BLOCK_TIME_DRIFT = 15 * time.Second
+
+// Average interval, in seconds, between the submissions of a mining node
+const DEFAULT_BLOCK_INTERVAL = 5
+
+// Maximum number of submissions held in the mempool, and how long one is held before it is dropped
+const MEMPOOL_SIZE = 1000
+const MEMPOOL_ENTRY_TTL = 5 * time.Minute
+
+// How long a node with a validator set waits for a submission to be added to a block before
+// answering it is pending
+const MEMPOOL_WAIT = 5 * time.Second
+
+// Interval between the attempts of a validator to catch up with the others and to forward its mempool again
+const PRODUCER_RETRY_INTERVAL = 5 * time.Second
+
+// How long a validator waits for the proposal of a consensus round, for the missing votes once
+// a quorum voted in a step, and for the whole round. Each round waits longer than the previous
+// one by CONSENSUS_TIMEOUT_DELTA, so that slow validators end up deciding together
+const CONSENSUS_TIMEOUT_PROPOSE = 3 * time.Second
+const CONSENSUS_TIMEOUT_VOTE = 1 * time.Second
+const CONSENSUS_TIMEOUT_ROUND = 10 * time.Second
+const CONSENSUS_TIMEOUT_DELTA = 500 * time.Millisecond
+
+// Interval between the comparisons of the chain of a node without a validator set with the
+// chains of its peers
+const FORK_CHOICE_INTERVAL = 5 * time.Second
+
+// Time a node leaves a peer alone after its branch failed to replay, doubling with each
+// failure in a row up to FORK_CHOICE_MAX_PENALTY
+const FORK_CHOICE_PENALTY = 1 * time.Minute
+const FORK_CHOICE_MAX_PENALTY = 1 * time.Hour
+
+// Useful work a chain without a validator set may gain per second its block timestamps span,
+// and the most it saves up (see ForkChoice.go)
+const MAX_WORK_PER_SECOND = 10.0
+const MAX_WORK_BURST = 1000.0
+
+// Minimums of the problems submitted: items, hardness score (see Hardness.go), and bounty,
+// growing by MIN_PROBLEM_BOUNTY_PER_ITEM for each item. The score counts the items a search
+// branches on, so it is the log2 of the search space left: 8 leaves at least 256 candidates
+const MIN_PROBLEM_ITEMS = 10
+const MIN_PROBLEM_HARDNESS = 8.0
+const MIN_PROBLEM_BOUNTY = 1.0
+const MIN_PROBLEM_BOUNTY_PER_ITEM = 0.05
+
+// Maximums of the problems of the network: items, and weight, value or capacity. Their
+// sums stay far from overflowing. Nodes may accept smaller submissions, see Limits.go
+const MAX_PROBLEM_ITEMS = 10000
+const MAX_PROBLEM_NUMBER = 1_000_000_000
+
+// Validator key file written in the data directory of each devnet node
+const VALIDATOR_KEY_FILE = "validator_key.json"
+
+// Interval, in blocks, between the state snapshots
+const SNAPSHOT_INTERVAL = 100
+
+// Defaults of the node config
+const DEFAULT_CONFIG_FILE = "solvernet.json"
+const DEFAULT_LISTEN_ADDRESS = ":3001"
+const DEFAULT_DATA_DIR = "solvernet_data"
+
+// Defaults of the limits of the node API, see Limits.go
+const DEFAULT_MAX_BODY_BYTES = 1 << 20
+const DEFAULT_MAX_PROBLEM_ITEMS = 1000
+const DEFAULT_MAX_NUMBER = 1_000_000
+const DEFAULT_MAX_SOLUTION_ITEMS = 1000
+const DEFAULT_RATE_LIMIT = 20.0
+const DEFAULT_RATE_BURST = 40
+const DEFAULT_MAX_CONNECTIONS = 512
+const DEFAULT_MAX_CONNECTIONS_PER_IP = 64
+const DEFAULT_MAX_IMPORT_BLOCKS = 100_000
+
+// How long a client has to send the headers of a request, how long the rate limit of an idle
+// client IP is kept, and how long a connection over the limits is given to read its rejection
+const READ_HEADER_TIMEOUT = 10 * time.Second
+const RATE_LIMIT_IDLE = 5 * time.Minute
+const REJECT_CONNECTION_TIMEOUT = 1 * time.Second
+
+// Directory holding the data dirs of the devnet nodes
+const DEFAULT_DEVNET_DIR = "devnet_data"
+
+// Identifies the chain in archives. Archives of another chain are not imported
+const CHAIN_ID = "solvernet"
+
+// Magic bytes starting a chain archive, and the maximum size of one of its records
+const ARCHIVE_MAGIC = "SNCHAIN1"
+const MAX_ARCHIVE_RECORD_SIZE = 16 << 20
+
+// Time given to the open requests to complete when a node shuts down
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
 // The initial balance of an address. This serves to skip the problem of initially distributing money for the sake of the hackathon
 const ADDRESS_INITIAL_BALANCE = 1000.0
+
+// Maximum size (items * (capacity + 1)) of the DP table a validator recomputes to check a DP
+// certificate, and of the one a dry run recomputes
+const MAX_DP_CERTIFICATE_CELLS = 10_000_000
+const MAX_DRY_RUN_CERTIFICATE_CELLS = 1_000_000
+
+// Number of problems whose DP solution is kept to check the certificates of their solutions
+const CERTIFICATE_CACHE_SIZE = 64
+
+// Number of blocks returned by the block explorer endpoints when no limit is given, and the maximum allowed
+const DEFAULT_BLOCKS_PAGE_LIMIT = 20
+const MAX_BLOCKS_PAGE_LIMIT = 100
+
+// Size of the buffer of each event subscription. Events are dropped for subscribers that fall behind
+const EVENT_SUBSCRIPTION_BUFFER = 64
+
+// Interval between the comments sent to keep idle event streams open
+const EVENT_STREAM_KEEP_ALIVE = 15 * time.Second
+
+// Node and key file used by the CLI when neither a flag nor SOLVERNET_NODE / SOLVERNET_KEY is set
+const DEFAULT_NODE_URL = "http://localhost:3001"
+const DEFAULT_KEY_FILE = "solvernet_key.json"
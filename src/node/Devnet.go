@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"solvernet/client"
+)
+
+// A devnet runs a local network of nodes as child processes of the solvernet binary.
+// Every node gets its own port and data dir and has all the others as peers.
+// Their logs are merged into the devnet output, each line prefixed with the node name
+
+type devnetNode struct {
+	name    string
+	url     string
+	command *exec.Cmd
+	output  *prefixWriter
+	exited  chan struct{} // closed once the node exited, with its exit error in err
+	err     error
+}
+
+func runDevnet(command *command, args []string) error {
+	flags := newFlags(command)
+	nodeCount := flags.Int("nodes", 3, "number of nodes")
+	basePort := flags.Int("base-port", 3001, "port of the first node. The others use the next ports")
+	dataDir := flags.String("data-dir", DEFAULT_DEVNET_DIR, "directory holding the data dir of every node")
+	mining := flags.Bool("mining", true, "nodes submit random problems and solutions to each other")
+	logLevel := flags.String("log-level", "info", "log level of the nodes")
+	reset := flags.Bool("reset", false, "delete the chains of a previous run")
+	validators := flags.Bool("validators", false, "make every node a validator, blocks being made by the proposer of their height")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *nodeCount < 1 || *basePort < 1 || *basePort+*nodeCount-1 > 65535 {
+		return errors.New("invalid number of nodes or base port")
+	}
+	if _, exists := logLevels[*logLevel]; !exists {
+		return fmt.Errorf("invalid log level %q", *logLevel)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if *reset {
+		if err := os.RemoveAll(*dataDir); err != nil {
+			return err
+		}
+	}
+
+	ports := make([]int, *nodeCount)
+	urls := make([]string, *nodeCount)
+	for i := range ports {
+		ports[i] = *basePort + i
+		urls[i] = "http://localhost:" + strconv.Itoa(ports[i])
+	}
+
+	// every validator keeps its key in its data dir, so the set is the same across runs
+	validatorSet := make([]string, 0, *nodeCount)
+	if *validators {
+		for i, port := range ports {
+			key, err := devnetValidatorKey(filepath.Join(*dataDir, "node-"+strconv.Itoa(port)))
+			if err != nil {
+				return err
+			}
+			validatorSet = append(validatorSet, key.Address+"@"+urls[i])
+		}
+	}
+
+	var outputMutex sync.Mutex
+	nodes := make([]*devnetNode, 0, *nodeCount)
+	for i, port := range ports {
+		peers := make([]string, 0, *nodeCount-1)
+		for j, url := range urls {
+			if j != i {
+				peers = append(peers, url)
+			}
+		}
+		name := "node-" + strconv.Itoa(port)
+		nodeArgs := []string{"node", "run",
+			// the flags set every field, so the config file and environment of the devnet do not leak into the nodes
+			"-config", os.DevNull,
+			"-listen", ":" + strconv.Itoa(port),
+			"-data-dir", filepath.Join(*dataDir, name),
+			"-peers", strings.Join(peers, ","),
+			"-cors-origins", "*",
+			"-mining=" + strconv.FormatBool(*mining),
+			"-log-level", *logLevel,
+			"-validators", strings.Join(validatorSet, ","),
+			// the nodes all connect from localhost, so its requests and connections are not limited
+			"-rate-limit", "0",
+			"-max-connections-per-ip", "0",
+			"-validator-key", "",
+		}
+		if *validators {
+			nodeArgs[len(nodeArgs)-1] = filepath.Join(*dataDir, name, VALIDATOR_KEY_FILE)
+		}
+		output := &prefixWriter{prefix: fmt.Sprintf("[%v] ", name), out: os.Stdout, mutex: &outputMutex}
+		node := &devnetNode{
+			name:    name,
+			url:     urls[i],
+			command: exec.Command(executable, nodeArgs...),
+			output:  output,
+			exited:  make(chan struct{}),
+		}
+		node.command.Stdout = output
+		node.command.Stderr = output
+		nodes = append(nodes, node)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, node := range nodes {
+		if err := node.command.Start(); err != nil {
+			stopDevnet(nodes)
+			return fmt.Errorf("failed to start %v: %w", node.name, err)
+		}
+		go func(node *devnetNode) {
+			node.err = node.command.Wait()
+			node.output.Flush()
+			close(node.exited)
+		}(node)
+	}
+	fmt.Printf("devnet: started %v nodes at %v\n", len(nodes), strings.Join(urls, ", "))
+
+	go checkDevnetGenesis(ctx, nodes)
+
+	// a node exiting on its own brings the whole devnet down
+	exited := make(chan *devnetNode, len(nodes))
+	for _, node := range nodes {
+		go func(node *devnetNode) {
+			<-node.exited
+			exited <- node
+		}(node)
+	}
+
+	var result error
+	select {
+	case <-ctx.Done():
+		fmt.Println("devnet: shutting down")
+	case node := <-exited:
+		result = fmt.Errorf("%v exited: %v", node.name, exitDescription(node.err))
+		fmt.Println("devnet:", result, "- shutting down the other nodes")
+	}
+	stopDevnet(nodes)
+	return result
+}
+
+// devnetValidatorKey returns the validator key kept in a node data dir, generating it on the first run
+func devnetValidatorKey(nodeDataDir string) (*client.Key, error) {
+	path := filepath.Join(nodeDataDir, VALIDATOR_KEY_FILE)
+	if key, err := client.LoadKey(path); !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+	key, err := client.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(nodeDataDir, 0755); err != nil {
+		return nil, err
+	}
+	return key, key.Save(path)
+}
+
+// hasExited tells whether the node exited, without waiting for it
+func (node *devnetNode) hasExited() bool {
+	select {
+	case <-node.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopDevnet interrupts the running nodes so they save their chain, and kills the ones still running after a while
+func stopDevnet(nodes []*devnetNode) {
+	for _, node := range nodes {
+		if node.command.Process != nil && !node.hasExited() {
+			node.command.Process.Signal(os.Interrupt)
+		}
+	}
+
+	deadline := time.After(2 * SHUTDOWN_TIMEOUT)
+	for _, node := range nodes {
+		if node.command.Process == nil {
+			continue
+		}
+		select {
+		case <-node.exited:
+		case <-deadline:
+			fmt.Printf("devnet: %v did not stop, killing it\n", node.name)
+			node.command.Process.Kill()
+		}
+	}
+}
+
+func exitDescription(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// checkDevnetGenesis waits for the nodes to come up and checks they share the genesis block
+func checkDevnetGenesis(ctx context.Context, nodes []*devnetNode) {
+	genesisHashes := make(map[string][]string)
+	for _, node := range nodes {
+		peer := client.New(node.url)
+		for {
+			genesis, err := peer.GetBlockByHeight(ctx, 0)
+			if err == nil {
+				genesisHashes[genesis.Hash] = append(genesisHashes[genesis.Hash], node.name)
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+	}
+
+	if len(genesisHashes) == 1 {
+		for hash := range genesisHashes {
+			fmt.Printf("devnet: all nodes are up, genesis %v\n", hash)
+		}
+		return
+	}
+	for hash, names := range genesisHashes {
+		fmt.Printf("devnet: WARNING genesis %v on %v\n", hash, strings.Join(names, ", "))
+	}
+}
+
+// prefixWriter writes every line it receives to out, prefixed. Writers sharing
+// a mutex never interleave their lines
+type prefixWriter struct {
+	prefix  string
+	out     io.Writer
+	mutex   *sync.Mutex
+	pending []byte // last line, until its end is written
+}
+
+func (w *prefixWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(w.out, "%v%s\n", w.prefix, w.pending[:end]); err != nil {
+			return 0, err
+		}
+		w.pending = w.pending[end+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes the last line when it has no end
+func (w *prefixWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.pending) > 0 {
+		fmt.Fprintf(w.out, "%v%s\n", w.prefix, w.pending)
+		w.pending = nil
+	}
+}
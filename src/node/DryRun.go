@@ -0,0 +1,96 @@
+package main
+
+// Dry runs validate a submission against the current tip without adding a block
+
+// ProblemValidation is the outcome of validating a problem
+type ProblemValidation struct {
+	Valid       bool       `json:"valid"`
+	Error       *ErrorBody `json:"error,omitempty"` // reason of the rejection
+	ItemCount   int        `json:"item_count"`
+	TotalWeight int        `json:"total_weight"`
+	Hardness    Hardness   `json:"hardness"`
+	MinBounty   float64    `json:"min_bounty"` // lowest bounty accepted for the problem
+	Height      int        `json:"height"`     // height the problem would be added at
+}
+
+// SolutionValidation is the outcome of validating a proposed solution
+type SolutionValidation struct {
+	Valid            bool       `json:"valid"`
+	Error            *ErrorBody `json:"error,omitempty"` // reason of the rejection
+	Weight           int        `json:"weight"`
+	Value            int        `json:"value"`
+	Capacity         int        `json:"capacity"`
+	CurrentBestValue int        `json:"current_best_value"`
+	Height           int        `json:"height"` // height the proposed solution would be added at
+}
+
+// DryRunProblem checks a problem against the limits of the node, and runs ValidateProblem
+// against the current tip
+func (bc *Blockchain) DryRunProblem(problem KnapsackProblem) *ProblemValidation {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	validation := &ProblemValidation{
+		Valid:       true,
+		ItemCount:   len(problem.Items),
+		TotalWeight: GetProblemItemsSumWeight(problem),
+		Hardness:    EstimateHardness(problem),
+		MinBounty:   MinProblemBounty(problem),
+		Height:      bc.getLastBlock().Height + 1,
+	}
+	err := bc.limits.checkSubmission(BlockData{Type: KnapsackProblemSubmission, Problem: &problem})
+	if err == nil {
+		err = ValidateProblem(problem, bc)
+	}
+	if err != nil {
+		validation.Valid = false
+		validation.Error = newErrorBody(err)
+	}
+	return validation
+}
+
+// DryRunProposedSolution checks a proposed solution against the limits of the node, and
+// validates it against the current tip. DP certificates are only checked against problems of
+// up to MAX_DRY_RUN_CERTIFICATE_CELLS cells, unless already solved
+func (bc *Blockchain) DryRunProposedSolution(proposedSolution KnapsackProposedSolution) *SolutionValidation {
+	bc.solveAhead(BlockData{Type: KnapsackProposedSolutionSubmission, Solution: &proposedSolution}, MAX_DRY_RUN_CERTIFICATE_CELLS)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	validation := &SolutionValidation{
+		Valid:  true,
+		Height: bc.getLastBlock().Height + 1,
+	}
+
+	height := proposedSolution.ProblemBlockHeight
+	if block, exists := bc.blockAt(height); exists && block.Data.Type == KnapsackProblemSubmission {
+		problem := *block.Data.Problem
+		validation.Capacity = problem.Capacity
+		if submissions := bc.findProblemSubmissions(height); len(submissions) > 0 {
+			validation.CurrentBestValue = submissions[len(submissions)-1].Solution.Value
+		}
+
+		// weight and value can only be computed when every index is valid
+		validIndexes := true
+		for _, index := range proposedSolution.ItemIndexes {
+			if index < 0 || index >= len(problem.Items) {
+				validIndexes = false
+			}
+		}
+		if validIndexes {
+			validation.Weight = GetTotalSolutionWeight(problem, proposedSolution)
+			validation.Value = GetTotalSolutionValue(problem, proposedSolution)
+		}
+	}
+
+	err := bc.limits.checkSubmission(BlockData{Type: KnapsackProposedSolutionSubmission, Solution: &proposedSolution})
+	if err == nil {
+		err = validateProposedSolutionAt(proposedSolution, bc, bc.nextTimestamp(), MAX_DRY_RUN_CERTIFICATE_CELLS, 0)
+	}
+	if err != nil {
+		validation.Valid = false
+		validation.Error = newErrorBody(err)
+	}
+	return validation
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Sentinel errors returned by the validation functions.
+// They are mapped to stable codes and HTTP statuses by the API
+
+// request errors
+var (
+	ErrInvalidJSON    = errors.New("invalid json")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrBlockNotFound  = errors.New("block not found")
+	ErrBodyTooLarge   = errors.New("request body too large")
+)
+
+// limit errors
+var (
+	ErrRateLimited        = errors.New("too many requests")
+	ErrTooManyConnections = errors.New("too many connections")
+	ErrImportForbidden    = errors.New("archives are only imported from localhost")
+)
+
+// problem errors
+var (
+	ErrBountyTooLow        = errors.New("bounty too low")
+	ErrNoProblemItems      = errors.New("no items in problem")
+	ErrNoProblemAddress    = errors.New("no address in problem")
+	ErrInvalidPayoutPolicy = errors.New("invalid payout policy")
+	ErrInvalidExpiry       = errors.New("negative expiry")
+	ErrCapacityTooLow      = errors.New("capacity too low")
+	ErrInvalidItem         = errors.New("negative/0 weight or value")
+	ErrTrivialProblem      = errors.New("total items weight is smaller than capacity. Trivial problem not allowed")
+	ErrTooFewItems         = errors.New("too few items in problem")
+	ErrProblemTooEasy      = errors.New("problem too easy")
+	ErrProblemTooLarge     = errors.New("too many items in problem")
+	ErrNumberOutOfRange    = errors.New("weight, value or capacity out of range")
+)
+
+// proposed solution errors
+var (
+	ErrProblemNotFound      = errors.New("block at height does not contain a problem")
+	ErrProblemExpired       = errors.New("problem at block height is expired")
+	ErrProblemSettled       = errors.New("problem already settled")
+	ErrNoSolutionItems      = errors.New("no items in solution")
+	ErrNoSolutionAddress    = errors.New("no address in solution")
+	ErrDuplicateItemIndexes = errors.New("duplicate item indexes")
+	ErrInvalidItemIndex     = errors.New("invalid item index")
+	ErrCapacityExceeded     = errors.New("solution exceeds capacity")
+	ErrValueMismatch        = errors.New("solution value does not match")
+	ErrSolutionNotBetter    = errors.New("solution is not better than previous solution")
+	ErrInvalidCertificate   = errors.New("invalid certificate")
+	ErrSolutionTooLarge     = errors.New("too many items in solution")
+	ErrCertificateUnchecked = errors.New("certificate too large to check in a dry run")
+)
+
+// signature errors
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrInvalidNonce     = errors.New("invalid nonce")
+)
+
+// transaction and block errors
+var (
+	ErrInvalidAmount       = errors.New("invalid transaction amount")
+	ErrInvalidAddress      = errors.New("invalid transaction address")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNoValidProblems     = errors.New("no valid problems to solve")
+	ErrInvalidPayout       = errors.New("invalid payout")
+	ErrInvalidBlockType    = errors.New("invalid block type")
+	ErrBlockNotChained     = errors.New("block is not correctly chained")
+	ErrInvalidStateRoot    = errors.New("state root does not match the snapshot")
+	ErrInvalidTimestamp    = errors.New("invalid block timestamp")
+	ErrInvalidLedgerUpdate = errors.New("invalid ledger update")
+	ErrInvalidBranch       = errors.New("branch of the peer does not hold the work it claims")
+)
+
+// archive errors
+var (
+	ErrInvalidArchive  = errors.New("invalid archive")
+	ErrArchiveMismatch = errors.New("archive does not match the chain")
+)
+
+// snapshot errors
+var (
+	ErrSnapshotNotFound = errors.New("no snapshot taken yet")
+	ErrInvalidSnapshot  = errors.New("invalid snapshot")
+	ErrHistoryNotHeld   = errors.New("the chain was pruned or started from a snapshot, and does not hold its oldest blocks")
+	ErrBlockPruned      = errors.New("block body not held. The chain was pruned or started from a snapshot")
+)
+
+// validator errors
+var (
+	ErrWrongProposer = errors.New("not proposed by the proposer of its round")
+	ErrNoValidators  = errors.New("the network has no validator set")
+	ErrMempoolFull   = errors.New("mempool is full")
+	ErrInvalidVote   = errors.New("invalid vote")
+	ErrInvalidCommit = errors.New("block is not committed by a quorum of validators")
+)
+
+type errorCode struct {
+	err    error
+	code   string
+	status int
+}
+
+// errorCodes maps every sentinel error to its machine-readable code and HTTP status.
+// Codes are part of the API and must not change
+var errorCodes = []errorCode{
+	{ErrInvalidJSON, "invalid_json", http.StatusBadRequest},
+	{ErrInvalidRequest, "invalid_request", http.StatusBadRequest},
+	{ErrBlockNotFound, "block_not_found", http.StatusNotFound},
+	{ErrBodyTooLarge, "body_too_large", http.StatusRequestEntityTooLarge},
+
+	{ErrRateLimited, "rate_limited", http.StatusTooManyRequests},
+	{ErrTooManyConnections, "too_many_connections", http.StatusServiceUnavailable},
+	{ErrImportForbidden, "import_forbidden", http.StatusForbidden},
+
+	{ErrBountyTooLow, "bounty_too_low", http.StatusBadRequest},
+	{ErrNoProblemItems, "no_problem_items", http.StatusBadRequest},
+	{ErrNoProblemAddress, "no_problem_address", http.StatusBadRequest},
+	{ErrInvalidPayoutPolicy, "invalid_payout_policy", http.StatusBadRequest},
+	{ErrInvalidExpiry, "invalid_expiry", http.StatusBadRequest},
+	{ErrCapacityTooLow, "capacity_too_low", http.StatusBadRequest},
+	{ErrInvalidItem, "invalid_item", http.StatusBadRequest},
+	{ErrTrivialProblem, "trivial_problem", http.StatusBadRequest},
+	{ErrTooFewItems, "too_few_items", http.StatusBadRequest},
+	{ErrProblemTooEasy, "problem_too_easy", http.StatusBadRequest},
+	{ErrProblemTooLarge, "problem_too_large", http.StatusBadRequest},
+	{ErrNumberOutOfRange, "number_out_of_range", http.StatusBadRequest},
+
+	{ErrProblemNotFound, "problem_not_found", http.StatusNotFound},
+	{ErrProblemExpired, "problem_expired", http.StatusConflict},
+	{ErrProblemSettled, "problem_settled", http.StatusConflict},
+	{ErrNoSolutionItems, "no_solution_items", http.StatusBadRequest},
+	{ErrNoSolutionAddress, "no_solution_address", http.StatusBadRequest},
+	{ErrDuplicateItemIndexes, "duplicate_item_indexes", http.StatusBadRequest},
+	{ErrInvalidItemIndex, "invalid_item_index", http.StatusBadRequest},
+	{ErrCapacityExceeded, "capacity_exceeded", http.StatusBadRequest},
+	{ErrValueMismatch, "value_mismatch", http.StatusBadRequest},
+	{ErrSolutionNotBetter, "solution_not_better", http.StatusConflict},
+	{ErrInvalidCertificate, "invalid_certificate", http.StatusBadRequest},
+	{ErrSolutionTooLarge, "solution_too_large", http.StatusBadRequest},
+	{ErrCertificateUnchecked, "certificate_unchecked", http.StatusUnprocessableEntity},
+
+	{ErrInvalidSignature, "invalid_signature", http.StatusBadRequest},
+	{ErrInvalidNonce, "invalid_nonce", http.StatusConflict},
+
+	{ErrInvalidAmount, "invalid_amount", http.StatusBadRequest},
+	{ErrInvalidAddress, "invalid_address", http.StatusBadRequest},
+	{ErrInsufficientBalance, "insufficient_balance", http.StatusConflict},
+	{ErrNoValidProblems, "no_valid_problems", http.StatusConflict},
+	{ErrInvalidPayout, "invalid_payout", http.StatusBadRequest},
+	{ErrInvalidBlockType, "invalid_block_type", http.StatusBadRequest},
+	{ErrBlockNotChained, "block_not_chained", http.StatusConflict},
+	{ErrInvalidStateRoot, "invalid_state_root", http.StatusBadRequest},
+	{ErrInvalidTimestamp, "invalid_timestamp", http.StatusBadRequest},
+	{ErrInvalidLedgerUpdate, "invalid_ledger_update", http.StatusBadRequest},
+	{ErrInvalidBranch, "invalid_branch", http.StatusBadRequest},
+
+	{ErrInvalidArchive, "invalid_archive", http.StatusBadRequest},
+	{ErrArchiveMismatch, "archive_mismatch", http.StatusConflict},
+
+	{ErrSnapshotNotFound, "snapshot_not_found", http.StatusNotFound},
+	{ErrInvalidSnapshot, "invalid_snapshot", http.StatusBadRequest},
+	{ErrHistoryNotHeld, "history_not_held", http.StatusConflict},
+	{ErrBlockPruned, "block_pruned", http.StatusGone},
+
+	{ErrWrongProposer, "wrong_proposer", http.StatusBadRequest},
+	{ErrNoValidators, "no_validators", http.StatusNotFound},
+	{ErrMempoolFull, "mempool_full", http.StatusServiceUnavailable},
+	{ErrInvalidVote, "invalid_vote", http.StatusBadRequest},
+	{ErrInvalidCommit, "invalid_commit", http.StatusBadRequest},
+}
+
+// ErrorResponse is the envelope of every error returned by the API
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// classifyError returns the code and HTTP status of an error.
+// Unknown errors are internal errors
+func classifyError(err error) (string, int) {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code, entry.status
+		}
+	}
+	return "internal_error", http.StatusInternalServerError
+}
+
+func newErrorBody(err error) *ErrorBody {
+	code, _ := classifyError(err)
+	return &ErrorBody{Code: code, Message: err.Error()}
+}
+
+func respondWithError(w http.ResponseWriter, err error) {
+	_, status := classifyError(err)
+	respondWithJSON(w, status, ErrorResponse{Error: *newErrorBody(err)})
+}
@@ -0,0 +1,133 @@
+package main
+
+import "sync"
+
+// EventType identifies what happened on the blockchain
+type EventType string
+
+const (
+	BlockAddedEvent       EventType = "block_added"
+	ProblemOpenedEvent    EventType = "problem_opened"
+	LeaderChangedEvent    EventType = "leader_changed"    // a proposed solution improved the best value
+	ProblemSettledEvent   EventType = "problem_settled"   // bounty paid
+	ProblemExpiredEvent   EventType = "problem_expired"   // window closed without solutions
+	ChainReorganizedEvent EventType = "chain_reorganized" // switched to a branch doing more work, see ForkChoice.go
+)
+
+type Event struct {
+	Type               EventType   `json:"type"`
+	BlockHeight        int         `json:"block_height"`
+	ProblemBlockHeight *int        `json:"problem_block_height,omitempty"`
+	Addresses          []string    `json:"addresses"` // addresses involved in the event
+	Data               interface{} `json:"data,omitempty"`
+}
+
+// EventFilter selects the events a subscriber receives. Empty fields match everything
+type EventFilter struct {
+	Types   map[EventType]bool
+	Address string
+}
+
+type Subscription struct {
+	Events chan Event
+	filter EventFilter
+}
+
+// EventHub delivers the events published by the blockchain to its subscribers
+type EventHub struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscriptions: make(map[*Subscription]bool)}
+}
+
+func (filter EventFilter) matches(event Event) bool {
+	if len(filter.Types) > 0 && !filter.Types[event.Type] {
+		return false
+	}
+	if filter.Address == "" {
+		return true
+	}
+	for _, address := range event.Addresses {
+		if address == filter.Address {
+			return true
+		}
+	}
+	return false
+}
+
+func (hub *EventHub) Subscribe(filter EventFilter) *Subscription {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	subscription := &Subscription{
+		Events: make(chan Event, EVENT_SUBSCRIPTION_BUFFER),
+		filter: filter,
+	}
+	hub.subscriptions[subscription] = true
+	return subscription
+}
+
+func (hub *EventHub) Unsubscribe(subscription *Subscription) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(hub.subscriptions, subscription)
+}
+
+// Publish sends the event to every matching subscriber without blocking
+func (hub *EventHub) Publish(event Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for subscription := range hub.subscriptions {
+		if !subscription.filter.matches(event) {
+			continue
+		}
+		select {
+		case subscription.Events <- event:
+		default:
+			logWarnf("Event subscriber is too slow, dropping event %v", event.Type)
+		}
+	}
+}
+
+// publishBlockEvents publishes the events caused by a block appended to the blockchain
+func (bc *Blockchain) publishBlockEvents(block Block) {
+	bc.events.Publish(Event{
+		Type:        BlockAddedEvent,
+		BlockHeight: block.Height,
+		Addresses:   blockAddresses(block),
+		Data:        block,
+	})
+
+	switch block.Data.Type {
+	case KnapsackProblemSubmission:
+		bc.events.Publish(Event{
+			Type:               ProblemOpenedEvent,
+			BlockHeight:        block.Height,
+			ProblemBlockHeight: &block.Height,
+			Addresses:          []string{block.Data.Problem.Address},
+			Data:               block.Data.Problem,
+		})
+	case KnapsackProposedSolutionSubmission:
+		// only proposed solutions better than the previous best are accepted, so each one takes the lead
+		bc.events.Publish(Event{
+			Type:               LeaderChangedEvent,
+			BlockHeight:        block.Height,
+			ProblemBlockHeight: &block.Data.Solution.ProblemBlockHeight,
+			Addresses:          []string{block.Data.Solution.Address},
+			Data:               block.Data.Solution,
+		})
+	case BountyPayoutSubmission:
+		bc.events.Publish(Event{
+			Type:               ProblemSettledEvent,
+			BlockHeight:        block.Height,
+			ProblemBlockHeight: &block.Data.Payout.ProblemBlockHeight,
+			Addresses:          blockAddresses(block),
+			Data:               block.Data.Payout,
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import "fmt"
+
+// Read-only views of the blockchain for explorers.
+// Every view is taken under bc.mutex so it is consistent with a single tip
+
+// ChainHead identifies the tip of the blockchain
+type ChainHead struct {
+	Height    int     `json:"height"`
+	Hash      string  `json:"hash"`
+	PrevHash  string  `json:"prevhash"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Work      float64 `json:"work"` // useful work of the chain, see ForkChoice.go
+	// with a validator set, the height up to which blocks are final. Every block added is
+	// final, as blocks are only added once committed by the validators
+	FinalizedHeight *int `json:"finalized_height,omitempty"`
+}
+
+// BlockPage is a range of consecutive blocks
+type BlockPage struct {
+	Blocks []Block    `json:"blocks"`
+	From   int        `json:"from"`
+	Limit  int        `json:"limit"`
+	Next   *int       `json:"next,omitempty"` // height of the first block of the next page, if any
+	Head   *ChainHead `json:"head"`
+}
+
+func (bc *Blockchain) getHead() *ChainHead {
+	lastBlock := bc.getLastBlock()
+	head := &ChainHead{
+		Height:    lastBlock.Height,
+		Hash:      lastBlock.Hash,
+		PrevHash:  lastBlock.PrevHash,
+		Timestamp: lastBlock.Timestamp,
+		Work:      bc.work.total,
+	}
+	if bc.validators != nil {
+		head.FinalizedHeight = &lastBlock.Height
+	}
+	return head
+}
+
+// GetHead returns the current tip of the blockchain
+func (bc *Blockchain) GetHead() *ChainHead {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.getHead()
+}
+
+// GetBaseHeight returns the height of the first block the chain holds the body of. It is not 0
+// for pruned chains and chains started from a snapshot
+func (bc *Blockchain) GetBaseHeight() int {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.store.Base()
+}
+
+// GetAllBlocks returns a copy of the whole blockchain
+func (bc *Blockchain) GetAllBlocks() []Block {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.blockRange(bc.store.Base(), bc.store.Tip())
+}
+
+// GetBlocks returns up to limit blocks starting at height from
+func (bc *Blockchain) GetBlocks(from int, limit int) (*BlockPage, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if base := bc.store.Base(); from < base {
+		return nil, fmt.Errorf("%w: the first block held is %v", ErrBlockPruned, base)
+	}
+	page := &BlockPage{
+		Blocks: make([]Block, 0),
+		From:   from,
+		Limit:  limit,
+		Head:   bc.getHead(),
+	}
+	page.Blocks = append(page.Blocks, bc.blockRange(from, from+limit-1)...)
+	if next := from + limit; len(page.Blocks) == limit && next <= page.Head.Height {
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// GetBlockByHeight returns the block at the given height, if it exists
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, bool) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.blockAt(height)
+}
+
+// GetBlockByHash returns the block with the given hash, if it exists
+func (bc *Blockchain) GetBlockByHash(hash string) (Block, bool) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	height, exists := bc.store.HeightOf(hash)
+	if !exists {
+		return Block{}, false
+	}
+	return bc.blockAt(height)
+}
+
+// LookupBlock returns the block at the given height, or why the chain does not hold it
+func (bc *Blockchain) LookupBlock(height int) (Block, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if block, exists := bc.blockAt(height); exists {
+		return block, nil
+	}
+	return Block{}, bc.missingBlockError(height)
+}
+
+// LookupBlockByHash returns the block with the given hash, or why the chain does not hold it
+func (bc *Blockchain) LookupBlockByHash(hash string) (Block, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	height, exists := bc.store.HeightOf(hash)
+	if !exists {
+		return Block{}, ErrBlockNotFound
+	}
+	if block, exists := bc.blockAt(height); exists {
+		return block, nil
+	}
+	return Block{}, bc.missingBlockError(height)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Fork choice of the nodes without a validator set. Every node adds the submissions it receives
+// at its own tip, so the chains of the nodes fork. The work of a chain is the useful work its
+// blocks hold, counted as follows:
+//   - each proposed solution gains value over the previous best solution of its problem,
+//     normalized by the Dantzig bound of the problem, so solving a problem to the optimum gains
+//     at most 1. The gain is scaled by the hardness score of the problem, the number of items
+//     a search branches on (see Hardness.go), so problems below the minimum of the network
+//     count for nothing
+//   - the gains only count once the problem is settled, for the solvers its payout pays from
+//     the address that posted it to another one: solutions the bounty does not reward, and the
+//     ones from the address that posted the problem, count for nothing
+//   - the work a chain gains is bounded by the time its blocks span: MAX_WORK_PER_SECOND per
+//     second of their timestamps, saved up to MAX_WORK_BURST. Timestamps cannot run ahead of
+//     the clocks of the nodes, so neither can the work of a chain made up in a hurry
+//
+// Nothing of the bounty is burned, so self-posted problems are not priced out: a poster solving
+// its own problems from a second address is paid its bounty back, and gains their work at no
+// cost but the solving. Only the hardness minimum and the bound on the work per second limit
+// what such a chain gains over the others
+//
+// Nodes regularly compare their chain with the chains of their peers, and switch to the branch
+// of a peer whose chain does more work. The branch is replayed from genesis to check it and
+// measure its work, and the submissions of the blocks dropped are added again at the new tip.
+// A peer whose branch fails to replay, or does less work than it claimed, is left alone for
+// FORK_CHOICE_PENALTY, doubling with each failure in a row
+
+// WorkIndex sums the useful work of the blocks of a chain, from its first body held
+type WorkIndex struct {
+	total     float64
+	budget    float64              // work the chain may still gain
+	timestamp int64                // latest timestamp of its blocks
+	open      map[int]*problemWork // work done on the problems not settled yet, by problem height
+}
+
+// problemWork is the work done on a problem, counted once its bounty is paid out
+type problemWork struct {
+	best     int                // value of the best solution
+	gains    map[string]float64 // normalized value gains of the solvers, but the poster
+	hardness float64
+}
+
+func NewWorkIndex() *WorkIndex {
+	return &WorkIndex{open: make(map[int]*problemWork)}
+}
+
+// add counts the work of the block appended to the chain. problem is the problem the block
+// solves, nil when it is not a proposed solution or the problem is not held
+func (index *WorkIndex) add(block Block, problem *KnapsackProblem) {
+	if block.Timestamp > index.timestamp {
+		if index.timestamp > 0 {
+			elapsed := float64(block.Timestamp-index.timestamp) / 1000
+			index.budget = min(index.budget+elapsed*MAX_WORK_PER_SECOND, MAX_WORK_BURST)
+		}
+		index.timestamp = block.Timestamp
+	}
+
+	switch {
+	case problem != nil:
+		index.addSolution(*problem, *block.Data.Solution)
+	case block.Data.Type == BountyPayoutSubmission && block.Data.Payout != nil:
+		work := index.payoutWork(*block.Data.Payout)
+		gained := min(work, index.budget)
+		index.total += gained
+		index.budget -= gained
+	}
+	// problems are settled right after their solution window, unless nobody solved them
+	for height := range index.open {
+		if height < block.Height-2*NUMBER_OF_BLOCKS_TO_SOLUTION {
+			delete(index.open, height)
+		}
+	}
+}
+
+// addSolution records the gain of a proposed solution over the previous best value of its problem
+func (index *WorkIndex) addSolution(problem KnapsackProblem, solution KnapsackProposedSolution) {
+	work, exists := index.open[solution.ProblemBlockHeight]
+	if !exists {
+		if checkHardness(problem) != nil {
+			return
+		}
+		work = &problemWork{gains: make(map[string]float64), hardness: EstimateHardness(problem).Score}
+		index.open[solution.ProblemBlockHeight] = work
+	}
+	previous := work.best
+	work.best = max(previous, solution.Value)
+	if bound := DantzigBound(problem); bound > 0 && solution.Value > previous && solution.Address != problem.Address {
+		work.gains[solution.Address] += float64(solution.Value-previous) / float64(bound)
+	}
+}
+
+// payoutWork returns the work of the solvers a payout pays the bounty of their problem to
+func (index *WorkIndex) payoutWork(payout BountyPayout) float64 {
+	work, exists := index.open[payout.ProblemBlockHeight]
+	if !exists {
+		return 0
+	}
+	delete(index.open, payout.ProblemBlockHeight)
+	total := 0.0
+	for _, tx := range payout.Transactions {
+		if tx.Amount > 0 && tx.From != tx.To {
+			total += work.gains[tx.To] * work.hardness
+		}
+	}
+	return total
+}
+
+// solvedProblem returns the problem a proposed solution block solves, nil for other blocks
+// and when the problem is not held. The caller must hold bc.mutex
+func (bc *Blockchain) solvedProblem(block Block) *KnapsackProblem {
+	if block.Data.Type != KnapsackProposedSolutionSubmission || block.Data.Solution == nil {
+		return nil
+	}
+	problemBlock, exists := bc.blockAt(block.Data.Solution.ProblemBlockHeight)
+	if !exists || problemBlock.Data.Type != KnapsackProblemSubmission {
+		return nil
+	}
+	return problemBlock.Data.Problem
+}
+
+// heavierThan tells whether the chain of head is preferred to the chain of other: it does
+// more work, or as much with more blocks. Chains doing as much work with as many blocks are
+// ordered by the hash of their tip, so that the nodes choose the same one
+func (head *ChainHead) heavierThan(other *ChainHead) bool {
+	if head.Work != other.Work {
+		return head.Work > other.Work
+	}
+	if head.Height != other.Height {
+		return head.Height > other.Height
+	}
+	return head.Hash < other.Hash
+}
+
+// Reorganize switches the chain to a branch forking from it, when the chain it leads to is
+// preferred to the chain. The branch holds the blocks after the last block both chains hold.
+// It tells whether the chain switched, and returns the head of the chain the branch leads to.
+// A branch failing to replay returns ErrInvalidBranch
+func (bc *Blockchain) Reorganize(branch []Block, ledger *Ledger) (bool, *ChainHead, error) {
+	if len(branch) == 0 {
+		return false, nil, nil
+	}
+	fork := branch[0].Height - 1
+
+	bc.mutex.Lock()
+	if bc.store.HeaderBase() > 0 || bc.store.Base() > 0 {
+		bc.mutex.Unlock()
+		return false, nil, fmt.Errorf("%w: cannot replay the branch forking after block %v", ErrBlockPruned, fork)
+	}
+	if forkBlock, exists := bc.blockAt(fork); !exists || forkBlock.Hash != branch[0].PrevHash {
+		bc.mutex.Unlock()
+		return false, nil, fmt.Errorf("%w: block %v does not follow the chain", ErrBlockNotChained, branch[0].Height)
+	}
+	blocks := append(bc.blockRange(0, fork), branch...)
+	tip := bc.getHead()
+	clock := bc.clock
+	bc.mutex.Unlock()
+
+	// the branch is checked without holding the chain, which may take a while
+	candidate, err := replayBlocks(blocks, NewLedger(), clock)
+	if err != nil {
+		return false, nil, fmt.Errorf("%w: %v", ErrInvalidBranch, err)
+	}
+	head := candidate.GetHead()
+	if !head.heavierThan(tip) {
+		return false, head, nil
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if bc.getLastBlock().Hash != tip.Hash {
+		// blocks were added meanwhile, the chains are compared again next time
+		return false, head, nil
+	}
+	dropped := bc.blockRange(fork+1, tip.Height)
+	adopted := candidate.GetAllBlocks()
+	batch := Batch{
+		Reset:    true,
+		Blocks:   adopted,
+		Balances: candidate.store.Balances(),
+		Nonces:   candidate.store.Nonces(),
+		Snapshot: candidate.store.Snapshot(),
+	}
+	if err := bc.store.Write(batch); err != nil {
+		logErrorf("Failed to switch to the branch forking after block %v: %v", fork, err)
+		return false, head, err
+	}
+	bc.addressIndex = candidate.addressIndex
+	bc.work = candidate.work
+	adopted = adopted[fork+1:]
+	log.Printf("Switched to a branch doing more work after block %v: %v blocks dropped, %v added", fork, len(dropped), len(adopted))
+
+	bc.events.Publish(Event{
+		Type:        ChainReorganizedEvent,
+		BlockHeight: fork,
+		Addresses:   []string{},
+		Data:        bc.getHead(),
+	})
+	for _, block := range adopted {
+		bc.publishBlockEvents(block)
+	}
+	bc.readd(dropped, adopted, fork, ledger)
+	bc.prune()
+	return true, head, nil
+}
+
+// readd adds the submissions of the blocks dropped by a switch to another branch again at the
+// tip, unless the branch holds them. Solutions to problems of the dropped blocks are not, as
+// the heights of the problems differ in the branch. The caller must hold bc.mutex
+func (bc *Blockchain) readd(dropped []Block, adopted []Block, fork int, ledger *Ledger) {
+	held := make(map[string]bool)
+	for _, block := range adopted {
+		held[entryID(block.Data)] = true
+	}
+	for _, block := range dropped {
+		data := block.Data
+		if data.Type == BountyPayoutSubmission || held[entryID(data)] {
+			continue
+		}
+		if data.Type == KnapsackProposedSolutionSubmission && data.Solution.ProblemBlockHeight > fork {
+			continue
+		}
+		newBlock, err := bc.generateNewBlock(data)
+		if err == nil {
+			err = bc.addBlock(newBlock, ledger)
+		}
+		if err != nil {
+			logDebugf("Dropping the submission of block %v: %v", block.Height, err)
+		}
+	}
+}
+
+// ForkChoice keeps the chain of a node without a validator set on the chain of its peers
+// doing the most work
+type ForkChoice struct {
+	bc        *Blockchain
+	ledger    *Ledger
+	peers     []string // API URLs
+	transport Transport
+	penalties map[string]*peerPenalty
+}
+
+// peerPenalty holds off the comparisons with a peer whose branches failed to replay
+type peerPenalty struct {
+	until    time.Time
+	failures int // in a row
+}
+
+func NewForkChoice(bc *Blockchain, ledger *Ledger, peerURLs []string, transport Transport) *ForkChoice {
+	return &ForkChoice{bc: bc, ledger: ledger, peers: peerURLs, transport: transport, penalties: make(map[string]*peerPenalty)}
+}
+
+// Run compares the chain with the chains of the peers every FORK_CHOICE_INTERVAL
+func (f *ForkChoice) Run() {
+	for {
+		f.sync()
+		f.bc.clock.Sleep(FORK_CHOICE_INTERVAL)
+	}
+}
+
+// sync switches the chain to the branch of any peer whose chain does more work
+func (f *ForkChoice) sync() {
+	if f.bc.GetBaseHeight() > 0 {
+		// without the blocks before a fork, a branch cannot be replayed
+		return
+	}
+	for _, peer := range f.peers {
+		if err := f.syncWith(peer); err != nil {
+			logDebugf("Failed to compare the chain with the one of %v: %v", peer, err)
+		}
+	}
+}
+
+func (f *ForkChoice) syncWith(peer string) error {
+	if penalty, exists := f.penalties[peer]; exists && f.bc.clock.Now().Before(penalty.until) {
+		return nil
+	}
+	head, err := f.transport.GetHead(context.Background(), peer)
+	if err != nil {
+		return err
+	}
+	if !head.heavierThan(f.bc.GetHead()) {
+		return nil
+	}
+	branch, err := f.fetchBranch(peer, head.Height)
+	if err != nil {
+		return err
+	}
+	switched, replayed, err := f.bc.Reorganize(branch, f.ledger)
+	// the peer may have switched branches meanwhile, so only the work of its head is checked.
+	// A head the chain holds does no more work than its tip
+	if err == nil && len(branch) == 0 {
+		err = fmt.Errorf("%w: %v claims %v work at block %v, which the chain holds", ErrInvalidBranch, peer, head.Work, head.Height)
+	}
+	if err == nil && replayed != nil && replayed.Hash == head.Hash && replayed.Work < head.Work {
+		err = fmt.Errorf("%w: %v does %v work, not %v", ErrInvalidBranch, peer, replayed.Work, head.Work)
+	}
+	switch {
+	case errors.Is(err, ErrInvalidBranch):
+		f.penalize(peer, err)
+	case err == nil:
+		delete(f.penalties, peer)
+		if !switched {
+			logDebugf("Kept the chain over the one of %v", peer)
+		}
+	}
+	return err
+}
+
+// penalize leaves a peer alone for FORK_CHOICE_PENALTY, doubling with each failure in a row
+func (f *ForkChoice) penalize(peer string, err error) {
+	penalty, exists := f.penalties[peer]
+	if !exists {
+		penalty = &peerPenalty{}
+		f.penalties[peer] = penalty
+	}
+	delay := FORK_CHOICE_PENALTY
+	for i := 0; i < penalty.failures && delay < FORK_CHOICE_MAX_PENALTY; i++ {
+		delay *= 2
+	}
+	delay = min(delay, FORK_CHOICE_MAX_PENALTY)
+	penalty.failures++
+	penalty.until = f.bc.clock.Now().Add(delay)
+	logWarnf("Not comparing the chain with the one of %v for %v: %v", peer, delay, err)
+}
+
+// fetchBranch returns the blocks of the chain of peer after the last block the chain holds too.
+// They are fetched backwards from the tip of peer, a page at a time
+func (f *ForkChoice) fetchBranch(peer string, peerTip int) ([]Block, error) {
+	reversed := make([]Block, 0)
+	for to := peerTip; to >= 0; {
+		from := max(0, to-MAX_BLOCKS_PAGE_LIMIT+1)
+		blocks, err := f.transport.GetBlocks(context.Background(), peer, from, to-from+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(blocks) == 0 {
+			break
+		}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if held, exists := f.bc.GetBlockByHeight(blocks[i].Height); exists && held.Hash == blocks[i].Hash {
+				branch := make([]Block, len(reversed))
+				for j, block := range reversed {
+					branch[len(reversed)-1-j] = block
+				}
+				return branch, nil
+			}
+			reversed = append(reversed, blocks[i])
+		}
+		to = blocks[0].Height - 1
+	}
+	return nil, fmt.Errorf("%w: %v holds no block of the chain", ErrBlockNotChained, peer)
+}
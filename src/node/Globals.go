@@ -1,5 +0,0 @@
-package main
-
-// memory representation of the blockchain
-
-var AllNodePorts = []string{"3001", "3002", "3003"}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Knapsack instances are not all worth a bounty: with few items, a capacity close to the total
+// weight or to the lightest item, or values unrelated to the weights, greedy picks or brute
+// force find the optimum at once. The network estimates the hardness of the problems submitted
+// and rejects the ones below MIN_PROBLEM_ITEMS items or MIN_PROBLEM_HARDNESS, and the ones whose
+// bounty is below the minimum for their size, so that trivial problems cannot be posted to farm
+// rewards or spam the chain
+
+// Hardness estimates how hard a knapsack problem is to solve
+type Hardness struct {
+	Items         int     `json:"items"`          // items fitting in the knapsack on their own
+	CapacityRatio float64 `json:"capacity_ratio"` // capacity over the total weight of the items
+	Correlation   float64 `json:"correlation"`    // Pearson correlation of the values and weights
+	Score         float64 `json:"score"`
+}
+
+// EstimateHardness returns the hardness of a problem. Its score is the number of items fitting
+// in the knapsack, weighted by how far the capacity is from taking none or all of them, at most
+// when it takes half of the weight, and by the correlation of the values and weights: when
+// values follow weights, the value/weight ratios tell little about which items to take
+func EstimateHardness(problem KnapsackProblem) Hardness {
+	hardness := Hardness{Correlation: itemsCorrelation(problem.Items)}
+	for _, item := range problem.Items {
+		if item.Weight <= problem.Capacity {
+			hardness.Items++
+		}
+	}
+	if totalWeight := GetProblemItemsSumWeight(problem); totalWeight > 0 {
+		hardness.CapacityRatio = math.Min(1, float64(problem.Capacity)/float64(totalWeight))
+	}
+	capacityFactor := 4 * hardness.CapacityRatio * (1 - hardness.CapacityRatio)
+	correlationFactor := (1 + math.Max(0, hardness.Correlation)) / 2
+	hardness.Score = float64(hardness.Items) * capacityFactor * correlationFactor
+	return hardness
+}
+
+// itemsCorrelation returns the Pearson correlation of the values and weights of the items,
+// 0 when either does not vary
+func itemsCorrelation(items []Item) float64 {
+	if len(items) < 2 {
+		return 0
+	}
+	n := float64(len(items))
+	var meanValue, meanWeight float64
+	for _, item := range items {
+		meanValue += float64(item.Value) / n
+		meanWeight += float64(item.Weight) / n
+	}
+	var covariance, valueVariance, weightVariance float64
+	for _, item := range items {
+		value := float64(item.Value) - meanValue
+		weight := float64(item.Weight) - meanWeight
+		covariance += value * weight
+		valueVariance += value * value
+		weightVariance += weight * weight
+	}
+	if valueVariance == 0 || weightVariance == 0 {
+		return 0
+	}
+	return covariance / math.Sqrt(valueVariance*weightVariance)
+}
+
+// MinProblemBounty returns the lowest bounty accepted for a problem, which grows with its
+// number of items from MIN_PROBLEM_BOUNTY
+func MinProblemBounty(problem KnapsackProblem) float64 {
+	return math.Max(MIN_PROBLEM_BOUNTY, float64(len(problem.Items))*MIN_PROBLEM_BOUNTY_PER_ITEM)
+}
+
+// checkHardness checks a problem meets the minimum size and hardness of the network
+func checkHardness(problem KnapsackProblem) error {
+	if len(problem.Items) < MIN_PROBLEM_ITEMS {
+		return fmt.Errorf("%w: %v items, at least %v required", ErrTooFewItems, len(problem.Items), MIN_PROBLEM_ITEMS)
+	}
+	if hardness := EstimateHardness(problem); hardness.Score < MIN_PROBLEM_HARDNESS {
+		return fmt.Errorf("%w: hardness %.2f, at least %v required", ErrProblemTooEasy, hardness.Score, MIN_PROBLEM_HARDNESS)
+	}
+	return nil
+}
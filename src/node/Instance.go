@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"solvernet/client"
+)
+
+// ReadProblemFile reads a problem from a JSON file holding a KnapsackProblem (the
+// address and signature are filled in when submitting), or from a knapsack instance
+// file: the number of items and the capacity, followed by the value and weight of each item
+func ReadProblemFile(path string) (*client.KnapsackProblem, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		var problem client.KnapsackProblem
+		if err := json.Unmarshal(trimmed, &problem); err != nil {
+			return nil, fmt.Errorf("invalid problem file %v: %w", path, err)
+		}
+		return &problem, nil
+	}
+
+	problem, err := parseInstance(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance file %v: %w", path, err)
+	}
+	return problem, nil
+}
+
+func parseInstance(content string) (*client.KnapsackProblem, error) {
+	numbers := make([]int, 0)
+	for _, field := range strings.Fields(content) {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			// some instance files write integral numbers as floats
+			float, floatErr := strconv.ParseFloat(field, 64)
+			if floatErr != nil || float != float64(int(float)) {
+				return nil, fmt.Errorf("%q is not an integer", field)
+			}
+			number = int(float)
+		}
+		numbers = append(numbers, number)
+	}
+	if len(numbers) < 2 {
+		return nil, fmt.Errorf("missing number of items and capacity")
+	}
+
+	itemCount, capacity := numbers[0], numbers[1]
+	if itemCount < 1 || len(numbers) != 2+2*itemCount {
+		return nil, fmt.Errorf("expected the value and weight of %v items", itemCount)
+	}
+	problem := &client.KnapsackProblem{Capacity: capacity, Items: make([]client.Item, itemCount)}
+	for i := range problem.Items {
+		problem.Items[i] = client.Item{Value: numbers[2+2*i], Weight: numbers[3+2*i]}
+	}
+	return problem, nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"log"
+
+	"solvernet/client"
 )
 
 type KnapsackProblem struct {
@@ -10,6 +12,15 @@ type KnapsackProblem struct {
 	Capacity int     `json:"capacity"`
 	Bounty   float64 `json:"bounty"`
 	Address  string  `json:"address"` // address to send the bounty from
+	// how the bounty is split at expiry. Defaults to winner takes all
+	PayoutPolicy PayoutPolicy `json:"payout_policy,omitempty"`
+	// seconds after the problem block solutions are accepted for, within the window of
+	// NUMBER_OF_BLOCKS_TO_SOLUTION blocks. 0 accepts them for the whole window
+	ExpirySeconds int `json:"expiry_seconds,omitempty"`
+	// required when Address is derived from a public key, see Signature.go
+	Nonce     int    `json:"nonce,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 type KnapsackProposedSolution struct {
@@ -17,6 +28,12 @@ type KnapsackProposedSolution struct {
 	ProblemBlockHeight int    `json:"problem_block_height"` // Identifies the block where the problem was submitted in
 	Value              int    `json:"value"`                // This is what we are trying to maximize
 	Address            string `json:"address"`              // address to send the bounty to
+	// optional proof of optimality. Settles the problem right away when it matches Value
+	Certificate *OptimalityCertificate `json:"certificate,omitempty"`
+	// required when Address is derived from a public key, see Signature.go
+	Nonce     int    `json:"nonce,omitempty"`
+	PublicKey string `json:"public_key,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 type ProblemSolutionPair struct {
@@ -24,6 +41,15 @@ type ProblemSolutionPair struct {
 	Solution *KnapsackProposedSolution `json:"solution"`
 }
 
+// problemDeadline returns the Unix time in milliseconds solutions are no longer accepted from
+// for the problem of a block, 0 when they are accepted for the whole window
+func problemDeadline(problemBlock Block) int64 {
+	if problemBlock.Data.Problem.ExpirySeconds == 0 {
+		return 0
+	}
+	return problemBlock.Timestamp + int64(problemBlock.Data.Problem.ExpirySeconds)*1000
+}
+
 func GetProblemItemsSumWeight(problem KnapsackProblem) int {
 	sum := 0
 	for _, item := range problem.Items {
@@ -48,37 +74,69 @@ func GetTotalSolutionValue(problem KnapsackProblem, proposedSolution KnapsackPro
 	return value
 }
 
+// ValidateProblem checks a problem submitted now
 func ValidateProblem(problem KnapsackProblem, bc *Blockchain) error {
-	if problem.Bounty < 1.0 {
-		return errors.New("bounty too low")
+	return validateProblem(problem, bc, 0)
+}
+
+// validateProblem checks a problem, once the pending submissions of its sender are
+func validateProblem(problem KnapsackProblem, bc *Blockchain, pending int) error {
+	if minBounty := MinProblemBounty(problem); problem.Bounty < minBounty {
+		return fmt.Errorf("%w: %v items require a bounty of at least %v", ErrBountyTooLow, len(problem.Items), minBounty)
 	}
 
 	// check if problem has items
 	if len(problem.Items) == 0 {
-		return errors.New("no items in problem")
+		return ErrNoProblemItems
+	}
+	if len(problem.Items) > MAX_PROBLEM_ITEMS {
+		return fmt.Errorf("%w: %v items, at most %v accepted", ErrProblemTooLarge, len(problem.Items), MAX_PROBLEM_ITEMS)
 	}
 
 	// check if problem has address
 	if problem.Address == "" {
-		return errors.New("no address in problem")
+		return ErrNoProblemAddress
+	}
+
+	if err := bc.verifySignature(client.ProblemSignature, problem.Address, problem.Nonce, pending, problem.PublicKey, problem.Signature, problem); err != nil {
+		return err
+	}
+
+	if !isValidPayoutPolicy(problem.PayoutPolicy) {
+		return ErrInvalidPayoutPolicy
+	}
+
+	if problem.ExpirySeconds < 0 {
+		return ErrInvalidExpiry
 	}
 
 	// check if problem has capacity
 	if problem.Capacity < 1 {
-		return errors.New("capacity too low")
+		return ErrCapacityTooLow
+	}
+	if problem.Capacity > MAX_PROBLEM_NUMBER {
+		return fmt.Errorf("%w: capacity above %v", ErrNumberOutOfRange, MAX_PROBLEM_NUMBER)
 	}
 
-	// check if problem has items with negative/0 weight or value
+	// check if problem has items with negative/0 weight or value.
+	// Bounding them keeps the sums of the weights and values from overflowing
 	for _, item := range problem.Items {
 		if item.Weight <= 0 || item.Value <= 0 {
-			return errors.New("negative/0 weight or value")
+			return ErrInvalidItem
+		}
+		if item.Weight > MAX_PROBLEM_NUMBER || item.Value > MAX_PROBLEM_NUMBER {
+			return fmt.Errorf("%w: item weight or value above %v", ErrNumberOutOfRange, MAX_PROBLEM_NUMBER)
 		}
 	}
 
 	// check if total items weight is smaller than capacity.
 	// This makes the problem trivial and not worth solving (solution is all items)
 	if GetProblemItemsSumWeight(problem) <= problem.Capacity {
-		return errors.New("total items weight is smaller than capacity. Trivial problem not allowed")
+		return ErrTrivialProblem
+	}
+
+	if err := checkHardness(problem); err != nil {
+		return err
 	}
 
 	// (TODO) A node cannot submit a new problem if it do not have the amount of tokens to pay the bounty
@@ -86,33 +144,57 @@ func ValidateProblem(problem KnapsackProblem, bc *Blockchain) error {
 	return nil
 }
 
+// ValidateProposedSolution checks a proposed solution submitted now
 func ValidateProposedSolution(proposedSolution KnapsackProposedSolution, bc *Blockchain) error {
-	if proposedSolution.ProblemBlockHeight >= len(bc.Blocks) {
-		return errors.New("invalid proposed solution block height. Value too big")
+	return validateProposedSolutionAt(proposedSolution, bc, bc.nextTimestamp(), MAX_DP_CERTIFICATE_CELLS, 0)
+}
+
+// validateProposedSolutionAt checks a proposed solution submitted in a block with the given
+// timestamp, once the pending submissions of its sender are. DP certificates are checked against
+// problems of up to maxCells cells, or already solved
+func validateProposedSolutionAt(proposedSolution KnapsackProposedSolution, bc *Blockchain, timestamp int64, maxCells int, pending int) error {
+	currentHeight := bc.getLastBlock().Height
+	if proposedSolution.ProblemBlockHeight < 0 || proposedSolution.ProblemBlockHeight > currentHeight {
+		return ErrProblemNotFound
 	}
 	// cannot submit a solution for a block that has already expired/solved
-	if proposedSolution.ProblemBlockHeight < len(bc.Blocks)-NUMBER_OF_BLOCKS_TO_SOLUTION {
-		return errors.New("invalid proposed solution block height. Value too small")
+	if proposedSolution.ProblemBlockHeight < currentHeight+1-NUMBER_OF_BLOCKS_TO_SOLUTION {
+		return ErrProblemExpired
 	}
 
 	//check if solution has items
 	if len(proposedSolution.ItemIndexes) == 0 {
-		return errors.New("no items in solution")
+		return ErrNoSolutionItems
+	}
+	if len(proposedSolution.ItemIndexes) > MAX_PROBLEM_ITEMS {
+		return fmt.Errorf("%w: %v items, at most %v accepted", ErrSolutionTooLarge, len(proposedSolution.ItemIndexes), MAX_PROBLEM_ITEMS)
 	}
 
 	//check if solution has address
 	if proposedSolution.Address == "" {
-		return errors.New("no address in solution")
+		return ErrNoSolutionAddress
+	}
+
+	if err := bc.verifySignature(client.SolutionSignature, proposedSolution.Address, proposedSolution.Nonce, pending, proposedSolution.PublicKey, proposedSolution.Signature, proposedSolution); err != nil {
+		return err
 	}
 
 	block := bc.GetBlock(proposedSolution.ProblemBlockHeight)
 	if block.Data.Type != KnapsackProblemSubmission {
-		return errors.New("block at height does not contain a problem")
+		return ErrProblemNotFound
 	}
 
 	//check there is a problem at height position
 	if block.Data.Problem == nil {
-		return errors.New("block at height does not contain a problem")
+		return ErrProblemNotFound
+	}
+
+	if bc.findPayout(proposedSolution.ProblemBlockHeight) != nil {
+		return ErrProblemSettled
+	}
+
+	if deadline := problemDeadline(block); deadline > 0 && timestamp >= deadline {
+		return fmt.Errorf("%w: its deadline passed", ErrProblemExpired)
 	}
 
 	problem := block.Data.Problem
@@ -121,27 +203,36 @@ func ValidateProposedSolution(proposedSolution KnapsackProposedSolution, bc *Blo
 		indexMap[i] = true
 	}
 	if len(indexMap) != len(proposedSolution.ItemIndexes) {
-		return errors.New("duplicate item indexes")
+		return ErrDuplicateItemIndexes
 	}
 	for _, index := range proposedSolution.ItemIndexes {
 		if index < 0 || index >= len(problem.Items) {
-			return errors.New("invalid item index")
+			return ErrInvalidItemIndex
 		}
 	}
 	weight := GetTotalSolutionWeight(*problem, proposedSolution)
 
 	if weight > problem.Capacity {
-		return errors.New("solution exceeds capacity")
+		return ErrCapacityExceeded
 	}
 
 	value := GetTotalSolutionValue(*problem, proposedSolution)
 	if value != proposedSolution.Value {
 		log.Println("Invalid proposed solution", value, proposedSolution.Value)
-		return errors.New("solution value does not match")
+		return ErrValueMismatch
+	}
+
+	if proposedSolution.Certificate != nil {
+		if err := bc.verifySolutionCertificate(block, *proposedSolution.Certificate, maxCells); err != nil {
+			return err
+		}
+		if proposedSolution.Certificate.UpperBound < value {
+			return fmt.Errorf("%w: upper bound is lower than the solution value", ErrInvalidCertificate)
+		}
 	}
 
 	if !bc.checkIfIsBestProposedSolution(&proposedSolution) {
-		return errors.New("solution is not better than previous solution")
+		return ErrSolutionNotBetter
 	}
 
 	return nil
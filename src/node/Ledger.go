@@ -1,58 +1,81 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"sync"
 )
 
 // Ledger encapsulates the financial state of the blockchain participants
-// by mapping addresses to balances
+// by mapping addresses to balances. The balances are kept in a store, along with the blocks
 type Ledger struct {
-	mutex            sync.Mutex
+	store Store
+}
+
+// ledgerJSON is the JSON of a ledger
+type ledgerJSON struct {
 	AddressToBalance map[string]float64 `json:"address_to_balance"`
 }
 
-// NewLedger creates a new Ledger with initialized map
+// NewLedger creates a new Ledger kept in memory
 func NewLedger() *Ledger {
-	return &Ledger{
-		AddressToBalance: make(map[string]float64),
-		mutex:            sync.Mutex{},
-	}
+	return NewStoreLedger(NewMemoryStore())
 }
 
-// Update updates the state with a new block
-func (ledger *Ledger) Update(block Block) error {
+// NewStoreLedger creates a Ledger kept in store. A blockchain created with the ledger keeps its blocks in the same store
+func NewStoreLedger(store Store) *Ledger {
+	return &Ledger{store: store}
+}
 
-	if block.Data.Type != MonetaryTransaction {
-		return fmt.Errorf("cannot update Ledger. block type is not monetary transaction")
+// GetBalance returns the balance of an address. Unknown addresses have the initial balance
+func (ledger *Ledger) GetBalance(address string) float64 {
+	balance, exists := ledger.store.Balance(address)
+	if !exists {
+		return ADDRESS_INITIAL_BALANCE
 	}
+	return balance
+}
 
-	// Update balances for transactions
-	return ledger.addMonetaryTransaction(block)
+// Balances returns a copy of the balances of the addresses that have been part of a transfer
+func (ledger *Ledger) Balances() map[string]float64 {
+	return ledger.store.Balances()
+}
 
+// MarshalJSON serializes the balances
+func (ledger *Ledger) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ledgerJSON{ledger.store.Balances()})
 }
 
-// addMonetaryTransaction processes monetary transactions from a block
-func (ledger *Ledger) addMonetaryTransaction(block Block) error {
-	ledger.mutex.Lock()
-	defer ledger.mutex.Unlock()
-	tx := block.Data.Transaction
-	if tx == nil {
-		return fmt.Errorf("transaction data not found")
+// balancesAfter returns the balances a transfer or payout block leads to, for the addresses it touches
+func (ledger *Ledger) balancesAfter(block Block) (map[string]float64, error) {
+	var transactions []Transaction
+	switch block.Data.Type {
+	case MonetaryTransaction:
+		if block.Data.Transaction == nil {
+			return nil, fmt.Errorf("transaction data not found")
+		}
+		transactions = []Transaction{*block.Data.Transaction}
+	case BountyPayoutSubmission:
+		if block.Data.Payout == nil {
+			return nil, fmt.Errorf("payout data not found")
+		}
+		transactions = block.Data.Payout.Transactions
+	default:
+		return nil, fmt.Errorf("cannot update Ledger. block type is not monetary transaction")
 	}
 
-	// Initialize balances if not already present
-	if _, exists := ledger.AddressToBalance[tx.From]; !exists {
-		ledger.AddressToBalance[tx.From] = ADDRESS_INITIAL_BALANCE
+	balances := make(map[string]float64)
+	balance := func(address string) float64 {
+		if balance, changed := balances[address]; changed {
+			return balance
+		}
+		return ledger.GetBalance(address)
 	}
-	if _, exists := ledger.AddressToBalance[tx.To]; !exists {
-		ledger.AddressToBalance[tx.To] = ADDRESS_INITIAL_BALANCE
+	for _, tx := range transactions {
+		balances[tx.From] = balance(tx.From) - tx.Amount
+		balances[tx.To] = balance(tx.To) + tx.Amount
 	}
-	// Update balances
-	ledger.AddressToBalance[tx.From] -= tx.Amount
-	ledger.AddressToBalance[tx.To] += tx.Amount
-	return nil
+	return balances, nil
 }
 
 // This will be used when reading from mass data storage or network
@@ -63,11 +86,28 @@ func CreateLedgerFromBlockchain(bc *Blockchain) (*Ledger, error) {
 	// log the action
 	log.Println("Creating ledger from blockchain")
 
-	for _, block := range bc.Blocks {
-		if block.Data.Type == MonetaryTransaction {
-			// Update balances for transactions
-			newLedger.addMonetaryTransaction(block)
+	// a pruned chain, or one started from a snapshot, does not hold the blocks the balances of its snapshot come from
+	from := 0
+	if bc.store.Base() > 0 {
+		snapshot := bc.store.Snapshot()
+		if err := newLedger.store.Write(Batch{Balances: snapshot.State.Balances}); err != nil {
+			return nil, err
+		}
+		from = snapshot.Height + 1
+	}
+	err := bc.store.Range(from, bc.store.Tip(), func(block Block) error {
+		if block.Data.Type != MonetaryTransaction && block.Data.Type != BountyPayoutSubmission {
+			return nil
+		}
+		balances, err := newLedger.balancesAfter(block)
+		if err != nil {
+			// a block without its data changes no balance, as when it was added
+			return nil
 		}
+		return newLedger.store.Write(Batch{Balances: balances})
+	})
+	if err != nil {
+		return nil, err
 	}
 	return newLedger, nil
 }
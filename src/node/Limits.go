@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits protect the API of a node against oversized and abusive requests. The network rejects
+// the problems beyond MAX_PROBLEM_ITEMS items or MAX_PROBLEM_NUMBER (see ValidateProblem), in
+// blocks as in submissions. A node may accept smaller submissions through its API than the
+// blocks it adds, and bounds the size of the request bodies and archives imported, the rate of
+// the requests of each client IP and the connections it holds, each client IP and all together
+
+// Limits are the limits of the API of a node. 0 disables a limit
+type Limits struct {
+	MaxBodyBytes        int64
+	MaxProblemItems     int
+	MaxNumber           int // largest weight, value or capacity of a problem submitted
+	MaxSolutionItems    int
+	RateLimit           float64 // requests per second of a client IP
+	RateBurst           int     // requests a client IP may send at once, above the rate
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	MaxImportBlocks     int // blocks of an archive imported through the API
+}
+
+// SetLimits sets the limits of the submissions the chain accepts through the API
+func (bc *Blockchain) SetLimits(limits Limits) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	bc.limits = limits
+}
+
+func (bc *Blockchain) getLimits() Limits {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.limits
+}
+
+// checkLimits checks a submission received through the API is within the limits of the node
+func (bc *Blockchain) checkLimits(data BlockData) error {
+	return bc.getLimits().checkSubmission(data)
+}
+
+// checkSubmission checks a submission is within the limits
+func (limits Limits) checkSubmission(data BlockData) error {
+	if problem := data.Problem; data.Type == KnapsackProblemSubmission && problem != nil {
+		if limits.MaxProblemItems > 0 && len(problem.Items) > limits.MaxProblemItems {
+			return fmt.Errorf("%w: %v items, this node accepts at most %v", ErrProblemTooLarge, len(problem.Items), limits.MaxProblemItems)
+		}
+		if limits.MaxNumber > 0 {
+			if problem.Capacity > limits.MaxNumber {
+				return fmt.Errorf("%w: this node accepts capacities up to %v", ErrNumberOutOfRange, limits.MaxNumber)
+			}
+			for _, item := range problem.Items {
+				if item.Weight > limits.MaxNumber || item.Value > limits.MaxNumber {
+					return fmt.Errorf("%w: this node accepts item weights and values up to %v", ErrNumberOutOfRange, limits.MaxNumber)
+				}
+			}
+		}
+	}
+	if solution := data.Solution; data.Type == KnapsackProposedSolutionSubmission && solution != nil {
+		if limits.MaxSolutionItems > 0 && len(solution.ItemIndexes) > limits.MaxSolutionItems {
+			return fmt.Errorf("%w: %v items, this node accepts at most %v", ErrSolutionTooLarge, len(solution.ItemIndexes), limits.MaxSolutionItems)
+		}
+	}
+	return nil
+}
+
+// clientIP returns the IP of the remote address of a request or connection
+func clientIP(remoteAddress string) string {
+	if host, _, err := net.SplitHostPort(remoteAddress); err == nil {
+		return host
+	}
+	return remoteAddress
+}
+
+// isLoopback tells whether the remote address of a request is on the host of the node
+func isLoopback(remoteAddress string) bool {
+	ip := net.ParseIP(clientIP(remoteAddress))
+	return ip != nil && ip.IsLoopback()
+}
+
+// rateLimiter holds a token bucket per client IP, refilled at rate tokens per second up to burst
+type rateLimiter struct {
+	mutex     sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	clock     Clock
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(rate float64, burst int, clock Clock) *rateLimiter {
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		clock:     clock,
+		lastSweep: clock.Now(),
+	}
+}
+
+// allow takes a token of the bucket of ip. Without one, it returns how long until the next
+func (limiter *rateLimiter) allow(ip string) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := limiter.clock.Now()
+	limiter.sweep(now)
+	bucket, exists := limiter.buckets[ip]
+	if !exists {
+		bucket = &tokenBucket{tokens: limiter.burst, updated: now}
+		limiter.buckets[ip] = bucket
+	}
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*limiter.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets of the client IPs idle for RATE_LIMIT_IDLE, which are full again.
+// The caller must hold limiter.mutex
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < RATE_LIMIT_IDLE {
+		return
+	}
+	for ip, bucket := range limiter.buckets {
+		if now.Sub(bucket.updated) >= RATE_LIMIT_IDLE {
+			delete(limiter.buckets, ip)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+// limitRequests rate limits the requests of each client IP and bounds the size of their
+// bodies. Archives imported are bounded by their number of blocks instead, and only accepted
+// from localhost
+func limitRequests(limits Limits, clock Clock, next http.Handler) http.Handler {
+	var limiter *rateLimiter
+	if limits.RateLimit > 0 {
+		limiter = newRateLimiter(limits.RateLimit, limits.RateBurst, clock)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil {
+			if allowed, wait := limiter.allow(clientIP(r.RemoteAddr)); !allowed {
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				respondWithError(w, fmt.Errorf("%w: at most %v per second. Retry in %vs", ErrRateLimited, limits.RateLimit, retryAfter))
+				return
+			}
+		}
+		if limits.MaxBodyBytes > 0 && r.URL.Path != "/api/import" {
+			r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitListener bounds the connections accepted by a listener, of each client IP and all
+// together. Connections over the limits are answered with a 503 and closed
+type limitListener struct {
+	net.Listener
+	limits Limits
+	mutex  sync.Mutex
+	total  int
+	byIP   map[string]int
+}
+
+func newLimitListener(listener net.Listener, limits Limits) net.Listener {
+	if limits.MaxConnections == 0 && limits.MaxConnectionsPerIP == 0 {
+		return listener
+	}
+	return &limitListener{Listener: listener, limits: limits, byIP: make(map[string]int)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := clientIP(conn.RemoteAddr().String())
+		if err := l.acquire(ip); err != nil {
+			logDebugf("Rejected a connection from %v: %v", ip, err)
+			go rejectConnection(conn, err)
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// acquire counts a connection of ip, unless it is over the limits
+func (l *limitListener) acquire(ip string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.limits.MaxConnections > 0 && l.total >= l.limits.MaxConnections {
+		return fmt.Errorf("%w: the node holds %v", ErrTooManyConnections, l.total)
+	}
+	if l.limits.MaxConnectionsPerIP > 0 && l.byIP[ip] >= l.limits.MaxConnectionsPerIP {
+		return fmt.Errorf("%w: at most %v per client IP", ErrTooManyConnections, l.limits.MaxConnectionsPerIP)
+	}
+	l.total++
+	l.byIP[ip]++
+	return nil
+}
+
+func (l *limitListener) release(ip string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.total--
+	if l.byIP[ip]--; l.byIP[ip] == 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// limitedConn releases its slot of the listener once closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// rejectConnection answers a connection over the limits with the error, before any request
+func rejectConnection(conn net.Conn, err error) {
+	defer conn.Close()
+
+	_, status := classifyError(err)
+	body, _ := json.Marshal(ErrorResponse{Error: *newErrorBody(err)})
+	conn.SetWriteDeadline(time.Now().Add(REJECT_CONNECTION_TIMEOUT))
+	fmt.Fprintf(conn, "HTTP/1.1 %v %v\r\nContent-Type: application/json\r\nContent-Length: %v\r\nConnection: close\r\n\r\n%s",
+		status, http.StatusText(status), len(body), body)
+}
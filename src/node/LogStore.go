@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The chain log is the file of the data dir a node keeps its store in. Every batch is a record,
+// framed as the records of archives, appended and synced before the batch is applied. The
+// index of the blocks is rebuilt from the log when it is opened, and the last bodies are cached.
+// Pruning compacts the log: it is rewritten without the pruned bodies, and replaces the old one.
+// A batch resetting the store, as the chain switches to another branch, replaces the log too,
+// with a record per body as a compacted log, so that a body is read without the others.
+// A validator also logs its lock and its own proposals and votes, see Consensus.go
+
+var errTornRecord = errors.New("torn record")
+
+// logRecord is a record of the chain log
+type logRecord struct {
+	Batch
+	Headers []BlockHeader `json:"headers,omitempty"` // of the pruned blocks, in the first record of a compacted log
+}
+
+// blockLocation is where the body of a block is in the log
+type blockLocation struct {
+	offset int64 // of the record holding it
+	index  int   // of the block in the record
+}
+
+// LogStore is a store kept in an append-only log
+type LogStore struct {
+	storeIndex
+	path      string
+	file      *os.File
+	size      int64 // of the records applied
+	readOnly  bool
+	locations []blockLocation // of the bodies from base to the tip
+	recent    map[int]Block   // the last LOG_STORE_CACHE_SIZE bodies
+}
+
+// OpenLogStore opens the log at path, creating it if needed. A torn record at the end of the
+// log, left by a crash while it was written, is dropped. A read-only store leaves the log as
+// it is, and cannot be written
+func OpenLogStore(path string, readOnly bool) (*LogStore, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if !readOnly {
+		// left by a compaction that did not complete
+		os.Remove(path + ".tmp")
+	}
+
+	store := &LogStore{
+		storeIndex: newStoreIndex(),
+		path:       path,
+		file:       file,
+		readOnly:   readOnly,
+		recent:     make(map[int]Block),
+	}
+	if err := store.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// load applies the records of the log
+func (store *LogStore) load() error {
+	info, err := store.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	reader := bufio.NewReader(io.NewSectionReader(store.file, 0, size))
+
+	for store.size < size {
+		record, length, err := readLogRecord(reader, size-store.size)
+		if err == nil {
+			err = store.check(record.Batch)
+		}
+		if errors.Is(err, errTornRecord) && store.readOnly {
+			return nil
+		}
+		if errors.Is(err, errTornRecord) {
+			logWarnf("Dropping the torn record at the end of %v, at offset %v", store.path, store.size)
+			if err := store.file.Truncate(store.size); err != nil {
+				return err
+			}
+			return store.file.Sync()
+		}
+		if err != nil {
+			return fmt.Errorf("%v is corrupt at offset %v: %w", store.path, store.size, err)
+		}
+		store.applyRecord(record, store.size)
+		store.size += length
+	}
+	return nil
+}
+
+// readLogRecord reads a record and returns its length. A record going past the end of the log,
+// or failing its checksum at the end of the log, is torn
+func readLogRecord(r io.Reader, remaining int64) (logRecord, int64, error) {
+	var record logRecord
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return record, 0, errTornRecord
+	}
+	recordLength := int64(length) + 8
+	if recordLength > remaining {
+		return record, 0, errTornRecord
+	}
+
+	payload := make([]byte, length+4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return record, 0, err
+	}
+	checksum := binary.BigEndian.Uint32(payload[length:])
+	payload = payload[:length]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		if recordLength == remaining {
+			return record, 0, errTornRecord
+		}
+		return record, 0, errors.New("record checksum does not match")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, err
+	}
+	return record, recordLength, nil
+}
+
+// applyRecord applies a record read or written at offset. The caller must hold store.mutex,
+// except while loading
+func (store *LogStore) applyRecord(record logRecord, offset int64) {
+	if len(record.Headers) > 0 {
+		store.headers = record.Headers
+		store.headerBase = record.Headers[0].Height
+		store.tip = record.Headers[len(record.Headers)-1].Height
+		store.base = store.tip + 1
+		for _, header := range record.Headers {
+			store.hashToHeight[header.Hash] = header.Height
+		}
+	}
+	store.apply(record.Batch)
+	for i, block := range record.Blocks {
+		store.locations = append(store.locations, blockLocation{offset, i})
+		store.cache(block)
+	}
+}
+
+// cache keeps the body of a block just added, and forgets an older one. The caller must hold store.mutex
+func (store *LogStore) cache(block Block) {
+	store.recent[block.Height] = block
+	delete(store.recent, block.Height-LOG_STORE_CACHE_SIZE)
+}
+
+// block returns the body at height, if held. The caller must hold store.mutex
+func (store *LogStore) block(height int) (Block, bool, error) {
+	if height < store.base || height > store.tip {
+		return Block{}, false, nil
+	}
+	if block, cached := store.recent[height]; cached {
+		return block, true, nil
+	}
+	location := store.locations[height-store.base]
+	remaining := store.size - location.offset
+	record, _, err := readLogRecord(io.NewSectionReader(store.file, location.offset, remaining), remaining)
+	if err != nil {
+		return Block{}, false, err
+	}
+	return record.Blocks[location.index], true, nil
+}
+
+func (store *LogStore) Block(height int) (Block, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	block, held, err := store.block(height)
+	if err != nil {
+		logErrorf("Failed to read block %v from %v: %v", height, store.path, err)
+	}
+	return block, held
+}
+
+func (store *LogStore) Header(height int) (BlockHeader, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if block, held, err := store.block(height); err == nil && held {
+		return headerOf(block), true
+	}
+	return store.header(height)
+}
+
+// Range reads the bodies one at a time, so the store is not locked while they are visited
+func (store *LogStore) Range(from int, to int, visit func(Block) error) error {
+	for height := max(from, store.Base()); height <= to; height++ {
+		store.mutex.Lock()
+		if height > store.tip {
+			store.mutex.Unlock()
+			return nil
+		}
+		block, held, err := store.block(height)
+		store.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+		if !held {
+			return fmt.Errorf("%w: block %v was pruned meanwhile", ErrBlockPruned, height)
+		}
+		if err := visit(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *LogStore) Write(batch Batch) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.readOnly {
+		return ErrReadOnlyStore
+	}
+	if err := store.check(batch); err != nil {
+		return err
+	}
+
+	// the pruning is not logged: the log is compacted instead
+	record := logRecord{Batch: batch}
+	record.PruneBelow = 0
+	if batch.Reset {
+		if err := store.reset(record); err != nil {
+			return err
+		}
+	} else if len(batch.Blocks) > 0 || len(batch.Balances) > 0 || len(batch.Nonces) > 0 || batch.Snapshot != nil || batch.Consensus != nil {
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		offset := store.size
+		if err := store.append(encoded); err != nil {
+			return err
+		}
+		store.applyRecord(record, offset)
+	}
+
+	if batch.PruneBelow > store.base {
+		return store.compact(batch.PruneBelow)
+	}
+	return nil
+}
+
+// append writes a record at the end of the log and waits for it to be on disk. A record that
+// failed to be written is cut off, so the next ones do not follow a torn one. The caller must
+// hold store.mutex
+func (store *LogStore) append(encoded []byte) error {
+	_, err := store.file.WriteAt(encoded, store.size)
+	if err == nil {
+		err = store.file.Sync()
+	}
+	if err != nil {
+		store.file.Truncate(store.size)
+		return err
+	}
+	store.size += int64(len(encoded))
+	return nil
+}
+
+// compact rewrites the log without the bodies below height: a record with the headers of the
+// pruned blocks and the state, then a record per body kept. The new log replaces the old one
+// at once, so a crash leaves one or the other. The caller must hold store.mutex
+func (store *LogStore) compact(height int) error {
+	pruned := make([]BlockHeader, 0, height-store.base)
+	for prunedHeight := store.base; prunedHeight < height; prunedHeight++ {
+		block, _, err := store.block(prunedHeight)
+		if err != nil {
+			return err
+		}
+		pruned = append(pruned, headerOf(block))
+	}
+
+	var locations []blockLocation
+	err := store.replaceLog(func(file *os.File) (int64, error) {
+		var size int64
+		var err error
+		locations, size, err = store.writeCompacted(file, height, pruned)
+		return size, err
+	})
+	if err != nil {
+		return err
+	}
+	store.locations = locations
+	store.prune(pruned)
+	logDebugf("Compacted %v, pruning the bodies below %v", store.path, height)
+	return nil
+}
+
+// reset replaces the log with one holding the batch emptying the store: a record with the
+// state, then a record per body. The caller must hold store.mutex
+func (store *LogStore) reset(record logRecord) error {
+	// the new log starts empty, so its records do not need to empty it. The record of the
+	// validator is kept
+	first := logRecord{Batch: Batch{Balances: record.Balances, Nonces: record.Nonces, Snapshot: record.Snapshot, Consensus: record.Consensus}}
+	if first.Consensus == nil {
+		first.Consensus = store.consensus
+	}
+	var locations []blockLocation
+	err := store.replaceLog(func(file *os.File) (int64, error) {
+		var size int64
+		var err error
+		locations, size, err = writeLog(file, first, len(record.Blocks), func(i int) (Block, error) {
+			return record.Blocks[i], nil
+		})
+		return size, err
+	})
+	if err != nil {
+		return err
+	}
+	store.recent = make(map[int]Block)
+	store.apply(record.Batch)
+	store.locations = locations
+	for _, block := range record.Blocks {
+		store.cache(block)
+	}
+	return nil
+}
+
+// replaceLog writes a new log with write, which returns its size, and replaces the old one
+// with it at once, so a crash leaves one or the other. The caller must hold store.mutex
+func (store *LogStore) replaceLog(write func(file *os.File) (int64, error)) error {
+	temporaryPath := store.path + ".tmp"
+	file, err := os.OpenFile(temporaryPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	size, err := write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(temporaryPath, store.path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(temporaryPath)
+		return err
+	}
+	if err := syncDir(filepath.Dir(store.path)); err != nil {
+		logWarnf("Failed to sync %v: %v", filepath.Dir(store.path), err)
+	}
+
+	store.file.Close()
+	store.file = file
+	store.size = size
+	return nil
+}
+
+// writeCompacted writes the compacted log to file, and returns where the bodies kept are in it
+// and its size. The caller must hold store.mutex
+func (store *LogStore) writeCompacted(file *os.File, height int, pruned []BlockHeader) ([]blockLocation, int64, error) {
+	first := logRecord{
+		Batch:   Batch{Balances: store.balances, Nonces: store.nonces, Snapshot: store.snapshot, Consensus: store.consensus},
+		Headers: append(append([]BlockHeader(nil), store.headers...), pruned...),
+	}
+	return writeLog(file, first, store.tip-height+1, func(i int) (Block, error) {
+		block, _, err := store.block(height + i)
+		return block, err
+	})
+}
+
+// writeLog writes a log to file: the first record, then a record for each of the count bodies
+// returned by body. It returns where the bodies are in it and its size
+func writeLog(file *os.File, first logRecord, count int, body func(i int) (Block, error)) ([]blockLocation, int64, error) {
+	buffered := bufio.NewWriter(file)
+	var size int64
+	write := func(record logRecord) error {
+		encoded, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		size += int64(len(encoded))
+		_, err = buffered.Write(encoded)
+		return err
+	}
+
+	if err := write(first); err != nil {
+		return nil, 0, err
+	}
+	locations := make([]blockLocation, 0, count)
+	for i := 0; i < count; i++ {
+		block, err := body(i)
+		if err != nil {
+			return nil, 0, err
+		}
+		locations = append(locations, blockLocation{size, 0})
+		if err := write(logRecord{Batch: Batch{Blocks: []Block{block}}}); err != nil {
+			return nil, 0, err
+		}
+	}
+	return locations, size, buffered.Flush()
+}
+
+func (store *LogStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.file.Close()
+}
+
+// syncDir makes the entries of a directory durable, as after a rename
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+)
+
+// Log levels. log.Println logs at the info level, logDebugf and logWarnf
+// at their own level. A level hides the messages of the levels below it
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevels = map[string]LogLevel{
+	"debug": LogDebug,
+	"info":  LogInfo,
+	"warn":  LogWarn,
+	"error": LogError,
+}
+
+var currentLogLevel = LogInfo
+
+// levelLog writes the warnings and errors, which stay visible when the info messages are hidden
+var levelLog = log.New(os.Stderr, "", log.LstdFlags)
+
+// SetLogLevel sets the level by name. Unknown names are rejected by Config.Validate
+func SetLogLevel(name string) {
+	currentLogLevel = logLevels[name]
+	if currentLogLevel > LogInfo {
+		log.SetOutput(io.Discard)
+	} else {
+		log.SetOutput(levelLog.Writer())
+	}
+}
+
+func logDebugf(format string, v ...interface{}) {
+	if currentLogLevel <= LogDebug {
+		log.Printf(format, v...)
+	}
+}
+
+func logWarnf(format string, v ...interface{}) {
+	if currentLogLevel <= LogWarn {
+		levelLog.Printf("WARN "+format, v...)
+	}
+}
+
+func logErrorf(format string, v ...interface{}) {
+	levelLog.Printf("ERROR "+format, v...)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// The mempool holds the submissions waiting to be added to a block by a proposer. Every
+// submission is an entry, identified by the hash of its data, so a submission received
+// from several nodes is only held once. The entries added to a block are remembered for
+// MEMPOOL_ENTRY_TTL, so a copy forwarded late is not added again
+
+// MempoolEntry is a submission waiting for a block
+type MempoolEntry struct {
+	ID         string    `json:"id"`
+	Data       BlockData `json:"data"`
+	ReceivedAt int64     `json:"received_at"` // Unix time in milliseconds
+}
+
+type Mempool struct {
+	mutex       sync.Mutex
+	entries     []MempoolEntry             // in the order they were received
+	forwardedTo map[string]map[string]bool // nodes each entry was forwarded to
+	included    map[string]int64           // Unix time in milliseconds each entry added to a block was included at
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{forwardedTo: make(map[string]map[string]bool), included: make(map[string]int64)}
+}
+
+// entryID returns the ID of the entry holding data
+func entryID(data BlockData) string {
+	bytes, _ := json.Marshal(data)
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// Add adds the entry holding data, unless it is already held. It fails when the mempool is full
+func (mempool *Mempool) Add(data BlockData, now time.Time) (MempoolEntry, error) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	id := entryID(data)
+	if includedAt, exists := mempool.included[id]; exists {
+		return MempoolEntry{ID: id, Data: data, ReceivedAt: includedAt}, nil
+	}
+	for _, entry := range mempool.entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	if len(mempool.entries) >= MEMPOOL_SIZE {
+		return MempoolEntry{}, ErrMempoolFull
+	}
+	entry := MempoolEntry{ID: id, Data: data, ReceivedAt: now.UnixMilli()}
+	mempool.entries = append(mempool.entries, entry)
+	return entry, nil
+}
+
+// Entries returns the entries held, oldest first
+func (mempool *Mempool) Entries() []MempoolEntry {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	return append([]MempoolEntry{}, mempool.entries...)
+}
+
+// Get returns the entry holding data, if held or added to a block recently
+func (mempool *Mempool) Get(data BlockData) (MempoolEntry, bool) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	id := entryID(data)
+	if includedAt, exists := mempool.included[id]; exists {
+		return MempoolEntry{ID: id, Data: data, ReceivedAt: includedAt}, true
+	}
+	for _, entry := range mempool.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return MempoolEntry{}, false
+}
+
+// pendingCount returns how many entries of address follow nonce, the nonce of its last
+// submission added to a block, in a row
+func (mempool *Mempool) pendingCount(address string, nonce int) int {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	nonces := make(map[int]bool)
+	for _, entry := range mempool.entries {
+		if blockSender(Block{Data: entry.Data}) == address {
+			nonces[submissionNonce(entry.Data)] = true
+		}
+	}
+	count := 0
+	for nonces[nonce+count+1] {
+		count++
+	}
+	return count
+}
+
+// Remove drops the entry with the given ID, if held
+func (mempool *Mempool) Remove(id string) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	mempool.remove(id)
+}
+
+// Included drops the entry with the given ID, if held, as it was added to a block
+func (mempool *Mempool) Included(id string, now time.Time) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	mempool.remove(id)
+	mempool.included[id] = now.UnixMilli()
+}
+
+// remove drops the entry with the given ID. The caller must hold mempool.mutex
+func (mempool *Mempool) remove(id string) {
+	for i, entry := range mempool.entries {
+		if entry.ID == id {
+			mempool.entries = append(mempool.entries[:i], mempool.entries[i+1:]...)
+			delete(mempool.forwardedTo, id)
+			return
+		}
+	}
+}
+
+// Expire drops the entries received more than MEMPOOL_ENTRY_TTL before now. They were
+// forwarded to every validator since, and none could add them
+func (mempool *Mempool) Expire(now time.Time) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	kept := mempool.entries[:0]
+	for _, entry := range mempool.entries {
+		if now.Sub(time.UnixMilli(entry.ReceivedAt)) <= MEMPOOL_ENTRY_TTL {
+			kept = append(kept, entry)
+		} else {
+			delete(mempool.forwardedTo, entry.ID)
+		}
+	}
+	mempool.entries = kept
+	for id, includedAt := range mempool.included {
+		if now.Sub(time.UnixMilli(includedAt)) > MEMPOOL_ENTRY_TTL {
+			delete(mempool.included, id)
+		}
+	}
+}
+
+// toForward returns the entries not forwarded to the node yet, and marks them as forwarded
+func (mempool *Mempool) toForward(node string) []MempoolEntry {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	entries := make([]MempoolEntry, 0)
+	for _, entry := range mempool.entries {
+		if mempool.forwardedTo[entry.ID] == nil {
+			mempool.forwardedTo[entry.ID] = make(map[string]bool)
+		}
+		if !mempool.forwardedTo[entry.ID][node] {
+			mempool.forwardedTo[entry.ID][node] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// forwardFailed marks the entry as not forwarded to the node, so it is forwarded again
+func (mempool *Mempool) forwardFailed(id string, node string) {
+	mempool.mutex.Lock()
+	defer mempool.mutex.Unlock()
+
+	delete(mempool.forwardedTo[id], node)
+}
@@ -1,32 +1,47 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"log"
 	"math/rand"
-	"net/http"
 	"time"
+
+	"solvernet/client"
 )
 
 type Node struct {
-	Port string
+	Address   string   // address the node submits problems and solutions from
+	peers     []string // API URLs of the other nodes
+	transport Transport
+	clock     Clock
+	random    *rand.Rand
+	// average seconds between the steps, and the expiry in seconds of the problems submitted
+	blockInterval int
+	problemExpiry int
+}
+
+// NewNode creates a node talking to the peers at the given API URLs
+func NewNode(address string, peerURLs []string) *Node {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return NewNodeWith(address, peerURLs, NewHTTPTransport(peerURLs), SystemClock{}, random)
 }
 
-func InitNode(port string) *Node {
-	return &Node{Port: port}
+// NewNodeWith creates a node with its own transport, clock and source of randomness.
+// The peers are contacted in the given order
+func NewNodeWith(address string, peers []string, transport Transport, clock Clock, random *rand.Rand) *Node {
+	return &Node{Address: address, peers: peers, transport: transport, clock: clock, random: random, blockInterval: DEFAULT_BLOCK_INTERVAL}
 }
+
+// SetTiming sets the average seconds between the steps of the node, and the expiry in seconds
+// of the problems it submits. An expiry of 0 accepts solutions for the whole window
+func (n *Node) SetTiming(blockInterval int, problemExpiry int) {
+	n.blockInterval = blockInterval
+	n.problemExpiry = problemExpiry
+}
+
 func (n *Node) checkOnline() error {
-	for _, node := range AllNodePorts {
-		if node == n.Port {
-			continue
-		}
-		resp, err := http.Get("http://localhost:" + node + "/api/heartbeat") // "http://localhost:3001/api/heartbeat
-		if err != nil {
-			return err
-		}
-		err = resp.Body.Close()
-		if err != nil {
+	for _, peer := range n.peers {
+		if err := n.transport.Heartbeat(context.Background(), peer); err != nil {
 			return err
 		}
 	}
@@ -37,27 +52,37 @@ func (n *Node) StartNode(bc *Blockchain, ledger *Ledger) {
 	for {
 		err := n.checkOnline()
 		if err != nil {
-			log.Println(err)
+			logWarnf("Waiting for peers: %v", err)
 		} else {
 			break
 		}
-		time.Sleep(5 * time.Second)
+		n.clock.Sleep(5 * time.Second)
 	}
 	log.Println("ONLINE")
 	for {
-		// Sleep a random amount of time
-		time.Sleep(time.Duration(rand.Intn(10)+1) * time.Second)
-		log.Println("About to check if we should submit a problem or find a solution")
-		if rand.Intn(10) == 0 {
-			log.Println("About to submit a problem")
-			err := n.submitProblem()
-			if err != nil {
-				log.Println(err)
-			}
-		} else {
-			log.Println("About to submit a proposed solution")
-			n.submitProposedSolution(bc)
+		n.clock.Sleep(n.NextStepDelay())
+		n.Step(bc)
+	}
+}
+
+// NextStepDelay returns a random amount of time to wait before the next step, blockInterval
+// seconds on average
+func (n *Node) NextStepDelay() time.Duration {
+	return time.Duration(n.random.Intn(2*n.blockInterval)+1) * time.Second
+}
+
+// Step submits either a new problem or a proposed solution to an open problem of bc
+func (n *Node) Step(bc *Blockchain) {
+	logDebugf("About to check if we should submit a problem or find a solution")
+	if n.random.Intn(10) == 0 {
+		logDebugf("About to submit a problem")
+		err := n.submitProblem()
+		if err != nil {
+			log.Println(err)
 		}
+	} else {
+		logDebugf("About to submit a proposed solution")
+		n.submitProposedSolution(bc)
 	}
 }
 
@@ -67,12 +92,12 @@ func (n *Node) submitProposedSolution(bc *Blockchain) {
 	validProblemsBlocks := bc.FindValidProblemsBlocks()
 
 	if len(validProblemsBlocks) == 0 {
-		log.Println("No valid problems blocks found. Aborting...")
+		logDebugf("No valid problems blocks found. Aborting...")
 		return
 	}
 
 	// Select a random problem to solve
-	randomProblemBlock := validProblemsBlocks[rand.Intn(len(validProblemsBlocks))]
+	randomProblemBlock := validProblemsBlocks[n.random.Intn(len(validProblemsBlocks))]
 
 	problemHeight := randomProblemBlock.Height
 
@@ -80,7 +105,7 @@ func (n *Node) submitProposedSolution(bc *Blockchain) {
 	solutionItems := make([]int, 0)
 
 	for i := range randomProblemBlock.Data.Problem.Items {
-		if rand.Intn(2) == 1 { // 50% chance to include the item
+		if n.random.Intn(2) == 1 { // 50% chance to include the item
 			solutionItems = append(solutionItems, i)
 		}
 	}
@@ -90,7 +115,7 @@ func (n *Node) submitProposedSolution(bc *Blockchain) {
 		ItemIndexes:        solutionItems,
 		ProblemBlockHeight: problemHeight, // related problem block height
 		Value:              0,             // Value will be calculated later
-		Address:            n.Port,        // Assuming the node's port is used as its identity/address
+		Address:            n.Address,
 	}
 
 	totalValue := GetTotalSolutionValue(*randomProblemBlock.Data.Problem, newSolution)
@@ -104,27 +129,11 @@ func (n *Node) submitProposedSolution(bc *Blockchain) {
 		return
 	}
 
-	// Marshal the new solution into JSON
-	jsonPayload, err := json.Marshal(newSolution)
-	if err != nil {
-		log.Println("Error encoding JSON:", err)
-		return
-	}
-
 	// Submit the new solution to all nodes
-	for _, node := range AllNodePorts {
-		if node == n.Port {
-			continue
-		}
-		log.Println("Submitting proposed solution to node", node)
-		resp, err := http.Post("http://localhost:"+node+"/api/send_proposed_solution", "application/json", bytes.NewBuffer(jsonPayload))
-		if err != nil {
-			log.Println("Failed to submit proposed solution:", err)
-			continue
-		}
-		err = resp.Body.Close()
-		if err != nil {
-			log.Println("Error closing response body:", err)
+	for _, peer := range n.peers {
+		logDebugf("Submitting proposed solution to node %v", peer)
+		if err := n.transport.SendProposedSolution(context.Background(), peer, newSolution); err != nil {
+			logWarnf("Failed to submit proposed solution: %v", err)
 		}
 	}
 
@@ -134,19 +143,15 @@ func (n *Node) submitProposedSolution(bc *Blockchain) {
 func (n *Node) submitProblem() error {
 	log.Println("Creating a new problem")
 	problem := KnapsackProblem{}
-	// TODO: Ensure we don't offer more than we have in our balance
-	bounty := rand.Float64() * 10
-	if bounty == 0 {
-		log.Println("No bounty, not submitting problem")
-		return nil
-	}
-	problem.Bounty = bounty
-	problem.Address = n.Port
-	problem.Items = make([]Item, rand.Intn(10)+1)
+	problem.Address = n.Address
+	problem.ExpirySeconds = n.problemExpiry
+	// values follow weights, which makes the instances hard
+	problem.Items = make([]Item, n.random.Intn(10)+2*MIN_PROBLEM_ITEMS)
 	for i := range problem.Items {
+		weight := n.random.Intn(10) + 1
 		item := Item{
-			Value:  rand.Intn(10) + 1,
-			Weight: rand.Intn(10) + 1,
+			Value:  weight + n.random.Intn(5),
+			Weight: weight,
 		}
 		problem.Items[i] = item
 	}
@@ -154,25 +159,56 @@ func (n *Node) submitProblem() error {
 	sumOfWeights := GetProblemItemsSumWeight(problem)
 	problem.Capacity = int(sumOfWeights * 2 / 3)
 
-	jsonPayload, err := json.Marshal(problem)
-	if err != nil {
-		log.Println("Error encoding JSON:", err)
-		return err
+	// TODO: Ensure we don't offer more than we have in our balance
+	problem.Bounty = MinProblemBounty(problem) + n.random.Float64()*10
+	if err := checkHardness(problem); err != nil {
+		log.Println("Generated problem is too easy:", err, ". Discarding...")
+		return nil
 	}
 
-	for _, node := range AllNodePorts {
-		if node == n.Port {
-			continue
-		}
-		log.Println("SUBMITTING PROBLEM", problem, "TO", node)
-		resp, err := http.Post("http://localhost:"+node+"/api/send_problem", "application/json", bytes.NewBuffer(jsonPayload))
-		if err != nil {
-			return err
-		}
-		err = resp.Body.Close()
-		if err != nil {
+	for _, peer := range n.peers {
+		logDebugf("Submitting problem %v to node %v", problem, peer)
+		if err := n.transport.SendProblem(context.Background(), peer, problem); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func toClientProblem(problem KnapsackProblem) client.KnapsackProblem {
+	items := make([]client.Item, len(problem.Items))
+	for i, item := range problem.Items {
+		items[i] = client.Item{Weight: item.Weight, Value: item.Value}
+	}
+	return client.KnapsackProblem{
+		Items:         items,
+		Capacity:      problem.Capacity,
+		Bounty:        problem.Bounty,
+		Address:       problem.Address,
+		PayoutPolicy:  string(problem.PayoutPolicy),
+		ExpirySeconds: problem.ExpirySeconds,
+		Nonce:         problem.Nonce,
+		PublicKey:     problem.PublicKey,
+		Signature:     problem.Signature,
+	}
+}
+
+func toClientProposedSolution(solution KnapsackProposedSolution) client.KnapsackProposedSolution {
+	clientSolution := client.KnapsackProposedSolution{
+		ItemIndexes:        solution.ItemIndexes,
+		ProblemBlockHeight: solution.ProblemBlockHeight,
+		Value:              solution.Value,
+		Address:            solution.Address,
+		Nonce:              solution.Nonce,
+		PublicKey:          solution.PublicKey,
+		Signature:          solution.Signature,
+	}
+	if solution.Certificate != nil {
+		clientSolution.Certificate = &client.OptimalityCertificate{
+			Type:       string(solution.Certificate.Type),
+			UpperBound: solution.Certificate.UpperBound,
+			Digest:     solution.Certificate.Digest,
+		}
+	}
+	return clientSolution
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gorilla/handlers"
+
+	"solvernet/client"
+)
+
+func runNodeInit(command *command, args []string) error {
+	flags := newFlags(command)
+	configFlags := newConfigFlags(flags)
+	force := flags.Bool("force", false, "overwrite an existing config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*configFlags.configFile); err == nil && !*force {
+		return fmt.Errorf("%v already exists. Use -force to overwrite it", *configFlags.configFile)
+	}
+	// an existing file is replaced, not layered under the new config
+	config, err := configFlags.LoadConfig(flags, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	if err := config.SaveConfigFile(*configFlags.configFile); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %v. Run the node with: solvernet node run -config %v\n", *configFlags.configFile, *configFlags.configFile)
+	return nil
+}
+
+func runNodeRun(command *command, args []string) error {
+	flags := newFlags(command)
+	configFlags := newConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	config, err := configFlags.LoadConfig(flags, true)
+	if err != nil {
+		return err
+	}
+	return RunNode(config)
+}
+
+// RunNode serves the API of a node until it receives SIGINT or SIGTERM.
+// The chain is kept in the data dir, and every block is written to it as it is added
+func RunNode(config Config) error {
+	SetLogLevel(config.LogLevel)
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	blockchain, ledger, err := OpenBlockchain(config.DataDir, false)
+	if err != nil {
+		return err
+	}
+	// every block is written to the data dir as it is added, so closing the chain is all that is left on shutdown
+	defer blockchain.Close()
+	if config.PruneDepth > 0 {
+		blockchain.SetPruneDepth(config.PruneDepth)
+		log.Printf("Pruning the bodies of the blocks more than %v below the tip", config.PruneDepth)
+	}
+	limits := config.Limits()
+	blockchain.SetLimits(limits)
+
+	if len(config.Validators) > 0 {
+		producer, err := newNodeProducer(config, blockchain, ledger)
+		if err != nil {
+			return err
+		}
+		go producer.Run()
+	} else if len(config.Peers) > 0 {
+		if config.PruneDepth > 0 {
+			logWarnf("Pruned nodes do not switch to the chains of their peers doing more work")
+		}
+		forkChoice := NewForkChoice(blockchain, ledger, config.Peers, NewHTTPTransport(config.Peers))
+		go forkChoice.Run()
+	}
+
+	router := NewRouter(blockchain, ledger)
+	if err := CheckRouterAgainstSpec(router); err != nil {
+		return fmt.Errorf("API does not match openapi.json: %w", err)
+	}
+
+	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "HEAD", "OPTIONS"})
+	origins := handlers.AllowedOrigins(config.CORSOrigins)
+
+	// request contexts end with ctx, so event streams close on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{
+		Addr:              config.Listen,
+		Handler:           limitRequests(limits, SystemClock{}, handlers.CORS(headers, methods, origins)(router)),
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return err
+	}
+
+	if config.Mining {
+		node := NewNode(config.ListenPort(), config.Peers)
+		node.SetTiming(config.BlockInterval, config.ProblemExpiry)
+		go node.StartNode(blockchain, ledger)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("now serving on", config.Listen)
+		serveErr <- server.Serve(newLimitListener(listener, limits))
+	}()
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		err = server.Shutdown(shutdownCtx)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// newNodeProducer makes the node follow the validator set of the config, proposing blocks
+// when it has the key of one of the validators
+func newNodeProducer(config Config, blockchain *Blockchain, ledger *Ledger) (*Producer, error) {
+	validators, err := ParseValidators(config.Validators)
+	if err != nil {
+		return nil, err
+	}
+	var key *client.Key
+	if config.ValidatorKey != "" {
+		if key, err = client.LoadKey(config.ValidatorKey); err != nil {
+			return nil, err
+		}
+		if _, exists := validators.Validator(key.Address); !exists {
+			return nil, fmt.Errorf("validator key %v is not in the validator set", key.Address)
+		}
+		log.Printf("Validating as %v", key.Address)
+	}
+
+	peerURLs := append([]string{}, config.Peers...)
+	for _, validator := range validators.Validators() {
+		peerURLs = append(peerURLs, validator.URL)
+	}
+	log.Printf("Following %v validators", len(validators.Validators()))
+	return NewProducer(blockchain, ledger, validators, key, config.Peers, NewHTTPTransport(peerURLs)), nil
+}
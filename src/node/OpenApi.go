@@ -0,0 +1,180 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"solvernet/client"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPISpec documents every route of the node API.
+// CheckRouterAgainstSpec keeps it in sync with the router and the wire types
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// schemaTypes are the server and client types serialized as each schema of the document
+var schemaTypes = map[string][]reflect.Type{
+	"Item":                     {reflect.TypeOf(Item{}), reflect.TypeOf(client.Item{})},
+	"KnapsackProblem":          {reflect.TypeOf(KnapsackProblem{}), reflect.TypeOf(client.KnapsackProblem{})},
+	"OptimalityCertificate":    {reflect.TypeOf(OptimalityCertificate{}), reflect.TypeOf(client.OptimalityCertificate{})},
+	"KnapsackProposedSolution": {reflect.TypeOf(KnapsackProposedSolution{}), reflect.TypeOf(client.KnapsackProposedSolution{})},
+	"Transaction":              {reflect.TypeOf(Transaction{}), reflect.TypeOf(client.Transaction{})},
+	"BountyPayout":             {reflect.TypeOf(BountyPayout{}), reflect.TypeOf(client.BountyPayout{})},
+	"BlockData":                {reflect.TypeOf(BlockData{}), reflect.TypeOf(client.BlockData{})},
+	"Block":                    {reflect.TypeOf(Block{}), reflect.TypeOf(client.Block{})},
+	"ChainHead":                {reflect.TypeOf(ChainHead{}), reflect.TypeOf(client.ChainHead{})},
+	"BlockPage":                {reflect.TypeOf(BlockPage{}), reflect.TypeOf(client.BlockPage{})},
+	"BlockHeader":              {reflect.TypeOf(BlockHeader{}), reflect.TypeOf(client.BlockHeader{})},
+	"HeaderPage":               {reflect.TypeOf(HeaderPage{}), reflect.TypeOf(client.HeaderPage{})},
+	"MempoolEntry":             {reflect.TypeOf(MempoolEntry{}), reflect.TypeOf(client.MempoolEntry{})},
+	"PendingSubmission":        {reflect.TypeOf(PendingSubmission{}), reflect.TypeOf(client.PendingSubmission{})},
+	"Validator":                {reflect.TypeOf(Validator{}), reflect.TypeOf(client.Validator{})},
+	"ValidatorSchedule":        {reflect.TypeOf(ValidatorSchedule{}), reflect.TypeOf(client.ValidatorSchedule{})},
+	"Vote":                     {reflect.TypeOf(Vote{}), reflect.TypeOf(client.Vote{})},
+	"Commit":                   {reflect.TypeOf(Commit{}), reflect.TypeOf(client.Commit{})},
+	"Proposal":                 {reflect.TypeOf(Proposal{}), reflect.TypeOf(client.Proposal{})},
+	"ConsensusState":           {reflect.TypeOf(ConsensusState{}), reflect.TypeOf(client.ConsensusState{})},
+	"Ledger":                   {reflect.TypeOf(ledgerJSON{}), reflect.TypeOf(client.Ledger{})},
+	"Account":                  {reflect.TypeOf(Account{}), reflect.TypeOf(client.Account{})},
+	"ProblemSummary":           {reflect.TypeOf(ProblemSummary{}), reflect.TypeOf(client.ProblemSummary{})},
+	"ProblemSubmission":        {reflect.TypeOf(ProblemSubmission{}), reflect.TypeOf(client.ProblemSubmission{})},
+	"ProblemHistory":           {reflect.TypeOf(ProblemHistory{}), reflect.TypeOf(client.ProblemHistory{})},
+	"Hardness":                 {reflect.TypeOf(Hardness{}), reflect.TypeOf(client.Hardness{})},
+	"ProblemValidation":        {reflect.TypeOf(ProblemValidation{}), reflect.TypeOf(client.ProblemValidation{})},
+	"SolutionValidation":       {reflect.TypeOf(SolutionValidation{}), reflect.TypeOf(client.SolutionValidation{})},
+	"ErrorBody":                {reflect.TypeOf(ErrorBody{}), reflect.TypeOf(client.ErrorBody{})},
+	"ErrorResponse":            {reflect.TypeOf(ErrorResponse{}), reflect.TypeOf(client.ErrorResponse{})},
+	"Event":                    {reflect.TypeOf(Event{}), reflect.TypeOf(client.Event{})},
+	"ImportResult":             {reflect.TypeOf(ImportResult{}), reflect.TypeOf(client.ImportResult{})},
+	"ChainState":               {reflect.TypeOf(ChainState{}), reflect.TypeOf(client.ChainState{})},
+	"StateSnapshot":            {reflect.TypeOf(StateSnapshot{}), reflect.TypeOf(client.StateSnapshot{})},
+	"RPCRequest":               {reflect.TypeOf(RPCRequest{}), reflect.TypeOf(client.RPCRequest{})},
+	"RPCError":                 {reflect.TypeOf(RPCError{}), reflect.TypeOf(client.RPCError{})},
+	"RPCResponse":              {reflect.TypeOf(RPCResponse{}), reflect.TypeOf(client.RPCResponse{})},
+	"RPCNotification":          {reflect.TypeOf(RPCNotification{}), reflect.TypeOf(client.RPCNotification{})},
+}
+
+// mux path variables may carry a pattern, e.g. {height:[0-9]+}
+var pathVariablePattern = regexp.MustCompile(`\{([^:}]+)(:[^}]*)?\}`)
+
+func HandleGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// jsonFieldNames returns the JSON names of the fields of a struct type
+func jsonFieldNames(t reflect.Type) []string {
+	names := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckRouterAgainstSpec checks every route of the router is documented in
+// openapi.json and the other way around, and that the documented schemas have
+// the same fields as the server and client types
+func CheckRouterAgainstSpec(router *mux.Router) error {
+	var document openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &document); err != nil {
+		return err
+	}
+
+	documented := make(map[string]bool)
+	for path, operations := range document.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	routed := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		path := pathVariablePattern.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			routed[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	problems := make([]string, 0)
+	for route := range routed {
+		if !documented[route] {
+			problems = append(problems, "undocumented route "+route)
+		}
+	}
+	for route := range documented {
+		if !routed[route] {
+			problems = append(problems, "documented route not served "+route)
+		}
+	}
+
+	for name, schema := range document.Components.Schemas {
+		types, exists := schemaTypes[name]
+		if !exists {
+			problems = append(problems, "schema without type "+name)
+			continue
+		}
+		properties := make([]string, 0, len(schema.Properties))
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		for _, t := range types {
+			if fields := jsonFieldNames(t); !reflect.DeepEqual(fields, properties) {
+				problems = append(problems, fmt.Sprintf("schema %v has properties %v but %v has fields %v", name, properties, t, fields))
+			}
+		}
+	}
+	for name := range schemaTypes {
+		if _, exists := document.Components.Schemas[name]; !exists {
+			problems = append(problems, "type without schema "+name)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%v", strings.Join(problems, "; "))
+	}
+	return nil
+}
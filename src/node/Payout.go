@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PayoutPolicy defines how the bounty of an expired problem is distributed
+type PayoutPolicy string
+
+const (
+	// WinnerTakesAllPayout sends the whole bounty to the best solution (default)
+	WinnerTakesAllPayout PayoutPolicy = "winner_takes_all"
+	// ProportionalPayout splits the bounty among every solver that improved the
+	// best known value, in proportion to the improvement each one contributed
+	ProportionalPayout PayoutPolicy = "proportional"
+)
+
+// BountyPayout is the list of rewarding transactions generated when a problem
+// expires, or earlier when a solution is certified optimal
+type BountyPayout struct {
+	ProblemBlockHeight int           `json:"problem_block_height"`
+	Policy             PayoutPolicy  `json:"policy"`
+	Transactions       []Transaction `json:"transactions"`
+	Early              bool          `json:"early,omitempty"` // settled by an optimality certificate
+}
+
+func isValidPayoutPolicy(policy PayoutPolicy) bool {
+	return policy == "" || policy == WinnerTakesAllPayout || policy == ProportionalPayout
+}
+
+// effectivePayoutPolicy returns the policy of a problem, using the default when not set
+func effectivePayoutPolicy(problem KnapsackProblem) PayoutPolicy {
+	if problem.PayoutPolicy == "" {
+		return WinnerTakesAllPayout
+	}
+	return problem.PayoutPolicy
+}
+
+// ComputePayouts returns the rewarding transactions for a problem given the
+// proposed solutions submitted for it, in chain order.
+// The result only depends on its inputs, so every node computes the same payouts
+func ComputePayouts(problemBlockHeight int, problem KnapsackProblem, solutions []KnapsackProposedSolution) []Transaction {
+	if len(solutions) == 0 {
+		return nil
+	}
+
+	// only the solutions that strictly improved the best known value count
+	improvements := make(map[string]int)
+	addresses := make([]string, 0)
+	bestValue := 0
+	bestAddress := ""
+	for _, solution := range solutions {
+		if solution.Value <= bestValue {
+			continue
+		}
+		if _, exists := improvements[solution.Address]; !exists {
+			addresses = append(addresses, solution.Address)
+		}
+		improvements[solution.Address] += solution.Value - bestValue
+		bestValue = solution.Value
+		bestAddress = solution.Address
+	}
+
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	if effectivePayoutPolicy(problem) == WinnerTakesAllPayout {
+		return []Transaction{{
+			From:               problem.Address,
+			To:                 bestAddress,
+			Amount:             problem.Bounty,
+			ProblemBlockHeight: problemBlockHeight,
+		}}
+	}
+
+	// proportional split. The last solver receives the remainder so the
+	// payouts always sum up to the bounty
+	transactions := make([]Transaction, 0, len(addresses))
+	paid := 0.0
+	for i, address := range addresses {
+		amount := problem.Bounty * float64(improvements[address]) / float64(bestValue)
+		if i == len(addresses)-1 {
+			amount = problem.Bounty - paid
+		}
+		paid += amount
+		transactions = append(transactions, Transaction{
+			From:               problem.Address,
+			To:                 address,
+			Amount:             amount,
+			ProblemBlockHeight: problemBlockHeight,
+		})
+	}
+	return transactions
+}
+
+// findProposedSolutions returns the proposed solutions for the problem at the given height, in chain order
+func (bc *Blockchain) findProposedSolutions(problemBlockHeight int) []KnapsackProposedSolution {
+	solutions := make([]KnapsackProposedSolution, 0)
+	for _, block := range bc.blockRange(problemBlockHeight+1, problemExpiryHeight(problemBlockHeight)) {
+		if block.Data.Type == KnapsackProposedSolutionSubmission &&
+			block.Data.Solution.ProblemBlockHeight == problemBlockHeight {
+			solutions = append(solutions, *block.Data.Solution)
+		}
+	}
+	return solutions
+}
+
+// expectedPayout computes the payout the chain must contain for the problem at the given height
+func (bc *Blockchain) expectedPayout(problemBlockHeight int) (*BountyPayout, error) {
+	block, exists := bc.blockAt(problemBlockHeight)
+	if !exists || block.Data.Type != KnapsackProblemSubmission || block.Data.Problem == nil {
+		return nil, ErrProblemNotFound
+	}
+
+	problem := *block.Data.Problem
+	transactions := ComputePayouts(problemBlockHeight, problem, bc.findProposedSolutions(problemBlockHeight))
+	if len(transactions) == 0 {
+		return nil, nil
+	}
+	return &BountyPayout{
+		ProblemBlockHeight: problemBlockHeight,
+		Policy:             effectivePayoutPolicy(problem),
+		Transactions:       transactions,
+	}, nil
+}
+
+// findPayout returns the payout of the problem at the given height, if it was already settled
+func (bc *Blockchain) findPayout(problemBlockHeight int) *BountyPayout {
+	block := bc.findPayoutBlock(problemBlockHeight)
+	if block == nil {
+		return nil
+	}
+	return block.Data.Payout
+}
+
+// findPayoutBlock returns the block holding the payout of the problem at the given height
+func (bc *Blockchain) findPayoutBlock(problemBlockHeight int) *Block {
+	if _, exists := bc.blockAt(problemBlockHeight); !exists {
+		return nil
+	}
+	// a problem is settled by the block after its expiry at the latest
+	for _, block := range bc.blockRange(problemBlockHeight+1, problemExpiryHeight(problemBlockHeight)+1) {
+		if block.Data.Type == BountyPayoutSubmission && block.Data.Payout.ProblemBlockHeight == problemBlockHeight {
+			return &block
+		}
+	}
+	return nil
+}
+
+// hasCertifiedOptimalSolution tells if a solution proven optimal was submitted for the problem
+func (bc *Blockchain) hasCertifiedOptimalSolution(problemBlockHeight int) bool {
+	for _, solution := range bc.findProposedSolutions(problemBlockHeight) {
+		if IsCertifiedOptimal(solution) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bc *Blockchain) validatePayout(payout BountyPayout) error {
+	expected, err := bc.expectedPayout(payout.ProblemBlockHeight)
+	if err != nil {
+		return err
+	}
+	if expected == nil {
+		return fmt.Errorf("%w: problem has no solution to pay", ErrInvalidPayout)
+	}
+	if bc.findPayout(payout.ProblemBlockHeight) != nil {
+		return ErrProblemSettled
+	}
+
+	// a payout is added when the problem expires, unless a certified optimal solution settles it early
+	expired := bc.getLastBlock().Height >= payout.ProblemBlockHeight+NUMBER_OF_BLOCKS_TO_SOLUTION
+	if payout.Early {
+		if expired || !bc.hasCertifiedOptimalSolution(payout.ProblemBlockHeight) {
+			return fmt.Errorf("%w: problem cannot be settled early", ErrInvalidPayout)
+		}
+	} else if !expired {
+		return fmt.Errorf("%w: problem has not expired yet", ErrInvalidPayout)
+	}
+
+	if payout.Policy != expected.Policy || len(payout.Transactions) != len(expected.Transactions) {
+		return fmt.Errorf("%w: payout does not match the problem solutions", ErrInvalidPayout)
+	}
+	for i, tx := range payout.Transactions {
+		if tx != expected.Transactions[i] {
+			return fmt.Errorf("%w: transaction %d does not match the problem solutions", ErrInvalidPayout, i)
+		}
+		if err := bc.validateTransaction(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleProblem adds the payout block for a problem, if anyone solved it.
+// Early settlements happen before expiry, when a solution is certified optimal
+func (bc *Blockchain) settleProblem(problemBlockHeight int, ledger *Ledger, early bool) error {
+	if bc.findPayout(problemBlockHeight) != nil {
+		log.Printf("Problem at height %v was already settled", problemBlockHeight)
+		return nil
+	}
+
+	payout, err := bc.expectedPayout(problemBlockHeight)
+	if err != nil {
+		return err
+	}
+	if payout == nil {
+		log.Printf("Problem at height %v expired without solutions", problemBlockHeight)
+		problem := bc.GetBlock(problemBlockHeight).Data.Problem
+		bc.events.Publish(Event{
+			Type:               ProblemExpiredEvent,
+			BlockHeight:        bc.getLastBlock().Height,
+			ProblemBlockHeight: &problemBlockHeight,
+			Addresses:          []string{problem.Address},
+			Data:               problem,
+		})
+		return nil
+	}
+	payout.Early = early
+
+	payoutBlock, err := bc.GeneratePayoutBlock(*payout)
+	if err != nil {
+		log.Println("Failed to generate payout block")
+		return errors.New("failed to generate payout block")
+	}
+
+	return bc.addBlock(payoutBlock, ledger)
+}
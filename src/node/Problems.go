@@ -0,0 +1,141 @@
+package main
+
+import "fmt"
+
+// ProblemStatus is the lifecycle stage of a submitted problem
+type ProblemStatus string
+
+const (
+	ProblemOpen    ProblemStatus = "open"    // accepting proposed solutions
+	ProblemClosed  ProblemStatus = "closed"  // deadline passed, waiting for the window to close to be settled
+	ProblemSettled ProblemStatus = "settled" // bounty paid
+	ProblemExpired ProblemStatus = "expired" // window closed without solutions
+)
+
+// ProblemSubmission is a proposed solution along with the block it was submitted in
+type ProblemSubmission struct {
+	BlockHeight int                      `json:"block_height"`
+	Solution    KnapsackProposedSolution `json:"solution"`
+}
+
+// ProblemSummary describes an open problem
+type ProblemSummary struct {
+	ProblemBlockHeight int             `json:"problem_block_height"`
+	Problem            KnapsackProblem `json:"problem"`
+	SubmittedAt        int64           `json:"submitted_at,omitempty"` // timestamp of the problem block, Unix time in milliseconds
+	ExpiryHeight       int             `json:"expiry_height"`          // last height accepting proposed solutions
+	ExpiresAt          int64           `json:"expires_at,omitempty"`   // deadline of the problem, when it has an expiry in seconds
+	RemainingBlocks    int             `json:"remaining_blocks"`       // blocks left before expiry
+	BestValue          int             `json:"best_value"`
+	Leader             string          `json:"leader,omitempty"` // address of the best solution
+}
+
+// ProblemHistory is everything that happened to a problem
+type ProblemHistory struct {
+	ProblemBlockHeight int                 `json:"problem_block_height"`
+	Problem            KnapsackProblem     `json:"problem"`
+	Status             ProblemStatus       `json:"status"`
+	SubmittedAt        int64               `json:"submitted_at,omitempty"`
+	ExpiryHeight       int                 `json:"expiry_height"`
+	ExpiresAt          int64               `json:"expires_at,omitempty"`
+	Submissions        []ProblemSubmission `json:"submissions"`
+	Leader             *ProblemSubmission  `json:"leader,omitempty"`
+	PayoutBlockHeight  *int                `json:"payout_block_height,omitempty"`
+	Payout             *BountyPayout       `json:"payout,omitempty"`
+}
+
+func problemExpiryHeight(problemBlockHeight int) int {
+	return problemBlockHeight + NUMBER_OF_BLOCKS_TO_SOLUTION
+}
+
+// findProblemSubmissions returns the proposed solutions for the problem at the given height
+// with the blocks they were submitted in
+func (bc *Blockchain) findProblemSubmissions(problemBlockHeight int) []ProblemSubmission {
+	submissions := make([]ProblemSubmission, 0)
+	lastHeight := min(bc.getLastBlock().Height, problemExpiryHeight(problemBlockHeight))
+	for height := problemBlockHeight + 1; height <= lastHeight; height++ {
+		block, exists := bc.blockAt(height)
+		if exists && block.Data.Type == KnapsackProposedSolutionSubmission &&
+			block.Data.Solution.ProblemBlockHeight == problemBlockHeight {
+			submissions = append(submissions, ProblemSubmission{BlockHeight: height, Solution: *block.Data.Solution})
+		}
+	}
+	return submissions
+}
+
+// GetOpenProblems lists the problems still accepting proposed solutions
+func (bc *Blockchain) GetOpenProblems() []ProblemSummary {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return bc.openProblems()
+}
+
+// openProblems lists the problems whose window is still open, including the ones past their
+// deadline, as their bounties are not paid yet. The caller must hold bc.mutex
+func (bc *Blockchain) openProblems() []ProblemSummary {
+	currentHeight := bc.getLastBlock().Height
+	summaries := make([]ProblemSummary, 0)
+	for _, block := range bc.FindValidProblemsBlocks() {
+		expiryHeight := problemExpiryHeight(block.Height)
+		if expiryHeight <= currentHeight {
+			continue
+		}
+
+		summary := ProblemSummary{
+			ProblemBlockHeight: block.Height,
+			Problem:            *block.Data.Problem,
+			SubmittedAt:        block.Timestamp,
+			ExpiryHeight:       expiryHeight,
+			ExpiresAt:          problemDeadline(block),
+			RemainingBlocks:    expiryHeight - currentHeight,
+		}
+		// submissions are strictly improving, so the last one leads
+		if submissions := bc.findProblemSubmissions(block.Height); len(submissions) > 0 {
+			leader := submissions[len(submissions)-1].Solution
+			summary.BestValue = leader.Value
+			summary.Leader = leader.Address
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// GetProblemHistory returns the submissions, leader and payout of the problem at the given height
+func (bc *Blockchain) GetProblemHistory(problemBlockHeight int) (*ProblemHistory, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	block, exists := bc.blockAt(problemBlockHeight)
+	if header, held := bc.headerAt(problemBlockHeight); !exists && held && header.Type == KnapsackProblemSubmission {
+		return nil, fmt.Errorf("%w: problem block %v", ErrBlockPruned, problemBlockHeight)
+	}
+	if !exists || block.Data.Type != KnapsackProblemSubmission || block.Data.Problem == nil {
+		return nil, ErrProblemNotFound
+	}
+
+	history := &ProblemHistory{
+		ProblemBlockHeight: problemBlockHeight,
+		Problem:            *block.Data.Problem,
+		Status:             ProblemOpen,
+		SubmittedAt:        block.Timestamp,
+		ExpiryHeight:       problemExpiryHeight(problemBlockHeight),
+		ExpiresAt:          problemDeadline(block),
+		Submissions:        bc.findProblemSubmissions(problemBlockHeight),
+	}
+	if len(history.Submissions) > 0 {
+		history.Leader = &history.Submissions[len(history.Submissions)-1]
+	}
+
+	if payoutBlock := bc.findPayoutBlock(problemBlockHeight); payoutBlock != nil {
+		history.Status = ProblemSettled
+		history.PayoutBlockHeight = &payoutBlock.Height
+		history.Payout = payoutBlock.Data.Payout
+	} else if history.ExpiryHeight <= bc.getLastBlock().Height {
+		history.Status = ProblemExpired
+	} else if history.ExpiresAt > 0 && bc.nextTimestamp() >= history.ExpiresAt {
+		history.Status = ProblemClosed
+	}
+
+	return history, nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"solvernet/client"
+)
+
+// With a validator set, the submissions a node receives go through its producer instead of
+// being added at its tip right away. The producer holds them in its mempool and forwards them
+// to the mempools of the validators. The validators then decide on the blocks holding them
+// (see Consensus.go) and add the blocks once committed, sending them to their peers. Blocks
+// are added in order: a node missing some catches up from a validator holding them
+
+type Producer struct {
+	mutex      sync.Mutex // serializes the consensus and adding blocks
+	bc         *Blockchain
+	ledger     *Ledger
+	validators *ValidatorSet
+	key        *client.Key // signs the proposals and votes. nil when the node is not a validator
+	peers      []string    // API URLs of the nodes following the chain, which the blocks committed are sent to
+	transport  Transport
+	mempool    *Mempool
+	consensus  consensus
+	outbox     []func() // messages to send once p.mutex is released
+	// run the sending of messages and the consensus timeouts, in the background by default
+	spawn    func(task func())
+	schedule func(delay time.Duration, task func())
+}
+
+// PendingSubmission is the outcome of a submission added to the mempool, but to no block yet
+type PendingSubmission struct {
+	Pending bool         `json:"pending"`
+	Entry   MempoolEntry `json:"entry"`
+}
+
+// NewProducer makes the chain only accept the blocks committed by the validators, and the
+// submissions of the node go through the mempool of the returned producer. With key, the node
+// takes part in the consensus as one of the validators. The blocks committed are sent to the
+// peers that are not validators
+func NewProducer(bc *Blockchain, ledger *Ledger, validators *ValidatorSet, key *client.Key, peerURLs []string, transport Transport) *Producer {
+	p := &Producer{
+		bc:         bc,
+		ledger:     ledger,
+		validators: validators,
+		key:        key,
+		peers:      make([]string, 0),
+		transport:  transport,
+		mempool:    NewMempool(),
+		spawn:      func(task func()) { go task() },
+		schedule:   func(delay time.Duration, task func()) { time.AfterFunc(delay, task) },
+	}
+	validatorURLs := make(map[string]bool)
+	for _, validator := range validators.Validators() {
+		validatorURLs[validator.URL] = true
+	}
+	for _, peerURL := range peerURLs {
+		if !validatorURLs[peerURL] {
+			validatorURLs[peerURL] = true
+			p.peers = append(p.peers, peerURL)
+		}
+	}
+
+	bc.mutex.Lock()
+	bc.validators = validators
+	bc.producer = p
+	bc.mutex.Unlock()
+	p.consensus = newConsensus(bc.GetHead().Height + 1)
+	if record := bc.store.Consensus(); key != nil && record != nil && record.Height == p.consensus.height {
+		p.consensus.restore(*record)
+		log.Printf("Resuming round %v of block %v, locked in round %v", record.Round, record.Height, record.LockedRound)
+	}
+	return p
+}
+
+// Producer returns the producer of the chain, or ErrNoValidators without a validator set
+func (bc *Blockchain) Producer() (*Producer, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if bc.producer == nil {
+		return nil, ErrNoValidators
+	}
+	return bc.producer, nil
+}
+
+// isValidator tells whether the node is the given validator
+func (p *Producer) isValidator(validator Validator) bool {
+	return p.key != nil && validator.Address == p.key.Address
+}
+
+// otherValidators returns the validators but the node
+func (p *Producer) otherValidators() []Validator {
+	others := make([]Validator, 0)
+	for _, validator := range p.validators.Validators() {
+		if !p.isValidator(validator) {
+			others = append(others, validator)
+		}
+	}
+	return others
+}
+
+// Mempool returns the submissions waiting for a block
+func (p *Producer) Mempool() []MempoolEntry {
+	return p.mempool.Entries()
+}
+
+// Submit checks a submission against the chain and adds it to the mempool. Signed submissions
+// may follow the ones of their sender still in the mempool
+func (p *Producer) Submit(data BlockData) (MempoolEntry, error) {
+	if entry, exists := p.mempool.Get(data); exists {
+		return entry, nil
+	}
+	if err := p.bc.checkEntry(data, p.ledger, p.mempool); err != nil {
+		return MempoolEntry{}, err
+	}
+	entry, err := p.mempool.Add(data, p.bc.clock.Now())
+	if err != nil {
+		return MempoolEntry{}, err
+	}
+	p.advance()
+	return entry, nil
+}
+
+// SubmitAndWait submits data and waits up to timeout for a block to hold it. It returns a
+// PendingSubmission instead when none does by then
+func (p *Producer) SubmitAndWait(data BlockData, timeout time.Duration) (Block, *PendingSubmission, error) {
+	// subscribe first, as the block may be added before Submit returns
+	subscription := p.bc.Events().Subscribe(newEventFilter([]string{string(BlockAddedEvent)}, ""))
+	defer p.bc.Events().Unsubscribe(subscription)
+
+	entry, err := p.Submit(data)
+	if err != nil {
+		return Block{}, nil, err
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case event := <-subscription.Events:
+			if block, ok := event.Data.(Block); ok && entryID(block.Data) == entry.ID {
+				return block, nil, nil
+			}
+		case <-deadline.C:
+			return Block{}, &PendingSubmission{Pending: true, Entry: entry}, nil
+		}
+	}
+}
+
+// ReceiveBlock adds a committed block sent by a validator. A block the chain already holds
+// is accepted without being added again, and the blocks missing before it are first fetched
+// from its proposer. It tells whether the block was added
+func (p *Producer) ReceiveBlock(block Block) (bool, error) {
+	p.mutex.Lock()
+	added, err := p.receive(block)
+	outbox := p.takeOutbox()
+	p.mutex.Unlock()
+
+	p.send(outbox)
+	if added {
+		p.advance()
+	}
+	return added, err
+}
+
+func (p *Producer) receive(block Block) (bool, error) {
+	tip := p.bc.GetHead().Height
+	if block.Height <= tip {
+		if held, exists := p.bc.GetBlockByHeight(block.Height); exists && held.Hash == block.Hash {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: the chain holds another block at height %v", ErrBlockNotChained, block.Height)
+	}
+	if block.Height > tip+1 {
+		proposer, exists := p.validators.Validator(block.Proposer)
+		if !exists {
+			return false, fmt.Errorf("%w: %q is not a validator", ErrWrongProposer, block.Proposer)
+		}
+		if err := p.catchUp(proposer.URL, block.Height-1); err != nil {
+			return false, err
+		}
+	}
+	if err := p.add(block); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run catches up with the validators, then keeps catching up, and forwarding the mempool and
+// the messages of the current consensus round again, in case messages were lost
+func (p *Producer) Run() {
+	for {
+		p.sync()
+		p.advance()
+		p.resend()
+		p.bc.clock.Sleep(PRODUCER_RETRY_INTERVAL)
+	}
+}
+
+// sync adds the blocks the other validators hold beyond the tip
+func (p *Producer) sync() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, validator := range p.otherValidators() {
+		if err := p.catchUp(validator.URL, -1); err != nil {
+			logDebugf("Failed to sync with validator %v: %v", validator.Address, err)
+		}
+	}
+}
+
+// catchUp adds the blocks of the node at peer up to height, or all of them when height is
+// negative. The caller must hold p.mutex
+func (p *Producer) catchUp(peer string, height int) error {
+	for {
+		next := p.bc.GetHead().Height + 1
+		if height >= 0 && next > height {
+			return nil
+		}
+		limit := MAX_BLOCKS_PAGE_LIMIT
+		if height >= 0 {
+			limit = min(limit, height-next+1)
+		}
+		blocks, err := p.transport.GetBlocks(context.Background(), peer, next, limit)
+		if err != nil {
+			return fmt.Errorf("failed to catch up from %v: %w", peer, err)
+		}
+		if len(blocks) == 0 {
+			if height < 0 {
+				return nil
+			}
+			return fmt.Errorf("failed to catch up from %v: it has no block %v", peer, next)
+		}
+		for _, block := range blocks {
+			// every node adds the payout blocks itself, as it settles problems
+			if held, exists := p.bc.GetBlockByHeight(block.Height); exists {
+				if held.Hash != block.Hash {
+					return fmt.Errorf("%w: %v holds another block at height %v", ErrBlockNotChained, peer, block.Height)
+				}
+				continue
+			}
+			if err := p.add(block); err != nil {
+				return err
+			}
+		}
+		log.Printf("Caught up to block %v from %v", blocks[len(blocks)-1].Height, peer)
+		if height < 0 && len(blocks) < limit {
+			return nil
+		}
+	}
+}
+
+// add adds a committed block to the chain, drops the entry it holds from the mempool and
+// moves the consensus to the next height. The caller must hold p.mutex
+func (p *Producer) add(block Block) error {
+	if err := p.bc.AddBlock(block, p.ledger); err != nil {
+		return err
+	}
+	p.mempool.Included(entryID(block.Data), p.bc.clock.Now())
+	p.enterHeight()
+	return nil
+}
+
+// advance forwards the mempool to the validators, and starts deciding on the next block when
+// the node is a validator with submissions waiting
+func (p *Producer) advance() {
+	p.mutex.Lock()
+	p.mempool.Expire(p.bc.clock.Now())
+	if len(p.mempool.Entries()) > 0 {
+		p.activate()
+	}
+	p.process()
+	outbox := p.takeOutbox()
+	p.mutex.Unlock()
+	p.send(outbox)
+
+	for _, validator := range p.otherValidators() {
+		if entries := p.mempool.toForward(validator.URL); len(entries) > 0 {
+			validator := validator
+			p.spawn(func() { p.forward(validator, entries) })
+		}
+	}
+}
+
+// forward sends the entries to the mempool of a validator. Entries it rejects are dropped,
+// and the others are sent again if it could not be reached or rate limited them
+func (p *Producer) forward(validator Validator, entries []MempoolEntry) {
+	for _, entry := range entries {
+		err := p.transport.SendEntry(context.Background(), validator.URL, entry.Data)
+		var apiError *client.APIError
+		switch {
+		case err == nil:
+		case errors.As(err, &apiError) && apiError.StatusCode < http.StatusInternalServerError && apiError.StatusCode != http.StatusTooManyRequests:
+			log.Printf("Dropping mempool entry %v rejected by %v: %v", entry.ID, validator.URL, err)
+			p.mempool.Remove(entry.ID)
+		default:
+			logWarnf("Failed to forward mempool entry %v to %v: %v", entry.ID, validator.URL, err)
+			p.mempool.forwardFailed(entry.ID, validator.URL)
+		}
+	}
+}
+
+// post queues a message to send once p.mutex is released. The caller must hold p.mutex
+func (p *Producer) post(message func()) {
+	p.outbox = append(p.outbox, message)
+}
+
+// takeOutbox returns the messages queued. The caller must hold p.mutex
+func (p *Producer) takeOutbox() []func() {
+	outbox := p.outbox
+	p.outbox = nil
+	return outbox
+}
+
+func (p *Producer) send(outbox []func()) {
+	for _, message := range outbox {
+		p.spawn(message)
+	}
+}
+
+// checkEntry checks a submission could be added to a block at the tip, once the submissions
+// of its sender pending in mempool are
+func (bc *Blockchain) checkEntry(data BlockData, ledger *Ledger, mempool *Mempool) error {
+	if data.Type == BountyPayoutSubmission {
+		return fmt.Errorf("%w: payouts are not submitted", ErrInvalidBlockType)
+	}
+	bc.solveAhead(data, MAX_DP_CERTIFICATE_CELLS)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	block, err := bc.generateNewBlock(data)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	if sender := blockSender(block); !blockDataMissing(block) && sender != "" {
+		pending = mempool.pendingCount(sender, bc.store.Nonce(sender))
+	}
+	return bc.validateBlockData(block, ledger, pending)
+}
+
+// waitsForNonce tells whether a signed submission follows a submission of its sender not
+// added to a block yet
+func (bc *Blockchain) waitsForNonce(data BlockData) bool {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	sender := blockSender(Block{Data: data})
+	return isKeyAddress(sender) && submissionNonce(data) > bc.store.Nonce(sender)+1
+}
+
+func HandleReceiveBlock(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var block Block
+	if err := decodeJSONBody(r, &block); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	added, err := producer.ReceiveBlock(block)
+	if err != nil {
+		logDebugf("Rejected block %v: %v", block.Height, err)
+		respondWithError(w, err)
+		return
+	}
+	if added {
+		respondWithJSON(w, http.StatusCreated, block)
+	} else {
+		respondWithJSON(w, http.StatusOK, block)
+	}
+}
+
+func HandleSubmitEntry(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	var data BlockData
+	if err := decodeJSONBody(r, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+
+	if err := bc.checkLimits(data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	entry, err := producer.Submit(data)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, entry)
+}
+
+func HandleGetMempool(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, producer.Mempool())
+}
@@ -0,0 +1,111 @@
+package main
+
+import "fmt"
+
+// Pruning. A node with a prune depth drops the bodies of the blocks deeper than the depth
+// and before the blocks of the latest snapshot, and keeps their headers. Validation only
+// looks at the last NUMBER_OF_BLOCKS_TO_SOLUTION blocks, and the balances, nonces and open
+// problems of the older ones are in the snapshot. The headers keep the hash chain and the
+// address index. The store of a pruned node holds the latest snapshot, the headers of the
+// pruned blocks and the bodies held
+
+// BlockHeader is what the chain keeps of a pruned block
+type BlockHeader struct {
+	Height    int           `json:"height"`
+	Hash      string        `json:"hash"`
+	PrevHash  string        `json:"prevhash"`
+	StateRoot string        `json:"state_root,omitempty"`
+	Timestamp int64         `json:"timestamp,omitempty"`
+	Type      BlockDataType `json:"type"`
+	Addresses []string      `json:"addresses"` // addresses touched by the block
+}
+
+// HeaderPage is a range of consecutive block headers
+type HeaderPage struct {
+	Headers []BlockHeader `json:"headers"`
+	From    int           `json:"from"`
+	Limit   int           `json:"limit"`
+	Next    *int          `json:"next,omitempty"` // height of the first header of the next page, if any
+	Head    *ChainHead    `json:"head"`
+}
+
+func headerOf(block Block) BlockHeader {
+	return BlockHeader{
+		Height:    block.Height,
+		Hash:      block.Hash,
+		PrevHash:  block.PrevHash,
+		StateRoot: block.StateRoot,
+		Timestamp: block.Timestamp,
+		Type:      block.Data.Type,
+		Addresses: blockAddresses(block),
+	}
+}
+
+// headerAt returns the header of the block at height, pruned or not. The caller must hold bc.mutex
+func (bc *Blockchain) headerAt(height int) (BlockHeader, bool) {
+	return bc.store.Header(height)
+}
+
+// SetPruneDepth sets the number of blocks below the tip whose bodies are kept, and prunes
+// the chain. 0 keeps every body
+func (bc *Blockchain) SetPruneDepth(depth int) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	bc.pruneDepth = depth
+	bc.prune()
+}
+
+// prune drops the bodies the chain no longer needs. The caller must hold bc.mutex
+func (bc *Blockchain) prune() {
+	snapshot := bc.store.Snapshot()
+	if bc.pruneDepth == 0 || snapshot == nil {
+		return
+	}
+	// the blocks of the latest snapshot are kept, so the chain can be rebuilt from it
+	height := min(bc.getLastBlock().Height-bc.pruneDepth, snapshot.Height-NUMBER_OF_BLOCKS_TO_SOLUTION)
+	// pruning rewrites the log of the store, so it is done once every SNAPSHOT_INTERVAL blocks
+	height -= height % SNAPSHOT_INTERVAL
+	if height <= bc.store.Base() {
+		return
+	}
+
+	if err := bc.store.Write(Batch{PruneBelow: height}); err != nil {
+		logErrorf("Failed to prune the blocks below %v: %v", height, err)
+		return
+	}
+	logDebugf("Pruned the blocks below %v", height)
+}
+
+// GetHeaders returns up to limit block headers starting at height from
+func (bc *Blockchain) GetHeaders(from int, limit int) (*HeaderPage, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if headerBase := bc.store.HeaderBase(); from < headerBase {
+		return nil, fmt.Errorf("%w: the first header held is %v", ErrBlockPruned, headerBase)
+	}
+	page := &HeaderPage{
+		Headers: make([]BlockHeader, 0),
+		From:    from,
+		Limit:   limit,
+		Head:    bc.getHead(),
+	}
+	tip := bc.getLastBlock().Height
+	for height := from; height <= tip && len(page.Headers) < limit; height++ {
+		header, _ := bc.headerAt(height)
+		page.Headers = append(page.Headers, header)
+	}
+	if next := from + len(page.Headers); next <= tip {
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// missingBlockError tells why the chain has no body at height. The caller must hold bc.mutex
+func (bc *Blockchain) missingBlockError(height int) error {
+	if _, exists := bc.headerAt(height); exists || height < bc.store.HeaderBase() {
+		return ErrBlockPruned
+	}
+	return ErrBlockNotFound
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter creates the router of the node API. Every route must be documented in openapi.json
+func NewRouter(blockchain *Blockchain, ledger *Ledger) *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/api/openapi.json", HandleGetOpenAPI).Methods("GET")
+	router.HandleFunc("/api/heartbeat", HomeLink).Methods("GET")
+	router.HandleFunc("/api/home", HomeLink).Methods("GET")
+	router.HandleFunc("/api/get_blockchain", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetBlockchain(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/head", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetHead(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/blocks", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetBlocks(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/headers", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetHeaders(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/blocks/{height:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetBlockByHeight(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/blocks/hash/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetBlockByHash(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/get_ledger", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetLedger(w, r, ledger)
+	}).Methods("GET")
+	router.HandleFunc("/api/accounts/{address}", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetAccount(w, r, blockchain, ledger)
+	}).Methods("GET")
+	router.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		HandleEventStream(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/problems", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetOpenProblems(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/problems/{height}", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetProblem(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/validate/problem", func(w http.ResponseWriter, r *http.Request) {
+		HandleValidateProblem(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/validate/solution", func(w http.ResponseWriter, r *http.Request) {
+		HandleValidateProposedSolution(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/send_problem", func(w http.ResponseWriter, r *http.Request) {
+		HandleWriteProblemBlock(w, r, blockchain, ledger)
+	}).Methods("POST")
+	router.HandleFunc("/api/send_proposed_solution", func(w http.ResponseWriter, r *http.Request) {
+		HandleWriteProposedSolutionBlock(w, r, blockchain, ledger)
+	}).Methods("POST")
+	router.HandleFunc("/api/send_transaction", func(w http.ResponseWriter, r *http.Request) {
+		HandleWriteTransactionBlock(w, r, blockchain, ledger)
+	}).Methods("POST")
+	router.HandleFunc("/api/blocks", func(w http.ResponseWriter, r *http.Request) {
+		HandleReceiveBlock(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/mempool", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetMempool(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/mempool", func(w http.ResponseWriter, r *http.Request) {
+		HandleSubmitEntry(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/consensus", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetConsensus(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/consensus/proposals", func(w http.ResponseWriter, r *http.Request) {
+		HandleReceiveProposal(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/consensus/votes", func(w http.ResponseWriter, r *http.Request) {
+		HandleReceiveVote(w, r, blockchain)
+	}).Methods("POST")
+	router.HandleFunc("/api/validators", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetValidators(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/export", func(w http.ResponseWriter, r *http.Request) {
+		HandleExportArchive(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/api/import", func(w http.ResponseWriter, r *http.Request) {
+		HandleImportArchive(w, r, blockchain, ledger)
+	}).Methods("POST")
+	router.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetSnapshot(w, r, blockchain)
+	}).Methods("GET")
+	router.HandleFunc("/rpc", func(w http.ResponseWriter, r *http.Request) {
+		HandleRPC(w, r, blockchain, ledger)
+	}).Methods("POST")
+
+	return router
+}
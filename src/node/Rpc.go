@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// JSON-RPC 2.0 interface. It exposes the same operations as the REST API and
+// shares their logic, so both always behave the same
+
+const JSONRPC_VERSION = "2.0"
+
+// JSON-RPC error codes. Errors of the blockchain use RPC_APPLICATION_ERROR and
+// carry the stable error code of the REST API in their data
+const (
+	RPC_PARSE_ERROR       = -32700
+	RPC_INVALID_REQUEST   = -32600
+	RPC_METHOD_NOT_FOUND  = -32601
+	RPC_INVALID_PARAMS    = -32602
+	RPC_INTERNAL_ERROR    = -32603
+	RPC_APPLICATION_ERROR = -32000
+)
+
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // requests without id are notifications
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type RPCError struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorBody `json:"data,omitempty"`
+}
+
+// RPCNotification is pushed to subscribers for every event
+type RPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  Event  `json:"params"`
+}
+
+type rpcHeightOrHashParams struct {
+	Height *int   `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+type rpcPageParams struct {
+	From   int `json:"from"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+type rpcAddressParams struct {
+	Address string `json:"address"`
+	rpcPageParams
+}
+
+type rpcSubscribeParams struct {
+	Types   []string `json:"types"`
+	Address string   `json:"address"`
+}
+
+type rpcBalance struct {
+	Address string  `json:"address"`
+	Balance float64 `json:"balance"`
+}
+
+type rpcMethod func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error)
+
+// errInvalidParams marks errors caused by malformed params
+var errInvalidParams = errors.New("invalid params")
+
+// rpcMethods maps every method to the shared logic of the matching REST route.
+// subscribe is handled by HandleRPC as it turns the response into a stream
+var rpcMethods = map[string]rpcMethod{
+	"get_head": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		return bc.GetHead(), nil
+	},
+	"get_block": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var p rpcHeightOrHashParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.Height != nil:
+			return bc.LookupBlock(*p.Height)
+		case p.Hash != "":
+			return bc.LookupBlockByHash(p.Hash)
+		default:
+			return nil, fmt.Errorf("%w: height or hash is required", errInvalidParams)
+		}
+	},
+	"get_blocks": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		p := rpcPageParams{Limit: DEFAULT_BLOCKS_PAGE_LIMIT}
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := checkPage("from", p.From, p.Limit); err != nil {
+			return nil, err
+		}
+		return bc.GetBlocks(p.From, p.Limit)
+	},
+	"get_headers": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		p := rpcPageParams{Limit: DEFAULT_BLOCKS_PAGE_LIMIT}
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := checkPage("from", p.From, p.Limit); err != nil {
+			return nil, err
+		}
+		return bc.GetHeaders(p.From, p.Limit)
+	},
+	"get_balance": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var p rpcAddressParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Address == "" {
+			return nil, fmt.Errorf("%w: address is required", errInvalidParams)
+		}
+		return rpcBalance{Address: p.Address, Balance: ledger.GetBalance(p.Address)}, nil
+	},
+	"get_account": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		p := rpcAddressParams{rpcPageParams: rpcPageParams{Limit: DEFAULT_BLOCKS_PAGE_LIMIT}}
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Address == "" {
+			return nil, fmt.Errorf("%w: address is required", errInvalidParams)
+		}
+		if err := checkPage("offset", p.Offset, p.Limit); err != nil {
+			return nil, err
+		}
+		return bc.GetAccount(p.Address, ledger, p.Offset, p.Limit), nil
+	},
+	"get_open_problems": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		return bc.GetOpenProblems(), nil
+	},
+	"get_problem": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var p rpcHeightOrHashParams
+		if err := decodeRPCParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Height == nil {
+			return nil, fmt.Errorf("%w: height is required", errInvalidParams)
+		}
+		return bc.GetProblemHistory(*p.Height)
+	},
+	"validate_problem": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var problem KnapsackProblem
+		if err := decodeRPCParams(params, &problem); err != nil {
+			return nil, err
+		}
+		return bc.DryRunProblem(problem), nil
+	},
+	"validate_solution": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var proposedSolution KnapsackProposedSolution
+		if err := decodeRPCParams(params, &proposedSolution); err != nil {
+			return nil, err
+		}
+		return bc.DryRunProposedSolution(proposedSolution), nil
+	},
+	"submit_problem": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var problem KnapsackProblem
+		if err := decodeRPCParams(params, &problem); err != nil {
+			return nil, err
+		}
+		return submitRPC(problem, bc.GenerateProblemBlock, bc, ledger)
+	},
+	"submit_solution": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var proposedSolution KnapsackProposedSolution
+		if err := decodeRPCParams(params, &proposedSolution); err != nil {
+			return nil, err
+		}
+		return submitRPC(proposedSolution, bc.GenerateProposedSolutionBlock, bc, ledger)
+	},
+	"submit_transaction": func(params json.RawMessage, bc *Blockchain, ledger *Ledger) (interface{}, error) {
+		var tx Transaction
+		if err := decodeRPCParams(params, &tx); err != nil {
+			return nil, err
+		}
+		return submitRPC(tx, bc.GenerateTransactionBlock, bc, ledger)
+	},
+}
+
+// submitRPC submits data like the REST routes. The result is the block holding it, or its
+// PendingSubmission when no block holds it yet
+func submitRPC[T any](data T, generateBlock func(T) (Block, error), bc *Blockchain, ledger *Ledger) (interface{}, error) {
+	block, pending, err := SubmitBlockData(data, generateBlock, bc, ledger)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return pending, nil
+	}
+	return block, nil
+}
+
+func decodeRPCParams(params json.RawMessage, p interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, p); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidParams, err)
+	}
+	return nil
+}
+
+func newRPCError(err error) *RPCError {
+	if errors.Is(err, errInvalidParams) {
+		return &RPCError{Code: RPC_INVALID_PARAMS, Message: err.Error()}
+	}
+	_, status := classifyError(err)
+	if status == http.StatusInternalServerError {
+		return &RPCError{Code: RPC_INTERNAL_ERROR, Message: err.Error()}
+	}
+	return &RPCError{Code: RPC_APPLICATION_ERROR, Message: err.Error(), Data: newErrorBody(err)}
+}
+
+// callRPC runs a single request. It returns nil for notifications
+func callRPC(request RPCRequest, bc *Blockchain, ledger *Ledger) *RPCResponse {
+	response := &RPCResponse{JSONRPC: JSONRPC_VERSION, ID: request.ID}
+	if response.ID == nil {
+		response.ID = json.RawMessage("null")
+	}
+
+	if request.JSONRPC != JSONRPC_VERSION || request.Method == "" {
+		response.Error = &RPCError{Code: RPC_INVALID_REQUEST, Message: "invalid request"}
+		return response
+	}
+
+	method, exists := rpcMethods[request.Method]
+	switch {
+	case request.Method == "subscribe":
+		response.Error = &RPCError{Code: RPC_INVALID_REQUEST, Message: "subscribe must be the only request of the call"}
+	case !exists:
+		response.Error = &RPCError{Code: RPC_METHOD_NOT_FOUND, Message: "method not found: " + request.Method}
+	default:
+		result, err := method(request.Params, bc, ledger)
+		if err != nil {
+			response.Error = newRPCError(err)
+		} else {
+			response.Result = result
+		}
+	}
+
+	if request.ID == nil {
+		return nil
+	}
+	return response
+}
+
+// HandleRPC serves JSON-RPC 2.0 requests and batches.
+// A single subscribe request turns the response into a server-sent event stream
+// of "event" notifications, filtered like /api/events
+func HandleRPC(w http.ResponseWriter, r *http.Request, bc *Blockchain, ledger *Ledger) {
+	var body json.RawMessage
+	if err := decodeJSONBody(r, &body); err != nil {
+		respondWithJSON(w, http.StatusOK, RPCResponse{
+			JSONRPC: JSONRPC_VERSION,
+			Error:   &RPCError{Code: RPC_PARSE_ERROR, Message: err.Error()},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	// batch
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var requests []RPCRequest
+		if err := json.Unmarshal(body, &requests); err != nil || len(requests) == 0 {
+			respondWithJSON(w, http.StatusOK, RPCResponse{
+				JSONRPC: JSONRPC_VERSION,
+				Error:   &RPCError{Code: RPC_INVALID_REQUEST, Message: "invalid batch"},
+				ID:      json.RawMessage("null"),
+			})
+			return
+		}
+		responses := make([]*RPCResponse, 0, len(requests))
+		for _, request := range requests {
+			if response := callRPC(request, bc, ledger); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	var request RPCRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		respondWithJSON(w, http.StatusOK, RPCResponse{
+			JSONRPC: JSONRPC_VERSION,
+			Error:   &RPCError{Code: RPC_INVALID_REQUEST, Message: err.Error()},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	if request.JSONRPC == JSONRPC_VERSION && request.Method == "subscribe" {
+		var p rpcSubscribeParams
+		if err := decodeRPCParams(request.Params, &p); err != nil {
+			respondWithJSON(w, http.StatusOK, RPCResponse{JSONRPC: JSONRPC_VERSION, Error: newRPCError(err), ID: request.ID})
+			return
+		}
+		streamEvents(w, r, bc, newEventFilter(p.Types, p.Address), func(event Event) interface{} {
+			return RPCNotification{JSONRPC: JSONRPC_VERSION, Method: "event", Params: event}
+		})
+		return
+	}
+
+	response := callRPC(request, bc, ledger)
+	if response == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"solvernet/client"
+)
+
+// Addresses derived from an ed25519 public key ("0x" + 40 hex digits) must sign
+// every problem, proposed solution and transfer they send, and number them with
+// consecutive nonces starting at 1 so they cannot be replayed.
+// Other addresses predate signing and keep submitting unsigned data
+
+var keyAddressPattern = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+
+func isKeyAddress(address string) bool {
+	return keyAddressPattern.MatchString(address)
+}
+
+// verifySignature checks the signature and nonce of data sent by address, for the given kind
+// of submission (see client.SigningPayload). The nonce follows the pending submissions of
+// address not added to a block yet
+func (bc *Blockchain) verifySignature(kind string, address string, nonce int, pending int, publicKey string, signature string, data interface{}) error {
+	if !isKeyAddress(address) {
+		if publicKey != "" || signature != "" {
+			return fmt.Errorf("%w: address %v is not derived from a public key", ErrInvalidSignature, address)
+		}
+		return nil
+	}
+
+	if signature == "" {
+		return fmt.Errorf("%w: missing signature", ErrInvalidSignature)
+	}
+	if err := client.VerifySignature(kind, publicKey, signature, data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	// the public key is valid hex, as the signature was verified with it
+	publicKeyBytes, _ := hex.DecodeString(publicKey)
+	if client.AddressFromPublicKey(publicKeyBytes) != address {
+		return fmt.Errorf("%w: public key does not match address", ErrInvalidSignature)
+	}
+
+	if expected := bc.store.Nonce(address) + 1 + pending; nonce != expected {
+		return fmt.Errorf("%w: expected %v, got %v", ErrInvalidNonce, expected, nonce)
+	}
+	return nil
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"solvernet/client"
+)
+
+// The simulator runs many nodes, each with its own blockchain and ledger, in one
+// process on virtual time. It is a discrete event simulation: node steps and
+// message deliveries are events run one at a time in time order, so a run only
+// depends on its config and seed and is reproduced exactly by running it again
+
+var ErrPeerUnreachable = errors.New("peer unreachable")
+
+type SimConfig struct {
+	Nodes      int
+	Seed       int64
+	Duration   time.Duration // virtual time to simulate
+	MinLatency time.Duration // delivery delay of a message, drawn between the min and the max
+	MaxLatency time.Duration
+	DropRate   float64 // probability that a message is lost
+	Partitions []SimPartition
+	Validators bool // every node is a validator, and blocks are made by the proposer of their height
+}
+
+// SimPartition splits the nodes into groups that cannot reach each other during [From, Until)
+type SimPartition struct {
+	From   time.Duration
+	Until  time.Duration
+	Groups [][]int // node indexes. Nodes in no group are isolated
+}
+
+// SimReport is the outcome of a simulation
+type SimReport struct {
+	Seed       int64
+	Validators bool
+	Duration   time.Duration
+	Messages   SimMessageStats
+	Nodes      []SimNodeReport
+	Agreement  int      // number of blocks, from genesis, that all the chains have in common
+	Balances   []string // addresses whose balance differs between the nodes
+	Replay     []string // nodes whose chain cannot be replayed from genesis, with the reason
+}
+
+type SimMessageStats struct {
+	Sent        int
+	Delivered   int
+	Rejected    int // delivered but refused by the receiving chain
+	Dropped     int
+	Partitioned int
+}
+
+type SimNodeReport struct {
+	Name      string
+	Height    int
+	TipHash   string
+	Problems  int
+	Solutions int
+	Payouts   int
+	Balance   float64 // balance of the node address in its own ledger
+}
+
+type simNode struct {
+	name       string
+	node       *Node
+	bc         *Blockchain
+	ledger     *Ledger
+	producer   *Producer   // nil without validators
+	forkChoice *ForkChoice // nil with validators
+}
+
+type Simulator struct {
+	config SimConfig
+	start  time.Time
+	clock  *SimClock
+	random *rand.Rand
+	nodes  []*simNode
+	index  map[string]int // node index by name
+	queue  simQueue
+	stats  SimMessageStats
+}
+
+// simStart is the virtual time a simulation starts at
+var simStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewSimulator creates the nodes of a simulation. Every node has all the others as peers
+func NewSimulator(config SimConfig) (*Simulator, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Simulator{
+		config: config,
+		start:  simStart,
+		clock:  NewSimClock(simStart),
+		random: rand.New(rand.NewSource(config.Seed)),
+		index:  make(map[string]int),
+	}
+
+	names := make([]string, config.Nodes)
+	for i := range names {
+		names[i] = "node-" + strconv.Itoa(i)
+		s.index[names[i]] = i
+	}
+	// validators are reached by node name, and their keys are drawn from the seed
+	var validators *ValidatorSet
+	keys := make([]*client.Key, config.Nodes)
+	if config.Validators {
+		validators = &ValidatorSet{}
+		for i, name := range names {
+			seed := make([]byte, ed25519.SeedSize)
+			s.random.Read(seed)
+			keys[i] = client.KeyFromSeed(seed)
+			validators.validators = append(validators.validators, Validator{Address: keys[i].Address, URL: name})
+		}
+	}
+	for i, name := range names {
+		peers := make([]string, 0, len(names)-1)
+		for j, peer := range names {
+			if j != i {
+				peers = append(peers, peer)
+			}
+		}
+		ledger := NewLedger()
+		random := rand.New(rand.NewSource(s.random.Int63()))
+		bc := CreateNewBlockchain(ledger)
+		bc.SetClock(s.clock)
+		transport := &simTransport{simulator: s, from: i}
+		node := &simNode{
+			name:   name,
+			node:   NewNodeWith(name, peers, transport, s.clock, random),
+			bc:     bc,
+			ledger: ledger,
+		}
+		if validators != nil {
+			node.producer = NewProducer(bc, ledger, validators, keys[i], nil, transport)
+			// messages are delivered as events, so they are sent right away
+			node.producer.spawn = func(task func()) { task() }
+			node.producer.schedule = func(delay time.Duration, task func()) { s.schedule(s.clock.Now().Add(delay), task) }
+		} else {
+			node.forkChoice = NewForkChoice(bc, ledger, peers, transport)
+		}
+		s.nodes = append(s.nodes, node)
+	}
+	return s, nil
+}
+
+func (config SimConfig) validate() error {
+	problems := make([]string, 0)
+	if config.Nodes < 1 {
+		problems = append(problems, "at least one node is required")
+	}
+	if config.Duration <= 0 {
+		problems = append(problems, "the duration must be positive")
+	}
+	if config.MinLatency < 0 || config.MaxLatency < config.MinLatency {
+		problems = append(problems, "invalid latency range")
+	}
+	if config.DropRate < 0 || config.DropRate > 1 {
+		problems = append(problems, "the drop rate must be between 0 and 1")
+	}
+	for _, partition := range config.Partitions {
+		if partition.Until <= partition.From {
+			problems = append(problems, "a partition must end after it starts")
+		}
+		for _, group := range partition.Groups {
+			for _, node := range group {
+				if node < 0 || node >= config.Nodes {
+					problems = append(problems, fmt.Sprintf("partition node %v does not exist", node))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid simulation: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Run simulates the configured duration and reports the state of the nodes
+func (s *Simulator) Run() SimReport {
+	for _, node := range s.nodes {
+		s.scheduleStep(node)
+		if node.producer != nil {
+			s.scheduleSync(node)
+		} else {
+			s.scheduleForkChoice(node)
+		}
+	}
+
+	end := s.start.Add(s.config.Duration)
+	for s.queue.Len() > 0 {
+		event := heap.Pop(&s.queue).(*simEvent)
+		if event.at.After(end) {
+			break
+		}
+		s.clock.AdvanceTo(event.at)
+		event.run()
+	}
+	s.clock.AdvanceTo(end)
+
+	return s.report()
+}
+
+func (s *Simulator) scheduleStep(node *simNode) {
+	s.schedule(s.clock.Now().Add(node.node.NextStepDelay()), func() {
+		node.node.Step(node.bc)
+		s.scheduleStep(node)
+	})
+}
+
+// scheduleSync runs the loop of Producer.Run as events
+func (s *Simulator) scheduleSync(node *simNode) {
+	s.schedule(s.clock.Now().Add(PRODUCER_RETRY_INTERVAL), func() {
+		node.producer.sync()
+		node.producer.advance()
+		node.producer.resend()
+		s.scheduleSync(node)
+	})
+}
+
+// scheduleForkChoice runs the loop of ForkChoice.Run as events
+func (s *Simulator) scheduleForkChoice(node *simNode) {
+	s.schedule(s.clock.Now().Add(FORK_CHOICE_INTERVAL), func() {
+		node.forkChoice.sync()
+		s.scheduleForkChoice(node)
+	})
+}
+
+func (s *Simulator) schedule(at time.Time, run func()) {
+	heap.Push(&s.queue, &simEvent{at: at, sequence: s.queue.sequence, run: run})
+	s.queue.sequence++
+}
+
+// reachable tells whether a message from one node can reach another at the current time
+func (s *Simulator) reachable(from int, to int) bool {
+	elapsed := s.clock.Now().Sub(s.start)
+	for _, partition := range s.config.Partitions {
+		if elapsed < partition.From || elapsed >= partition.Until {
+			continue
+		}
+		if partitionGroup(partition, from) < 0 || partitionGroup(partition, from) != partitionGroup(partition, to) {
+			return false
+		}
+	}
+	return true
+}
+
+func partitionGroup(partition SimPartition, node int) int {
+	for i, group := range partition.Groups {
+		for _, member := range group {
+			if member == node {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// send delivers a message after the latency, unless it is dropped or the receiver is unreachable.
+// Like an HTTP request, a message that cannot be delivered fails at once
+func (s *Simulator) send(from int, peer string, deliver func(*simNode) error) error {
+	to, exists := s.index[peer]
+	if !exists {
+		return fmt.Errorf("%w: %v", ErrPeerUnreachable, peer)
+	}
+	s.stats.Sent++
+	if !s.reachable(from, to) {
+		s.stats.Partitioned++
+		return fmt.Errorf("%w: %v is partitioned", ErrPeerUnreachable, peer)
+	}
+	if s.random.Float64() < s.config.DropRate {
+		s.stats.Dropped++
+		return fmt.Errorf("%w: message to %v dropped", ErrPeerUnreachable, peer)
+	}
+
+	latency := s.config.MinLatency
+	if spread := s.config.MaxLatency - s.config.MinLatency; spread > 0 {
+		latency += time.Duration(s.random.Int63n(int64(spread) + 1))
+	}
+	receiver := s.nodes[to]
+	s.schedule(s.clock.Now().Add(latency), func() {
+		s.stats.Delivered++
+		if err := deliver(receiver); err != nil {
+			s.stats.Rejected++
+			logDebugf("%v rejected a message from %v: %v", receiver.name, s.nodes[from].name, err)
+		}
+	})
+	return nil
+}
+
+func (s *Simulator) report() SimReport {
+	report := SimReport{
+		Seed:       s.config.Seed,
+		Validators: s.config.Validators,
+		Duration:   s.config.Duration,
+		Messages:   s.stats,
+		Balances:   make([]string, 0),
+		Replay:     make([]string, 0),
+	}
+
+	for _, node := range s.nodes {
+		blocks := node.bc.GetAllBlocks()
+		nodeReport := SimNodeReport{
+			Name:    node.name,
+			Height:  blocks[len(blocks)-1].Height,
+			TipHash: blocks[len(blocks)-1].Hash,
+			Balance: node.ledger.GetBalance(node.node.Address),
+		}
+		for _, block := range blocks[1:] {
+			switch block.Data.Type {
+			case KnapsackProblemSubmission:
+				nodeReport.Problems++
+			case KnapsackProposedSolutionSubmission:
+				nodeReport.Solutions++
+			case BountyPayoutSubmission:
+				nodeReport.Payouts++
+			}
+		}
+		report.Nodes = append(report.Nodes, nodeReport)
+
+		if _, err := ReplayBlocks(blocks, NewLedger()); err != nil {
+			report.Replay = append(report.Replay, fmt.Sprintf("%v: %v", node.name, err))
+		}
+	}
+
+	report.Agreement = len(s.nodes[0].bc.GetAllBlocks())
+	for _, node := range s.nodes[1:] {
+		report.Agreement = min(report.Agreement, commonPrefix(s.nodes[0].bc.GetAllBlocks(), node.bc.GetAllBlocks()))
+	}
+
+	addresses := make(map[string]bool)
+	for _, node := range s.nodes {
+		for address := range node.ledger.Balances() {
+			addresses[address] = true
+		}
+	}
+	for address := range addresses {
+		balance := s.nodes[0].ledger.GetBalance(address)
+		for _, node := range s.nodes[1:] {
+			if node.ledger.GetBalance(address) != balance {
+				report.Balances = append(report.Balances, address)
+				break
+			}
+		}
+	}
+	sort.Strings(report.Balances)
+	return report
+}
+
+func commonPrefix(a []Block, b []Block) int {
+	length := 0
+	for length < len(a) && length < len(b) && a[length].Hash == b[length].Hash {
+		length++
+	}
+	return length
+}
+
+// simTransport is the transport of one simulated node
+type simTransport struct {
+	simulator *Simulator
+	from      int
+}
+
+func (t *simTransport) Heartbeat(ctx context.Context, peer string) error {
+	to, exists := t.simulator.index[peer]
+	if !exists || !t.simulator.reachable(t.from, to) {
+		return fmt.Errorf("%w: %v", ErrPeerUnreachable, peer)
+	}
+	return nil
+}
+
+func (t *simTransport) SendProblem(ctx context.Context, peer string, problem KnapsackProblem) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		return simSubmit(receiver, problem, receiver.bc.GenerateProblemBlock)
+	})
+}
+
+func (t *simTransport) SendProposedSolution(ctx context.Context, peer string, solution KnapsackProposedSolution) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		return simSubmit(receiver, solution, receiver.bc.GenerateProposedSolutionBlock)
+	})
+}
+
+// simSubmit adds a submission at the tip of the receiver, or to its mempool when it has a
+// producer. Simulated nodes do not wait for the block holding it
+func simSubmit[T any](receiver *simNode, data T, generateBlock func(T) (Block, error)) error {
+	if receiver.producer == nil {
+		_, _, err := SubmitBlockData(data, generateBlock, receiver.bc, receiver.ledger)
+		return err
+	}
+	block, err := generateBlock(data)
+	if err != nil {
+		return err
+	}
+	_, err = receiver.producer.Submit(block.Data)
+	return err
+}
+
+func (t *simTransport) SendEntry(ctx context.Context, peer string, data BlockData) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		if receiver.producer == nil {
+			return ErrNoValidators
+		}
+		_, err := receiver.producer.Submit(data)
+		return err
+	})
+}
+
+func (t *simTransport) SendBlock(ctx context.Context, peer string, block Block) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		if receiver.producer == nil {
+			return ErrNoValidators
+		}
+		_, err := receiver.producer.ReceiveBlock(block)
+		return err
+	})
+}
+
+func (t *simTransport) SendProposal(ctx context.Context, peer string, proposal Proposal) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		if receiver.producer == nil {
+			return ErrNoValidators
+		}
+		return receiver.producer.ReceiveProposal(proposal)
+	})
+}
+
+func (t *simTransport) SendVote(ctx context.Context, peer string, vote Vote) error {
+	return t.simulator.send(t.from, peer, func(receiver *simNode) error {
+		if receiver.producer == nil {
+			return ErrNoValidators
+		}
+		return receiver.producer.ReceiveVote(vote)
+	})
+}
+
+// GetHead is answered at once, as the requests of a node are not events
+func (t *simTransport) GetHead(ctx context.Context, peer string) (*ChainHead, error) {
+	to, exists := t.simulator.index[peer]
+	if !exists || !t.simulator.reachable(t.from, to) {
+		return nil, fmt.Errorf("%w: %v", ErrPeerUnreachable, peer)
+	}
+	return t.simulator.nodes[to].bc.GetHead(), nil
+}
+
+// GetBlocks is answered at once, as the requests of a node are not events
+func (t *simTransport) GetBlocks(ctx context.Context, peer string, from int, limit int) ([]Block, error) {
+	to, exists := t.simulator.index[peer]
+	if !exists || !t.simulator.reachable(t.from, to) {
+		return nil, fmt.Errorf("%w: %v", ErrPeerUnreachable, peer)
+	}
+	page, err := t.simulator.nodes[to].bc.GetBlocks(from, limit)
+	if err != nil {
+		return nil, err
+	}
+	return page.Blocks, nil
+}
+
+// simEvent is something happening at a point of virtual time. Events at the
+// same time run in the order they were scheduled
+type simEvent struct {
+	at       time.Time
+	sequence int
+	run      func()
+}
+
+type simQueue struct {
+	events   []*simEvent
+	sequence int
+}
+
+func (q *simQueue) Len() int { return len(q.events) }
+func (q *simQueue) Less(i, j int) bool {
+	if q.events[i].at.Equal(q.events[j].at) {
+		return q.events[i].sequence < q.events[j].sequence
+	}
+	return q.events[i].at.Before(q.events[j].at)
+}
+func (q *simQueue) Swap(i, j int)      { q.events[i], q.events[j] = q.events[j], q.events[i] }
+func (q *simQueue) Push(x interface{}) { q.events = append(q.events, x.(*simEvent)) }
+func (q *simQueue) Pop() interface{} {
+	last := q.events[len(q.events)-1]
+	q.events = q.events[:len(q.events)-1]
+	return last
+}
+
+func runSim(command *command, args []string) error {
+	flags := newFlags(command)
+	config := SimConfig{}
+	flags.IntVar(&config.Nodes, "nodes", 4, "number of nodes")
+	flags.Int64Var(&config.Seed, "seed", 1, "seed of the randomness. The same seed gives the same run")
+	flags.DurationVar(&config.Duration, "duration", 10*time.Minute, "virtual time to simulate")
+	flags.DurationVar(&config.MinLatency, "min-latency", 20*time.Millisecond, "minimum delivery delay of a message")
+	flags.DurationVar(&config.MaxLatency, "max-latency", 200*time.Millisecond, "maximum delivery delay of a message")
+	flags.Float64Var(&config.DropRate, "drop", 0, "probability that a message is lost")
+	flags.BoolVar(&config.Validators, "validators", false, "make every node a validator, blocks being made by the proposer of their height")
+	partition := flags.String("partition", "", "groups of nodes split by a partition, e.g. 0,1|2,3")
+	partitionFrom := flags.Duration("partition-from", 0, "time the partition starts")
+	partitionUntil := flags.Duration("partition-until", 0, "time the partition heals. By default it lasts until the end")
+	logLevel := flags.String("log-level", "error", "log level of the nodes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if _, exists := logLevels[*logLevel]; !exists {
+		return fmt.Errorf("invalid log level %q", *logLevel)
+	}
+	SetLogLevel(*logLevel)
+
+	if *partition != "" {
+		groups, err := parsePartitionGroups(*partition)
+		if err != nil {
+			return err
+		}
+		until := *partitionUntil
+		if until == 0 {
+			until = config.Duration
+		}
+		config.Partitions = []SimPartition{{From: *partitionFrom, Until: until, Groups: groups}}
+	}
+
+	simulator, err := NewSimulator(config)
+	if err != nil {
+		return err
+	}
+	report := simulator.Run()
+	printSimReport(report)
+	if len(report.Replay) > 0 {
+		return errors.New("some chains cannot be replayed")
+	}
+	return nil
+}
+
+// parsePartitionGroups parses groups of node indexes, like 0,1|2,3
+func parsePartitionGroups(value string) ([][]int, error) {
+	groups := make([][]int, 0)
+	for _, group := range strings.Split(value, "|") {
+		nodes := make([]int, 0)
+		for _, entry := range splitList(group) {
+			node, err := strconv.Atoi(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid partition %q", value)
+			}
+			nodes = append(nodes, node)
+		}
+		groups = append(groups, nodes)
+	}
+	return groups, nil
+}
+
+func printSimReport(report SimReport) {
+	messages := report.Messages
+	fmt.Printf("simulated %v with %v nodes, seed %v\n", report.Duration, len(report.Nodes), report.Seed)
+	if report.Validators {
+		fmt.Println("every node is a validator")
+	}
+	fmt.Printf("messages: %v sent, %v delivered, %v rejected, %v dropped, %v partitioned\n",
+		messages.Sent, messages.Delivered, messages.Rejected, messages.Dropped, messages.Partitioned)
+	fmt.Printf("\n%-8v %7v  %-16v %9v %10v %8v %10v\n", "node", "height", "tip", "problems", "solutions", "payouts", "balance")
+	for _, node := range report.Nodes {
+		fmt.Printf("%-8v %7v  %-16v %9v %10v %8v %10.4f\n",
+			node.Name, node.Height, node.TipHash[:16], node.Problems, node.Solutions, node.Payouts, node.Balance)
+	}
+	fmt.Printf("\nchains: the nodes agree on the first %v blocks\n", report.Agreement)
+	if len(report.Balances) == 0 {
+		fmt.Println("balances: the nodes agree on every balance")
+	} else {
+		fmt.Printf("balances: the nodes disagree on %v addresses: %v\n", len(report.Balances), strings.Join(report.Balances, ", "))
+	}
+	if len(report.Replay) == 0 {
+		fmt.Println("replay: every chain replays from genesis")
+	}
+	for _, failure := range report.Replay {
+		fmt.Println("replay: FAILED", failure)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"solvernet/client"
+)
+
+// State snapshots. Every SNAPSHOT_INTERVAL blocks, the state after the block before the
+// interval is captured, and its root is committed in the state_root of the next block.
+// A node can then start from a snapshot and the blocks after it, instead of the whole chain.
+// The snapshot carries the last NUMBER_OF_BLOCKS_TO_SOLUTION blocks before it, which the
+// open problems, their solutions and the settlements of the next blocks are checked against
+
+// ChainState is the state the next blocks are validated against
+type ChainState struct {
+	Balances     map[string]float64 `json:"balances"`      // addresses that have been part of a transfer
+	Nonces       map[string]int     `json:"nonces"`        // addresses that have submitted entries
+	OpenProblems []ProblemSummary   `json:"open_problems"` // along with their best solutions
+	Escrow       map[string]float64 `json:"escrow"`        // bounties of the open problems, by address
+}
+
+// StateSnapshot is the state of the chain after the block at Height
+type StateSnapshot struct {
+	Height    int        `json:"height"`
+	BlockHash string     `json:"block_hash"`
+	StateRoot string     `json:"state_root"`
+	State     ChainState `json:"state"`
+	Blocks    []Block    `json:"blocks"` // from Height - NUMBER_OF_BLOCKS_TO_SOLUTION to Height
+}
+
+// stateRoot hashes the canonical JSON of a state. Maps are encoded with sorted keys, and the
+// state holds no value json cannot encode
+func stateRoot(height int, blockHash string, state ChainState) string {
+	bytes, _ := json.Marshal(struct {
+		Height    int        `json:"height"`
+		BlockHash string     `json:"block_hash"`
+		State     ChainState `json:"state"`
+	}{height, blockHash, state})
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// isSnapshotHeight tells whether the state after the block at height is captured
+func isSnapshotHeight(height int) bool {
+	return (height+1)%SNAPSHOT_INTERVAL == 0
+}
+
+// expectedStateRoot returns the state root the block at height must commit. The caller must hold bc.mutex
+func (bc *Blockchain) expectedStateRoot(height int) string {
+	snapshot := bc.store.Snapshot()
+	if height == 0 || height%SNAPSHOT_INTERVAL != 0 || snapshot == nil || snapshot.Height != height-1 {
+		return ""
+	}
+	return snapshot.StateRoot
+}
+
+// takeSnapshotIfDue captures the state when the tip is at a snapshot height, before the
+// problems the tip settles are. The caller must hold bc.mutex
+func (bc *Blockchain) takeSnapshotIfDue(ledger *Ledger) {
+	if tip := bc.getLastBlock(); isSnapshotHeight(tip.Height) {
+		snapshot := bc.takeSnapshot(ledger)
+		if err := bc.store.Write(Batch{Snapshot: snapshot}); err != nil {
+			logErrorf("Failed to write the snapshot at height %v: %v", snapshot.Height, err)
+			return
+		}
+		logDebugf("Snapshot at height %v, state root %v", snapshot.Height, snapshot.StateRoot)
+	}
+}
+
+// takeSnapshot captures the state after the tip. The caller must hold bc.mutex
+func (bc *Blockchain) takeSnapshot(ledger *Ledger) *StateSnapshot {
+	tip := bc.getLastBlock()
+	state := ChainState{
+		Balances:     ledger.Balances(),
+		Nonces:       bc.store.Nonces(),
+		OpenProblems: bc.openProblems(),
+		Escrow:       make(map[string]float64),
+	}
+	for _, summary := range state.OpenProblems {
+		state.Escrow[summary.Problem.Address] += summary.Problem.Bounty
+	}
+
+	return &StateSnapshot{
+		Height:    tip.Height,
+		BlockHash: tip.Hash,
+		StateRoot: stateRoot(tip.Height, tip.Hash, state),
+		State:     state,
+		Blocks:    bc.blockRange(tip.Height-NUMBER_OF_BLOCKS_TO_SOLUTION, tip.Height),
+	}
+}
+
+// GetSnapshot returns the latest snapshot of the chain
+func (bc *Blockchain) GetSnapshot() (*StateSnapshot, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	snapshot := bc.store.Snapshot()
+	if snapshot == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return snapshot, nil
+}
+
+// NewBlockchainFromSnapshot creates a blockchain starting at a snapshot, in the store of the
+// ledger, which must be empty. The state is checked against the blocks of the snapshot and its
+// root. The root itself is trusted: it must be checked against the block committing it
+func NewBlockchainFromSnapshot(snapshot *StateSnapshot, ledger *Ledger) (*Blockchain, error) {
+	if len(snapshot.Blocks) == 0 {
+		return nil, fmt.Errorf("%w: no blocks", ErrInvalidSnapshot)
+	}
+	first := snapshot.Blocks[0]
+	last := snapshot.Blocks[len(snapshot.Blocks)-1]
+	if first.Height != max(0, snapshot.Height-NUMBER_OF_BLOCKS_TO_SOLUTION) || last.Height != snapshot.Height || last.Hash != snapshot.BlockHash {
+		return nil, fmt.Errorf("%w: the blocks do not end at the snapshot", ErrInvalidSnapshot)
+	}
+	if first.Height == 0 && first.Hash != GenesisHash() {
+		return nil, fmt.Errorf("%w: genesis block does not match", ErrInvalidSnapshot)
+	}
+
+	// the blocks are chained to each other, and the first one is checked by its hash only
+	for i, block := range snapshot.Blocks {
+		calculatedHash, err := calculateHash(block)
+		chained := err == nil && calculatedHash == block.Hash
+		if i > 0 {
+			previous := snapshot.Blocks[i-1]
+			chained = chained && block.Height == previous.Height+1 && block.PrevHash == previous.Hash
+		}
+		if !chained || blockDataMissing(block) {
+			return nil, fmt.Errorf("%w: block %v", ErrInvalidSnapshot, block.Height)
+		}
+	}
+	// the nonces of the snapshot already count the entries of its blocks
+	batch := Batch{Blocks: snapshot.Blocks, Balances: snapshot.State.Balances, Nonces: snapshot.State.Nonces}
+	if err := ledger.store.Write(batch); err != nil {
+		return nil, err
+	}
+	bc := newBlockchain(ledger)
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	taken := bc.takeSnapshot(ledger)
+	if taken.StateRoot != snapshot.StateRoot {
+		return nil, fmt.Errorf("%w: the state does not match its root", ErrInvalidSnapshot)
+	}
+	if err := bc.store.Write(Batch{Snapshot: taken}); err != nil {
+		return nil, err
+	}
+
+	// the problems the last block settles are settled after the snapshot is taken
+	bc.settleAfter(last, ledger)
+	return bc, nil
+}
+
+// GenesisHash returns the hash of the genesis block every chain starts with
+func GenesisHash() string {
+	return CreateNewBlockchain(NewLedger()).GetBlock(0).Hash
+}
+
+// addReplayedBlock adds a block of another copy of the chain. Payouts are not added but
+// generated by the chain, and must match the given ones
+func (bc *Blockchain) addReplayedBlock(block Block, ledger *Ledger) error {
+	if existing, exists := bc.GetBlockByHeight(block.Height); exists {
+		if existing.Hash != block.Hash {
+			return fmt.Errorf("block %v differs from the chain", block.Height)
+		}
+		return nil
+	}
+	if block.Data.Type == BountyPayoutSubmission {
+		return fmt.Errorf("payout %v was not generated by the chain", block.Height)
+	}
+	if err := bc.AddBlock(block, ledger); err != nil {
+		return fmt.Errorf("block %v: %w", block.Height, err)
+	}
+	return nil
+}
+
+// copyTo writes the chain and its state to an empty store, in one batch. The chain must hold no pruned headers
+func (bc *Blockchain) copyTo(store Store) error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	return store.Write(Batch{
+		Blocks:   bc.blockRange(bc.store.Base(), bc.store.Tip()),
+		Balances: bc.store.Balances(),
+		Nonces:   bc.store.Nonces(),
+		Snapshot: bc.store.Snapshot(),
+	})
+}
+
+func HandleGetSnapshot(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	snapshot, err := bc.GetSnapshot()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	// a snapshot never changes, so its root identifies it
+	respondWithETag(w, r, snapshot.StateRoot, snapshot)
+}
+
+func runChainBootstrap(command *command, args []string) error {
+	flags := newFlags(command)
+	nodeURL := flags.String("node", envOrDefault("SOLVERNET_NODE", DEFAULT_NODE_URL), "URL of the node to download the snapshot and blocks from")
+	dataDir := flags.String("data-dir", envOrDefault("SOLVERNET_DATA_DIR", DEFAULT_DATA_DIR), "data dir of the new node")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := filepath.Join(*dataDir, LOG_STORE_FILE)
+	for _, file := range []string{LOG_STORE_FILE, PERSISTED_BLOCKCHAIN_FILE} {
+		if _, err := os.Stat(filepath.Join(*dataDir, file)); err == nil {
+			return fmt.Errorf("%v already holds a chain", *dataDir)
+		}
+	}
+	SetLogLevel("warn")
+	ctx := context.Background()
+	node := client.New(*nodeURL)
+
+	var snapshot StateSnapshot
+	clientSnapshot, err := node.GetSnapshot(ctx)
+	if err == nil {
+		err = convertJSON(clientSnapshot, &snapshot)
+	}
+	if err != nil {
+		return err
+	}
+	ledger := NewLedger()
+	bc, err := NewBlockchainFromSnapshot(&snapshot, ledger)
+	if err != nil {
+		return err
+	}
+
+	// the root is only trusted once found in the block committing it
+	committing, err := node.GetBlockByHeight(ctx, snapshot.Height+1)
+	if err != nil {
+		return fmt.Errorf("block %v, committing the snapshot: %w", snapshot.Height+1, err)
+	}
+	if committing.StateRoot != snapshot.StateRoot {
+		return fmt.Errorf("%w: block %v commits another state root", ErrInvalidSnapshot, committing.Height)
+	}
+
+	for from := snapshot.Height + 1; ; {
+		page, err := node.GetBlocks(ctx, from, MAX_BLOCKS_PAGE_LIMIT)
+		if err != nil {
+			return err
+		}
+		blocks, err := fromClientBlocks(page.Blocks)
+		if err != nil {
+			return err
+		}
+		for _, block := range blocks {
+			if err := bc.addReplayedBlock(block, ledger); err != nil {
+				return err
+			}
+		}
+		if page.Next == nil {
+			break
+		}
+		from = *page.Next
+	}
+
+	// the chain is built in memory, so nothing is written unless it is complete
+	if err := os.MkdirAll(*dataDir, 0755); err != nil {
+		return err
+	}
+	store, err := OpenLogStore(path, false)
+	if err != nil {
+		return err
+	}
+	if err := bc.copyTo(store); err != nil {
+		store.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	head := bc.GetHead()
+	fmt.Printf("bootstrapped %v from the snapshot at height %v, state root %v\n", *dataDir, snapshot.Height, snapshot.StateRoot)
+	fmt.Printf("the chain is at height %v, tip %v\n", head.Height, head.Hash)
+	return nil
+}
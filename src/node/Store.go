@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Block and state stores. The chain and its ledger keep their blocks, balances and nonces in
+// one store, written in batches: the blocks of a batch and the state they lead to are written
+// together or not at all. MemoryStore holds everything in memory, for tests and simulations.
+// LogStore appends the batches to a log in the data dir, see LogStore.go
+
+var (
+	ErrInvalidBatch  = errors.New("invalid batch")
+	ErrReadOnlyStore = errors.New("store opened read-only")
+)
+
+// BlockStore holds the blocks of a chain from its base to its tip. Below the base, the
+// bodies were pruned and only the headers are held
+type BlockStore interface {
+	HeaderBase() int // height of the first header held
+	Base() int       // height of the first body held
+	Tip() int        // height of the last block, -1 when there is none
+	Block(height int) (Block, bool)
+	Header(height int) (BlockHeader, bool) // pruned or not
+	HeightOf(hash string) (int, bool)
+	// Range visits the bodies held from height from to height to, in order, until visit fails
+	Range(from int, to int, visit func(Block) error) error
+}
+
+// StateStore holds the state the blocks of a chain lead to
+type StateStore interface {
+	Balance(address string) (float64, bool) // false for the addresses never part of a transfer
+	Balances() map[string]float64           // a copy
+	Nonce(address string) int
+	Nonces() map[string]int   // a copy
+	Snapshot() *StateSnapshot // the latest snapshot, nil before the first one
+}
+
+// Store holds a chain and its state
+type Store interface {
+	BlockStore
+	StateStore
+	Consensus() *ConsensusRecord // the latest record of the validator, nil when none
+	// Write applies a batch atomically
+	Write(batch Batch) error
+	Close() error
+}
+
+// Batch is a set of writes to a store
+type Batch struct {
+	Reset      bool               `json:"reset,omitempty"`       // the store is emptied first, as the chain switches to another branch
+	Blocks     []Block            `json:"blocks,omitempty"`      // appended after the tip. The first blocks of an empty store may start at any height
+	Balances   map[string]float64 `json:"balances,omitempty"`    // new balances of the addresses
+	Nonces     map[string]int     `json:"nonces,omitempty"`      // new nonces of the addresses
+	Snapshot   *StateSnapshot     `json:"snapshot,omitempty"`    // replaces the latest snapshot
+	PruneBelow int                `json:"prune_below,omitempty"` // the bodies below this height are dropped, and their headers kept
+	Consensus  *ConsensusRecord   `json:"consensus,omitempty"`   // replaces the record of the validator, kept across resets
+}
+
+// storeIndex is what every store keeps in memory: all but the bodies of the blocks
+type storeIndex struct {
+	mutex        sync.Mutex
+	headerBase   int
+	base         int
+	tip          int
+	headers      []BlockHeader // of the blocks from headerBase to base
+	hashToHeight map[string]int
+	balances     map[string]float64
+	nonces       map[string]int
+	snapshot     *StateSnapshot
+	consensus    *ConsensusRecord
+}
+
+func newStoreIndex() storeIndex {
+	return storeIndex{
+		tip:          -1,
+		hashToHeight: make(map[string]int),
+		balances:     make(map[string]float64),
+		nonces:       make(map[string]int),
+	}
+}
+
+// check tells whether a batch can be applied. The caller must hold index.mutex
+func (index *storeIndex) check(batch Batch) error {
+	tip := index.tip
+	if batch.Reset {
+		tip = -1
+	}
+	next := tip + 1
+	for i, block := range batch.Blocks {
+		if block.Height != next && (tip >= 0 || i > 0) {
+			return fmt.Errorf("%w: block %v does not follow block %v", ErrInvalidBatch, block.Height, next-1)
+		}
+		next = block.Height + 1
+	}
+	if batch.PruneBelow > 0 && batch.PruneBelow >= next {
+		return fmt.Errorf("%w: cannot prune the tip", ErrInvalidBatch)
+	}
+	return nil
+}
+
+// apply applies a checked batch, but for the bodies and the pruning. The caller must hold index.mutex
+func (index *storeIndex) apply(batch Batch) {
+	if batch.Reset {
+		index.headerBase, index.base, index.tip = 0, 0, -1
+		index.headers = nil
+		index.hashToHeight = make(map[string]int)
+		index.balances = make(map[string]float64)
+		index.nonces = make(map[string]int)
+		index.snapshot = nil
+	}
+	if index.tip < 0 && len(batch.Blocks) > 0 {
+		index.headerBase = batch.Blocks[0].Height
+		index.base = batch.Blocks[0].Height
+	}
+	for _, block := range batch.Blocks {
+		index.hashToHeight[block.Hash] = block.Height
+		index.tip = block.Height
+	}
+	for address, balance := range batch.Balances {
+		index.balances[address] = balance
+	}
+	for address, nonce := range batch.Nonces {
+		index.nonces[address] = nonce
+	}
+	if batch.Snapshot != nil {
+		index.snapshot = batch.Snapshot
+	}
+	if batch.Consensus != nil {
+		index.consensus = batch.Consensus
+	}
+}
+
+// prune keeps the headers of the bodies dropped from the base. The caller must hold index.mutex
+func (index *storeIndex) prune(headers []BlockHeader) {
+	index.headers = append(index.headers, headers...)
+	index.base += len(headers)
+}
+
+// header returns the header of a pruned block. The caller must hold index.mutex
+func (index *storeIndex) header(height int) (BlockHeader, bool) {
+	if height < index.headerBase || height >= index.base {
+		return BlockHeader{}, false
+	}
+	return index.headers[height-index.headerBase], true
+}
+
+func (index *storeIndex) HeaderBase() int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.headerBase
+}
+
+func (index *storeIndex) Base() int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.base
+}
+
+func (index *storeIndex) Tip() int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.tip
+}
+
+func (index *storeIndex) HeightOf(hash string) (int, bool) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	height, exists := index.hashToHeight[hash]
+	return height, exists
+}
+
+func (index *storeIndex) Balance(address string) (float64, bool) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	balance, exists := index.balances[address]
+	return balance, exists
+}
+
+func (index *storeIndex) Balances() map[string]float64 {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	balances := make(map[string]float64, len(index.balances))
+	for address, balance := range index.balances {
+		balances[address] = balance
+	}
+	return balances
+}
+
+func (index *storeIndex) Nonce(address string) int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.nonces[address]
+}
+
+func (index *storeIndex) Nonces() map[string]int {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	nonces := make(map[string]int, len(index.nonces))
+	for address, nonce := range index.nonces {
+		nonces[address] = nonce
+	}
+	return nonces
+}
+
+func (index *storeIndex) Snapshot() *StateSnapshot {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.snapshot
+}
+
+func (index *storeIndex) Consensus() *ConsensusRecord {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	return index.consensus
+}
+
+// MemoryStore is a store held in memory
+type MemoryStore struct {
+	storeIndex
+	blocks []Block // from base to the tip
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{storeIndex: newStoreIndex()}
+}
+
+func (store *MemoryStore) Block(height int) (Block, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.block(height)
+}
+
+// block returns the body at height, if held. The caller must hold store.mutex
+func (store *MemoryStore) block(height int) (Block, bool) {
+	if height < store.base || height > store.tip {
+		return Block{}, false
+	}
+	return store.blocks[height-store.base], true
+}
+
+func (store *MemoryStore) Header(height int) (BlockHeader, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if block, exists := store.block(height); exists {
+		return headerOf(block), true
+	}
+	return store.header(height)
+}
+
+func (store *MemoryStore) Range(from int, to int, visit func(Block) error) error {
+	store.mutex.Lock()
+	start := min(max(from-store.base, 0), len(store.blocks))
+	end := min(max(to-store.base+1, start), len(store.blocks))
+	// the bodies are never modified, so they are visited without holding the lock
+	blocks := store.blocks[start:end]
+	store.mutex.Unlock()
+
+	for _, block := range blocks {
+		if err := visit(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) Write(batch Batch) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := store.check(batch); err != nil {
+		return err
+	}
+	if batch.Reset {
+		store.blocks = nil
+	}
+	store.apply(batch)
+	store.blocks = append(store.blocks, batch.Blocks...)
+
+	if pruned := batch.PruneBelow - store.base; pruned > 0 {
+		headers := make([]BlockHeader, pruned)
+		for i, block := range store.blocks[:pruned] {
+			headers[i] = headerOf(block)
+		}
+		store.prune(headers)
+		// copied, so the memory of the pruned bodies is released
+		store.blocks = append([]Block(nil), store.blocks[pruned:]...)
+	}
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
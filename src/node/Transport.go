@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"solvernet/client"
+)
+
+// Transport carries the messages of a node to its peers, named by their API URL.
+// Nodes use the HTTP API of their peers; the simulator delivers in process
+type Transport interface {
+	Heartbeat(ctx context.Context, peer string) error
+	SendProblem(ctx context.Context, peer string, problem KnapsackProblem) error
+	SendProposedSolution(ctx context.Context, peer string, solution KnapsackProposedSolution) error
+	GetHead(ctx context.Context, peer string) (*ChainHead, error)
+	// messages between the validators, see Producer.go and Consensus.go
+	SendEntry(ctx context.Context, peer string, data BlockData) error
+	SendBlock(ctx context.Context, peer string, block Block) error
+	SendProposal(ctx context.Context, peer string, proposal Proposal) error
+	SendVote(ctx context.Context, peer string, vote Vote) error
+	GetBlocks(ctx context.Context, peer string, from int, limit int) ([]Block, error)
+}
+
+// HTTPTransport talks to the peers through their REST API
+type HTTPTransport struct {
+	clients map[string]*client.Client
+}
+
+func NewHTTPTransport(peerURLs []string) *HTTPTransport {
+	clients := make(map[string]*client.Client)
+	for _, peerURL := range peerURLs {
+		clients[peerURL] = client.New(peerURL)
+	}
+	return &HTTPTransport{clients: clients}
+}
+
+func (t *HTTPTransport) peer(peerURL string) *client.Client {
+	if peer, exists := t.clients[peerURL]; exists {
+		return peer
+	}
+	return client.New(peerURL)
+}
+
+func (t *HTTPTransport) Heartbeat(ctx context.Context, peer string) error {
+	return t.peer(peer).Heartbeat(ctx)
+}
+
+func (t *HTTPTransport) SendProblem(ctx context.Context, peer string, problem KnapsackProblem) error {
+	_, err := t.peer(peer).SendProblem(ctx, toClientProblem(problem))
+	return acceptPending(err)
+}
+
+func (t *HTTPTransport) SendProposedSolution(ctx context.Context, peer string, solution KnapsackProposedSolution) error {
+	_, err := t.peer(peer).SendProposedSolution(ctx, toClientProposedSolution(solution))
+	return acceptPending(err)
+}
+
+func (t *HTTPTransport) GetHead(ctx context.Context, peer string) (*ChainHead, error) {
+	clientHead, err := t.peer(peer).GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var head ChainHead
+	return &head, convertJSON(clientHead, &head)
+}
+
+func (t *HTTPTransport) SendEntry(ctx context.Context, peer string, data BlockData) error {
+	var clientData client.BlockData
+	if err := convertJSON(data, &clientData); err != nil {
+		return err
+	}
+	_, err := t.peer(peer).SendEntry(ctx, clientData)
+	return err
+}
+
+func (t *HTTPTransport) SendBlock(ctx context.Context, peer string, block Block) error {
+	var clientBlock client.Block
+	if err := convertJSON(block, &clientBlock); err != nil {
+		return err
+	}
+	return t.peer(peer).SendBlock(ctx, clientBlock)
+}
+
+func (t *HTTPTransport) SendProposal(ctx context.Context, peer string, proposal Proposal) error {
+	var clientProposal client.Proposal
+	if err := convertJSON(proposal, &clientProposal); err != nil {
+		return err
+	}
+	return t.peer(peer).SendProposal(ctx, clientProposal)
+}
+
+func (t *HTTPTransport) SendVote(ctx context.Context, peer string, vote Vote) error {
+	var clientVote client.Vote
+	if err := convertJSON(vote, &clientVote); err != nil {
+		return err
+	}
+	return t.peer(peer).SendVote(ctx, clientVote)
+}
+
+func (t *HTTPTransport) GetBlocks(ctx context.Context, peer string, from int, limit int) ([]Block, error) {
+	page, err := t.peer(peer).GetBlocks(ctx, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	return fromClientBlocks(page.Blocks)
+}
+
+// acceptPending treats a submission pending in the mempool of a validator as delivered
+func acceptPending(err error) error {
+	var pending *client.PendingError
+	if errors.As(err, &pending) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"solvernet/client"
+)
+
+// A network can be run by a known set of validators. The block of every height is then
+// proposed by one of them, drawn round-robin from the set for each round of the consensus,
+// and only added once a quorum of validators committed it (see Consensus.go): the others
+// forward the submissions they receive to the mempools of the validators (see Producer.go).
+// Proposers sign their blocks, and the blocks added carry the precommits that made them
+// final. Payout blocks are not proposed: every node makes them as it settles a problem, so
+// they are neither signed nor committed. Without a validator set, every node adds the
+// submissions it receives at its own tip
+
+// Validator is a member of the validator set
+type Validator struct {
+	Address string `json:"address"` // derived from the key the validator signs its blocks with
+	URL     string `json:"url"`     // of its API
+}
+
+// ValidatorSet is the validators of a network, in the order of the proposer schedule
+type ValidatorSet struct {
+	validators []Validator
+}
+
+// ValidatorSchedule is the validator set and the proposer of the round being decided
+type ValidatorSchedule struct {
+	Validators   []Validator `json:"validators"`
+	NextHeight   int         `json:"next_height"`
+	NextProposer Validator   `json:"next_proposer"`
+}
+
+// ParseValidators parses validators given as address@url
+func ParseValidators(entries []string) (*ValidatorSet, error) {
+	set := &ValidatorSet{validators: make([]Validator, 0, len(entries))}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		address, url, found := strings.Cut(entry, "@")
+		if !found || !isKeyAddress(address) || !isHTTPURL(url) {
+			return nil, fmt.Errorf("invalid validator %q. Expected an address derived from a public key, @ and an http(s) URL", entry)
+		}
+		if seen[address] {
+			return nil, fmt.Errorf("duplicate validator %v", address)
+		}
+		seen[address] = true
+		set.validators = append(set.validators, Validator{Address: address, URL: url})
+	}
+	return set, nil
+}
+
+// Validators returns the validators in the order of the schedule
+func (set *ValidatorSet) Validators() []Validator {
+	return append([]Validator(nil), set.validators...)
+}
+
+// Proposer returns the validator proposing the block at height in the given consensus round
+func (set *ValidatorSet) Proposer(height int, round int) Validator {
+	return set.validators[(height+round)%len(set.validators)]
+}
+
+// isScheduled tells whether a validator proposes the block at height in one of the rounds up
+// to round. A block proposed again in a later round keeps the proposer of its first round
+func (set *ValidatorSet) isScheduled(height int, round int, address string) bool {
+	for r := 0; r <= round && r < len(set.validators); r++ {
+		if set.Proposer(height, r).Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Quorum returns the number of validators whose votes decide: more than two thirds of them
+func (set *ValidatorSet) Quorum() int {
+	return len(set.validators)*2/3 + 1
+}
+
+// skipQuorum returns the number of validators that must be in a later round for a validator
+// to join them: at least one of them is then not faulty
+func (set *ValidatorSet) skipQuorum() int {
+	return len(set.validators) - set.Quorum() + 1
+}
+
+// Validator returns the validator with the given address
+func (set *ValidatorSet) Validator(address string) (Validator, bool) {
+	for _, validator := range set.validators {
+		if validator.Address == address {
+			return validator, true
+		}
+	}
+	return Validator{}, false
+}
+
+// validateProposer checks a block was signed by a validator, when the chain has a validator
+// set, and that a committed block was proposed by the proposer of a round up to the one of its
+// commit. The proposer of a block not committed yet is checked against its round by the
+// consensus. The caller must hold bc.mutex
+func (bc *Blockchain) validateProposer(block Block) error {
+	if bc.validators == nil {
+		return nil
+	}
+	if block.Data.Type == BountyPayoutSubmission {
+		if block.Proposer != "" || block.Signature != "" {
+			return fmt.Errorf("%w: payout blocks are not proposed", ErrWrongProposer)
+		}
+		return nil
+	}
+
+	if _, exists := bc.validators.Validator(block.Proposer); !exists {
+		return fmt.Errorf("%w: block %v is proposed by %q, not a validator", ErrWrongProposer, block.Height, block.Proposer)
+	}
+	if block.Commit != nil && !bc.validators.isScheduled(block.Height, block.Commit.Round, block.Proposer) {
+		return fmt.Errorf("%w: block %v is proposed by %q, not the proposer of a round up to %v", ErrWrongProposer, block.Height, block.Proposer, block.Commit.Round)
+	}
+	// the block is signed before it is committed
+	signed := block
+	signed.Commit = nil
+	if err := verifySigner(client.BlockSignature, block.Proposer, block.PublicKey, block.Signature, signed); err != nil {
+		return fmt.Errorf("%w: block %v: %v", ErrInvalidSignature, block.Height, err)
+	}
+	return nil
+}
+
+// validateCommit checks a block carries the precommits of a quorum of validators for it,
+// when the chain has a validator set. The caller must hold bc.mutex
+func (bc *Blockchain) validateCommit(block Block) error {
+	if bc.validators == nil {
+		return nil
+	}
+	if block.Data.Type == BountyPayoutSubmission {
+		if block.Commit != nil {
+			return fmt.Errorf("%w: payout blocks are not committed", ErrInvalidCommit)
+		}
+		return nil
+	}
+	if block.Commit == nil {
+		return fmt.Errorf("%w: block %v has no commit", ErrInvalidCommit, block.Height)
+	}
+
+	voters := make(map[string]bool)
+	for _, vote := range block.Commit.Votes {
+		if vote.Type != Precommit || vote.Height != block.Height || vote.Round != block.Commit.Round || vote.BlockHash != block.Hash {
+			return fmt.Errorf("%w: vote of %v is not a precommit of block %v in round %v", ErrInvalidCommit, vote.Validator, block.Height, block.Commit.Round)
+		}
+		if err := bc.validators.verifyVote(vote); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCommit, err)
+		}
+		voters[vote.Validator] = true
+	}
+	if len(voters) < bc.validators.Quorum() {
+		return fmt.Errorf("%w: block %v has %v precommits, %v are needed", ErrInvalidCommit, block.Height, len(voters), bc.validators.Quorum())
+	}
+	return nil
+}
+
+// verifyVote checks a vote was signed by a validator
+func (set *ValidatorSet) verifyVote(vote Vote) error {
+	if _, exists := set.Validator(vote.Validator); !exists {
+		return fmt.Errorf("%w: %q is not a validator", ErrInvalidVote, vote.Validator)
+	}
+	if vote.Type != Prevote && vote.Type != Precommit {
+		return fmt.Errorf("%w: unknown vote type %q", ErrInvalidVote, vote.Type)
+	}
+	if err := verifySigner(client.VoteSignature, vote.Validator, vote.PublicKey, vote.Signature, vote); err != nil {
+		return fmt.Errorf("%w: vote of %v: %v", ErrInvalidSignature, vote.Validator, err)
+	}
+	return nil
+}
+
+// verifySigner checks data was signed for the given kind with publicKey, the key of address
+func verifySigner(kind string, address string, publicKey string, signature string, data interface{}) error {
+	if err := client.VerifySignature(kind, publicKey, signature, data); err != nil {
+		return err
+	}
+	// the public key is valid hex, as the signature was verified with it
+	publicKeyBytes, _ := hex.DecodeString(publicKey)
+	if client.AddressFromPublicKey(publicKeyBytes) != address {
+		return fmt.Errorf("public key does not match %v", address)
+	}
+	return nil
+}
+
+// signBlock makes a block proposed by the key address: it sets the proposer, hashes the
+// block again, as its hash covers the proposer, and signs it
+func signBlock(block *Block, key *client.Key) error {
+	block.Proposer = key.Address
+	block.PublicKey = key.PublicKey
+	hash, err := calculateHash(*block)
+	if err != nil {
+		return err
+	}
+	block.Hash = hash
+	block.Signature, err = key.Sign(client.BlockSignature, *block)
+	return err
+}
+
+func HandleGetValidators(w http.ResponseWriter, r *http.Request, bc *Blockchain) {
+	producer, err := bc.Producer()
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, producer.Schedule())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// Optimality certificates: SolveDP against brute force, the claims VerifyCertificate accepts,
+// and the DP tables dry runs solve
+
+func TestSolveDPFindsTheOptimum(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		problem := KnapsackProblem{Capacity: 10 + random.Intn(50)}
+		for j := 0; j < 4+random.Intn(8); j++ {
+			problem.Items = append(problem.Items, Item{Weight: 1 + random.Intn(20), Value: 1 + random.Intn(20)})
+		}
+		optimum, _ := SolveDP(problem)
+		expected := GetTotalSolutionValue(problem, KnapsackProposedSolution{ItemIndexes: optimalItems(problem)})
+		if optimum != expected {
+			t.Errorf("SolveDP finds %v for %+v, brute force %v", optimum, problem, expected)
+		}
+		if bound := DantzigBound(problem); bound < optimum {
+			t.Errorf("the Dantzig bound %v is below the optimum %v of %+v", bound, optimum, problem)
+		}
+	}
+}
+
+func TestDantzigBound(t *testing.T) {
+	// the two best ratios fit, and two thirds of the third item fill the rest
+	problem := KnapsackProblem{Capacity: 50, Items: []Item{{Weight: 10, Value: 60}, {Weight: 20, Value: 100}, {Weight: 30, Value: 120}}}
+	if bound := DantzigBound(problem); bound != 240 {
+		t.Errorf("the Dantzig bound is %v, not 240", bound)
+	}
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	problem := testProblem("poster", 30, "")
+	optimum, digest := SolveDP(problem)
+	other, otherDigest := SolveDP(KnapsackProblem{Capacity: problem.Capacity - 1, Items: problem.Items})
+	if otherDigest == digest {
+		t.Fatalf("the digests of capacities %v and %v are the same", problem.Capacity, problem.Capacity-1)
+	}
+	large := testProblem("poster", 30, "")
+	large.Capacity = MAX_DP_CERTIFICATE_CELLS
+
+	tests := []struct {
+		name        string
+		problem     KnapsackProblem
+		certificate OptimalityCertificate
+		valid       bool
+	}{
+		{"dp", problem, OptimalityCertificate{Type: DPCertificate, UpperBound: optimum, Digest: digest}, true},
+		{"dp with a wrong bound", problem, OptimalityCertificate{Type: DPCertificate, UpperBound: optimum + 1, Digest: digest}, false},
+		{"dp of another table", problem, OptimalityCertificate{Type: DPCertificate, UpperBound: other, Digest: otherDigest}, false},
+		{"dp of a table too large", large, OptimalityCertificate{Type: DPCertificate, UpperBound: optimum, Digest: digest}, false},
+		{"dantzig", problem, OptimalityCertificate{Type: DantzigCertificate, UpperBound: DantzigBound(problem)}, true},
+		{"dantzig with a wrong bound", problem, OptimalityCertificate{Type: DantzigCertificate, UpperBound: DantzigBound(problem) - 1}, false},
+		{"unknown type", problem, OptimalityCertificate{Type: "lp", UpperBound: optimum}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyCertificate(test.problem, test.certificate)
+			if test.valid && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidCertificate) {
+				t.Errorf("expected %v, got %v", ErrInvalidCertificate, err)
+			}
+		})
+	}
+}
+
+func TestDryRunsBoundTheDPTablesSolved(t *testing.T) {
+	clock := NewSimClock(simStart)
+	bc, ledger := newTestChain(t, clock)
+	// a table of 20 items and 100,001 capacities, larger than dry runs solve
+	problem := KnapsackProblem{Capacity: 100_000, Bounty: 30, Address: "poster"}
+	for i := 0; i < 20; i++ {
+		weight := 5_000 + 500*i
+		problem.Items = append(problem.Items, Item{Weight: weight, Value: weight + i%3})
+	}
+	if cells := dpCells(problem); cells <= MAX_DRY_RUN_CERTIFICATE_CELLS || cells > MAX_DP_CERTIFICATE_CELLS {
+		t.Fatalf("the table has %v cells", cells)
+	}
+	height := addTestProblem(t, bc, ledger, clock, problem)
+
+	optimum, digest := SolveDP(problem)
+	solution := testSolution(problem, height, "alice", 0, 1)
+	solution.Certificate = &OptimalityCertificate{Type: DPCertificate, UpperBound: optimum, Digest: digest}
+	clock.Sleep(time.Second)
+
+	validation := bc.DryRunProposedSolution(solution)
+	if validation.Valid || validation.Error == nil || validation.Error.Code != "certificate_unchecked" {
+		t.Fatalf("the dry run returns %+v, %+v", validation, validation.Error)
+	}
+	// checking the submission solves the table, which dry runs then find
+	if err := bc.checkEntry(BlockData{Type: KnapsackProposedSolutionSubmission, Solution: &solution}, ledger, NewMempool()); err != nil {
+		t.Fatal(err)
+	}
+	if validation := bc.DryRunProposedSolution(solution); !validation.Valid {
+		t.Errorf("the dry run returns %+v once the table is solved", validation.Error)
+	}
+
+	wrong := solution
+	wrong.Certificate = &OptimalityCertificate{Type: DPCertificate, UpperBound: optimum, Digest: digest[1:] + "0"}
+	if validation := bc.DryRunProposedSolution(wrong); validation.Valid || validation.Error.Code != "invalid_certificate" {
+		t.Errorf("the dry run of a wrong certificate returns %+v", validation.Error)
+	}
+}
@@ -0,0 +1,430 @@
+// Package client is a typed Go client for the node API described in openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// APIError is an error response returned by a node
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%v (%v): %v", e.Code, e.StatusCode, e.Message)
+}
+
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// New creates a client for the node at baseURL, e.g. http://localhost:3001
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) error {
+	_, err := c.doStatus(ctx, method, path, query, body, result)
+	return err
+}
+
+// doStatus is do, also returning the status of a successful response
+func (c *Client) doStatus(ctx context.Context, method string, path string, query url.Values, body interface{}, result interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, decodeError(resp)
+	}
+	if result == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(result)
+}
+
+func decodeError(resp *http.Response) error {
+	var errorResponse ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil || errorResponse.Error.Code == "" {
+		return &APIError{StatusCode: resp.StatusCode, Code: "unknown", Message: resp.Status}
+	}
+	return &APIError{StatusCode: resp.StatusCode, Code: errorResponse.Error.Code, Message: errorResponse.Error.Message}
+}
+
+func pageQuery(startName string, start int, limit int) url.Values {
+	query := url.Values{}
+	query.Set(startName, strconv.Itoa(start))
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	return query
+}
+
+// Heartbeat checks the node is online
+func (c *Client) Heartbeat(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/api/heartbeat", nil, nil, nil)
+}
+
+func (c *Client) GetBlockchain(ctx context.Context) ([]Block, error) {
+	var blocks []Block
+	err := c.do(ctx, http.MethodGet, "/api/get_blockchain", nil, nil, &blocks)
+	return blocks, err
+}
+
+func (c *Client) GetHead(ctx context.Context) (*ChainHead, error) {
+	var head ChainHead
+	err := c.do(ctx, http.MethodGet, "/api/head", nil, nil, &head)
+	return &head, err
+}
+
+// GetBlocks returns up to limit blocks starting at height from. A zero limit uses the node default
+func (c *Client) GetBlocks(ctx context.Context, from int, limit int) (*BlockPage, error) {
+	var page BlockPage
+	err := c.do(ctx, http.MethodGet, "/api/blocks", pageQuery("from", from, limit), nil, &page)
+	return &page, err
+}
+
+// GetHeaders returns up to limit block headers starting at height from. A zero limit uses the node default
+func (c *Client) GetHeaders(ctx context.Context, from int, limit int) (*HeaderPage, error) {
+	var page HeaderPage
+	err := c.do(ctx, http.MethodGet, "/api/headers", pageQuery("from", from, limit), nil, &page)
+	return &page, err
+}
+
+func (c *Client) GetBlockByHeight(ctx context.Context, height int) (*Block, error) {
+	var block Block
+	err := c.do(ctx, http.MethodGet, "/api/blocks/"+strconv.Itoa(height), nil, nil, &block)
+	return &block, err
+}
+
+func (c *Client) GetBlockByHash(ctx context.Context, hash string) (*Block, error) {
+	var block Block
+	err := c.do(ctx, http.MethodGet, "/api/blocks/hash/"+url.PathEscape(hash), nil, nil, &block)
+	return &block, err
+}
+
+func (c *Client) GetLedger(ctx context.Context) (*Ledger, error) {
+	var ledger Ledger
+	err := c.do(ctx, http.MethodGet, "/api/get_ledger", nil, nil, &ledger)
+	return &ledger, err
+}
+
+// GetAccount returns the state of an address and up to limit history entries, skipping the offset most recent ones
+func (c *Client) GetAccount(ctx context.Context, address string, offset int, limit int) (*Account, error) {
+	var account Account
+	err := c.do(ctx, http.MethodGet, "/api/accounts/"+url.PathEscape(address), pageQuery("offset", offset, limit), nil, &account)
+	return &account, err
+}
+
+// GetSnapshot returns the latest state snapshot of the chain
+func (c *Client) GetSnapshot(ctx context.Context) (*StateSnapshot, error) {
+	var snapshot StateSnapshot
+	err := c.do(ctx, http.MethodGet, "/api/snapshot", nil, nil, &snapshot)
+	return &snapshot, err
+}
+
+func (c *Client) GetOpenProblems(ctx context.Context) ([]ProblemSummary, error) {
+	var problems []ProblemSummary
+	err := c.do(ctx, http.MethodGet, "/api/problems", nil, nil, &problems)
+	return problems, err
+}
+
+func (c *Client) GetProblem(ctx context.Context, height int) (*ProblemHistory, error) {
+	var history ProblemHistory
+	err := c.do(ctx, http.MethodGet, "/api/problems/"+strconv.Itoa(height), nil, nil, &history)
+	return &history, err
+}
+
+func (c *Client) ValidateProblem(ctx context.Context, problem KnapsackProblem) (*ProblemValidation, error) {
+	var validation ProblemValidation
+	err := c.do(ctx, http.MethodPost, "/api/validate/problem", nil, problem, &validation)
+	return &validation, err
+}
+
+func (c *Client) ValidateSolution(ctx context.Context, solution KnapsackProposedSolution) (*SolutionValidation, error) {
+	var validation SolutionValidation
+	err := c.do(ctx, http.MethodPost, "/api/validate/solution", nil, solution, &validation)
+	return &validation, err
+}
+
+// PendingError is returned for a submission a node added to its mempool, but to no block yet.
+// It is added when its proposer gets it
+type PendingError struct {
+	Entry MempoolEntry
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("submission %v is pending in the mempool", e.Entry.ID)
+}
+
+// submit sends a submission and returns the block holding it, or a PendingError
+func (c *Client) submit(ctx context.Context, path string, submission interface{}) (*Block, error) {
+	var response json.RawMessage
+	status, err := c.doStatus(ctx, http.MethodPost, path, nil, submission, &response)
+	if err != nil {
+		return &Block{}, err
+	}
+	if status == http.StatusAccepted {
+		var entry MempoolEntry
+		if err := json.Unmarshal(response, &entry); err != nil {
+			return &Block{}, err
+		}
+		return &Block{}, &PendingError{Entry: entry}
+	}
+	var block Block
+	err = json.Unmarshal(response, &block)
+	return &block, err
+}
+
+func (c *Client) SendProblem(ctx context.Context, problem KnapsackProblem) (*Block, error) {
+	return c.submit(ctx, "/api/send_problem", problem)
+}
+
+func (c *Client) SendProposedSolution(ctx context.Context, solution KnapsackProposedSolution) (*Block, error) {
+	return c.submit(ctx, "/api/send_proposed_solution", solution)
+}
+
+// SendTransaction sends a signed transfer, see Key.SignTransaction
+func (c *Client) SendTransaction(ctx context.Context, tx Transaction) (*Block, error) {
+	return c.submit(ctx, "/api/send_transaction", tx)
+}
+
+// SendBlock sends a block committed by the validators
+func (c *Client) SendBlock(ctx context.Context, block Block) error {
+	return c.do(ctx, http.MethodPost, "/api/blocks", nil, block, nil)
+}
+
+// SendEntry adds a submission to the mempool of the node, to be added by the next proposer
+func (c *Client) SendEntry(ctx context.Context, data BlockData) (*MempoolEntry, error) {
+	var entry MempoolEntry
+	err := c.do(ctx, http.MethodPost, "/api/mempool", nil, data, &entry)
+	return &entry, err
+}
+
+// GetMempool returns the submissions waiting for a block, oldest first
+func (c *Client) GetMempool(ctx context.Context) ([]MempoolEntry, error) {
+	var entries []MempoolEntry
+	err := c.do(ctx, http.MethodGet, "/api/mempool", nil, nil, &entries)
+	return entries, err
+}
+
+// SendProposal sends the block proposed by the proposer of a consensus round
+func (c *Client) SendProposal(ctx context.Context, proposal Proposal) error {
+	return c.do(ctx, http.MethodPost, "/api/consensus/proposals", nil, proposal, nil)
+}
+
+// SendVote sends the prevote or precommit of a validator
+func (c *Client) SendVote(ctx context.Context, vote Vote) error {
+	return c.do(ctx, http.MethodPost, "/api/consensus/votes", nil, vote, nil)
+}
+
+// GetConsensus returns the height and round being decided, and the finalized height
+func (c *Client) GetConsensus(ctx context.Context) (*ConsensusState, error) {
+	var state ConsensusState
+	err := c.do(ctx, http.MethodGet, "/api/consensus", nil, nil, &state)
+	return &state, err
+}
+
+// GetValidators returns the validator set and the proposer of the round being decided
+func (c *Client) GetValidators(ctx context.Context) (*ValidatorSchedule, error) {
+	var schedule ValidatorSchedule
+	err := c.do(ctx, http.MethodGet, "/api/validators", nil, nil, &schedule)
+	return &schedule, err
+}
+
+// ExportChain writes the chain archive of the node to w, gzip compressed if asked
+func (c *Client) ExportChain(ctx context.Context, w io.Writer, compress bool) error {
+	target := c.BaseURL + "/api/export"
+	if compress {
+		target += "?gzip=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportChain sends a chain archive, compressed or not, to be added to the chain of the node
+func (c *Client) ImportChain(ctx context.Context, archive io.Reader) (*ImportResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/api/import", archive)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.transferClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, decodeError(resp)
+	}
+	var result ImportResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	return &result, err
+}
+
+// transferClient is the HTTP client of the archive transfers, which take as long as the chain is big
+func (c *Client) transferClient() *http.Client {
+	return &http.Client{Transport: c.HTTPClient.Transport}
+}
+
+// Call sends a single JSON-RPC request to /rpc and decodes its result into result.
+// Errors of the call are returned as *RPCError
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	request := RPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: json.RawMessage("1")}
+	var response RPCResponse
+	if err := c.do(ctx, http.MethodPost, "/rpc", nil, request, &response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// StreamEvents subscribes to the node events and calls handle for each one until
+// ctx is done, the stream ends or handle returns an error.
+// Empty types and address receive every event
+func (c *Client) StreamEvents(ctx context.Context, types []string, address string, handle func(Event) error) error {
+	stream, err := c.SubscribeEvents(ctx, types, address)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		event, err := stream.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+}
+
+// EventStream is a subscription to the node events
+type EventStream struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// SubscribeEvents subscribes to the node events. It returns once the node subscribed, so no
+// event happening after it returns is missed. Empty types and address receive every event
+func (c *Client) SubscribeEvents(ctx context.Context, types []string, address string) (*EventStream, error) {
+	query := url.Values{}
+	if len(types) > 0 {
+		query.Set("types", strings.Join(types, ","))
+	}
+	if address != "" {
+		query.Set("address", address)
+	}
+	target := c.BaseURL + "/api/events"
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the stream is long lived, so the default timeout does not apply
+	resp, err := (&http.Client{Transport: c.HTTPClient.Transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &EventStream{ctx: ctx, body: resp.Body, scanner: scanner}, nil
+}
+
+// Next waits for the next event. It returns io.EOF when the stream ends, and the error of
+// the context when it is done
+func (stream *EventStream) Next() (Event, error) {
+	for stream.scanner.Scan() {
+		line := stream.scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+			return Event{}, err
+		}
+		return event, nil
+	}
+	if stream.ctx.Err() != nil {
+		return Event{}, stream.ctx.Err()
+	}
+	if err := stream.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+func (stream *EventStream) Close() error {
+	return stream.body.Close()
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Kinds of signed submissions. The kind is part of the signed payload,
+// so a signature is only valid for the kind of submission it was made for
+const (
+	ProblemSignature     = "solvernet/problem"
+	SolutionSignature    = "solvernet/proposed_solution"
+	TransactionSignature = "solvernet/transaction"
+	BlockSignature       = "solvernet/block"
+	ProposalSignature    = "solvernet/proposal"
+	VoteSignature        = "solvernet/vote"
+)
+
+// Key is an ed25519 key pair and the address derived from it
+type Key struct {
+	Address    string `json:"address"`
+	PublicKey  string `json:"public_key"`  // hex
+	PrivateKey string `json:"private_key"` // hex
+}
+
+func GenerateKey() (*Key, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	return KeyFromSeed(seed), nil
+}
+
+// KeyFromSeed returns the key pair derived from a seed of ed25519.SeedSize bytes
+func KeyFromSeed(seed []byte) *Key {
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	return &Key{
+		Address:    AddressFromPublicKey(publicKey),
+		PublicKey:  hex.EncodeToString(publicKey),
+		PrivateKey: hex.EncodeToString(privateKey),
+	}
+}
+
+// AddressFromPublicKey returns "0x" followed by the first 20 bytes of the SHA-256 of the public key, in hex
+func AddressFromPublicKey(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	return "0x" + hex.EncodeToString(hash[:20])
+}
+
+// LoadKey reads a key file written by Save
+func LoadKey(path string) (*Key, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key Key
+	if err := json.Unmarshal(bytes, &key); err != nil {
+		return nil, fmt.Errorf("invalid key file %v: %w", path, err)
+	}
+	privateKey, err := key.privateKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %v: %w", path, err)
+	}
+	if AddressFromPublicKey(privateKey.Public().(ed25519.PublicKey)) != key.Address {
+		return nil, fmt.Errorf("invalid key file %v: address does not match the key", path)
+	}
+	return &key, nil
+}
+
+// Save writes the key to path, readable by its owner only
+func (key *Key) Save(path string) error {
+	bytes, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(bytes, '\n'), 0600)
+}
+
+func (key *Key) privateKey() (ed25519.PrivateKey, error) {
+	privateKey, err := hex.DecodeString(key.PrivateKey)
+	if err != nil || len(privateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key")
+	}
+	return ed25519.PrivateKey(privateKey), nil
+}
+
+// SigningPayload returns the bytes signed for a submission: its kind, a newline and
+// the JSON of data without its signature, with sorted keys. Nodes rebuild it from
+// their own types, so it does not depend on the field order of the Go structs
+func SigningPayload(kind string, data interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "signature")
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(kind+"\n"), canonical...), nil
+}
+
+// Sign returns the hex signature of data for the given kind of submission
+func (key *Key) Sign(kind string, data interface{}) (string, error) {
+	privateKey, err := key.privateKey()
+	if err != nil {
+		return "", err
+	}
+	payload, err := SigningPayload(kind, data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ed25519.Sign(privateKey, payload)), nil
+}
+
+// VerifySignature checks signature was made by publicKey over data, for the given kind of submission
+func VerifySignature(kind string, publicKey string, signature string, data interface{}) error {
+	publicKeyBytes, err := hex.DecodeString(publicKey)
+	if err != nil || len(publicKeyBytes) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	signatureBytes, err := hex.DecodeString(signature)
+	if err != nil || len(signatureBytes) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	payload, err := SigningPayload(kind, data)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKeyBytes, payload, signatureBytes) {
+		return errors.New("signature does not match")
+	}
+	return nil
+}
+
+// SignProblem sets the public key and signature of a problem sent from the key address
+func (key *Key) SignProblem(problem *KnapsackProblem) error {
+	problem.Address = key.Address
+	problem.PublicKey = key.PublicKey
+	signature, err := key.Sign(ProblemSignature, problem)
+	problem.Signature = signature
+	return err
+}
+
+// SignProposedSolution sets the public key and signature of a proposed solution rewarding the key address
+func (key *Key) SignProposedSolution(solution *KnapsackProposedSolution) error {
+	solution.Address = key.Address
+	solution.PublicKey = key.PublicKey
+	signature, err := key.Sign(SolutionSignature, solution)
+	solution.Signature = signature
+	return err
+}
+
+// SignTransaction sets the public key and signature of a transfer sent from the key address
+func (key *Key) SignTransaction(tx *Transaction) error {
+	tx.From = key.Address
+	tx.PublicKey = key.PublicKey
+	signature, err := key.Sign(TransactionSignature, tx)
+	tx.Signature = signature
+	return err
+}
@@ -0,0 +1,332 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Wire types of the node API. They follow the schemas of openapi.json,
+// which the node checks against its own types at startup
+
+type BlockDataType int
+
+const (
+	MonetaryTransaction BlockDataType = iota
+	KnapsackProblemSubmission
+	KnapsackProposedSolutionSubmission
+	BountyPayoutSubmission
+)
+
+type Item struct {
+	Weight int `json:"weight"`
+	Value  int `json:"value"`
+}
+
+type KnapsackProblem struct {
+	Items         []Item  `json:"items"`
+	Capacity      int     `json:"capacity"`
+	Bounty        float64 `json:"bounty"`
+	Address       string  `json:"address"`
+	PayoutPolicy  string  `json:"payout_policy,omitempty"`
+	ExpirySeconds int     `json:"expiry_seconds,omitempty"`
+	Nonce         int     `json:"nonce,omitempty"`
+	PublicKey     string  `json:"public_key,omitempty"`
+	Signature     string  `json:"signature,omitempty"`
+}
+
+type OptimalityCertificate struct {
+	Type       string `json:"type"`
+	UpperBound int    `json:"upper_bound"`
+	Digest     string `json:"digest,omitempty"`
+}
+
+type KnapsackProposedSolution struct {
+	ItemIndexes        []int                  `json:"items"`
+	ProblemBlockHeight int                    `json:"problem_block_height"`
+	Value              int                    `json:"value"`
+	Address            string                 `json:"address"`
+	Certificate        *OptimalityCertificate `json:"certificate,omitempty"`
+	Nonce              int                    `json:"nonce,omitempty"`
+	PublicKey          string                 `json:"public_key,omitempty"`
+	Signature          string                 `json:"signature,omitempty"`
+}
+
+type Transaction struct {
+	From               string  `json:"from"`
+	To                 string  `json:"to"`
+	Amount             float64 `json:"amount"`
+	ProblemBlockHeight int     `json:"problem_block_height"`
+	Nonce              int     `json:"nonce,omitempty"`
+	PublicKey          string  `json:"public_key,omitempty"`
+	Signature          string  `json:"signature,omitempty"`
+}
+
+type BountyPayout struct {
+	ProblemBlockHeight int           `json:"problem_block_height"`
+	Policy             string        `json:"policy"`
+	Transactions       []Transaction `json:"transactions"`
+	Early              bool          `json:"early,omitempty"`
+}
+
+type BlockData struct {
+	Type        BlockDataType             `json:"type"`
+	Transaction *Transaction              `json:"transaction,omitempty"`
+	Problem     *KnapsackProblem          `json:"problem,omitempty"`
+	Solution    *KnapsackProposedSolution `json:"proposed_solution,omitempty"`
+	Payout      *BountyPayout             `json:"payout,omitempty"`
+}
+
+type Block struct {
+	Height    int       `json:"height"`
+	Data      BlockData `json:"data"`
+	Hash      string    `json:"hash"`
+	PrevHash  string    `json:"prevhash"`
+	StateRoot string    `json:"state_root,omitempty"`
+	Timestamp int64     `json:"timestamp,omitempty"`
+	Proposer  string    `json:"proposer,omitempty"`
+	PublicKey string    `json:"public_key,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+	Commit    *Commit   `json:"commit,omitempty"`
+}
+
+type Vote struct {
+	Type      string `json:"type"`
+	Height    int    `json:"height"`
+	Round     int    `json:"round"`
+	BlockHash string `json:"block_hash,omitempty"`
+	Validator string `json:"validator"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+type Commit struct {
+	Round int    `json:"round"`
+	Votes []Vote `json:"votes"`
+}
+
+type Proposal struct {
+	Block      Block  `json:"block"`
+	Round      int    `json:"round"`
+	ValidRound int    `json:"valid_round"`
+	Prevotes   []Vote `json:"prevotes,omitempty"`
+	Proposer   string `json:"proposer"`
+	PublicKey  string `json:"public_key"`
+	Signature  string `json:"signature"`
+}
+
+type ConsensusState struct {
+	Height          int       `json:"height"`
+	Round           int       `json:"round"`
+	Step            string    `json:"step"`
+	Proposer        Validator `json:"proposer"`
+	LockedRound     int       `json:"locked_round"`
+	ValidRound      int       `json:"valid_round"`
+	Prevotes        int       `json:"prevotes"`
+	Precommits      int       `json:"precommits"`
+	FinalizedHeight int       `json:"finalized_height"`
+}
+
+type MempoolEntry struct {
+	ID         string    `json:"id"`
+	Data       BlockData `json:"data"`
+	ReceivedAt int64     `json:"received_at"`
+}
+
+// PendingSubmission is the result of the JSON-RPC submit methods when no block holds the
+// submission yet
+type PendingSubmission struct {
+	Pending bool         `json:"pending"`
+	Entry   MempoolEntry `json:"entry"`
+}
+
+type Validator struct {
+	Address string `json:"address"`
+	URL     string `json:"url"`
+}
+
+type ValidatorSchedule struct {
+	Validators   []Validator `json:"validators"`
+	NextHeight   int         `json:"next_height"`
+	NextProposer Validator   `json:"next_proposer"`
+}
+
+type ChainHead struct {
+	Height    int     `json:"height"`
+	Hash      string  `json:"hash"`
+	PrevHash  string  `json:"prevhash"`
+	Timestamp int64   `json:"timestamp,omitempty"`
+	Work      float64 `json:"work"`
+	// with a validator set, the height up to which blocks are final
+	FinalizedHeight *int `json:"finalized_height,omitempty"`
+}
+
+type BlockPage struct {
+	Blocks []Block    `json:"blocks"`
+	From   int        `json:"from"`
+	Limit  int        `json:"limit"`
+	Next   *int       `json:"next,omitempty"`
+	Head   *ChainHead `json:"head"`
+}
+
+type BlockHeader struct {
+	Height    int           `json:"height"`
+	Hash      string        `json:"hash"`
+	PrevHash  string        `json:"prevhash"`
+	StateRoot string        `json:"state_root,omitempty"`
+	Timestamp int64         `json:"timestamp,omitempty"`
+	Type      BlockDataType `json:"type"`
+	Addresses []string      `json:"addresses"`
+}
+
+type HeaderPage struct {
+	Headers []BlockHeader `json:"headers"`
+	From    int           `json:"from"`
+	Limit   int           `json:"limit"`
+	Next    *int          `json:"next,omitempty"`
+	Head    *ChainHead    `json:"head"`
+}
+
+type Ledger struct {
+	AddressToBalance map[string]float64 `json:"address_to_balance"`
+}
+
+type Account struct {
+	Address            string  `json:"address"`
+	Balance            float64 `json:"balance"`
+	Nonce              int     `json:"nonce"`
+	PendingNonce       int     `json:"pending_nonce"`
+	EscrowedBounty     float64 `json:"escrowed_bounty"`
+	ProblemsPosted     []int   `json:"problems_posted"`
+	SolutionsSubmitted []int   `json:"solutions_submitted"`
+	History            []Block `json:"history"`
+	HistoryTotal       int     `json:"history_total"`
+	NextOffset         *int    `json:"next_offset,omitempty"`
+}
+
+type ProblemSummary struct {
+	ProblemBlockHeight int             `json:"problem_block_height"`
+	Problem            KnapsackProblem `json:"problem"`
+	SubmittedAt        int64           `json:"submitted_at,omitempty"`
+	ExpiryHeight       int             `json:"expiry_height"`
+	ExpiresAt          int64           `json:"expires_at,omitempty"`
+	RemainingBlocks    int             `json:"remaining_blocks"`
+	BestValue          int             `json:"best_value"`
+	Leader             string          `json:"leader,omitempty"`
+}
+
+type ProblemSubmission struct {
+	BlockHeight int                      `json:"block_height"`
+	Solution    KnapsackProposedSolution `json:"solution"`
+}
+
+type ProblemHistory struct {
+	ProblemBlockHeight int                 `json:"problem_block_height"`
+	Problem            KnapsackProblem     `json:"problem"`
+	Status             string              `json:"status"`
+	SubmittedAt        int64               `json:"submitted_at,omitempty"`
+	ExpiryHeight       int                 `json:"expiry_height"`
+	ExpiresAt          int64               `json:"expires_at,omitempty"`
+	Submissions        []ProblemSubmission `json:"submissions"`
+	Leader             *ProblemSubmission  `json:"leader,omitempty"`
+	PayoutBlockHeight  *int                `json:"payout_block_height,omitempty"`
+	Payout             *BountyPayout       `json:"payout,omitempty"`
+}
+
+type Hardness struct {
+	Items         int     `json:"items"`
+	CapacityRatio float64 `json:"capacity_ratio"`
+	Correlation   float64 `json:"correlation"`
+	Score         float64 `json:"score"`
+}
+
+type ProblemValidation struct {
+	Valid       bool       `json:"valid"`
+	Error       *ErrorBody `json:"error,omitempty"`
+	ItemCount   int        `json:"item_count"`
+	TotalWeight int        `json:"total_weight"`
+	Hardness    Hardness   `json:"hardness"`
+	MinBounty   float64    `json:"min_bounty"`
+	Height      int        `json:"height"`
+}
+
+type SolutionValidation struct {
+	Valid            bool       `json:"valid"`
+	Error            *ErrorBody `json:"error,omitempty"`
+	Weight           int        `json:"weight"`
+	Value            int        `json:"value"`
+	Capacity         int        `json:"capacity"`
+	CurrentBestValue int        `json:"current_best_value"`
+	Height           int        `json:"height"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// Event is a blockchain event. Data is left raw, its type depends on the event type
+type Event struct {
+	Type               string          `json:"type"`
+	BlockHeight        int             `json:"block_height"`
+	ProblemBlockHeight *int            `json:"problem_block_height,omitempty"`
+	Addresses          []string        `json:"addresses"`
+	Data               json.RawMessage `json:"data,omitempty"`
+}
+
+// JSON-RPC 2.0 envelopes of the /rpc endpoint
+
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type RPCError struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorBody `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %v: %v", e.Code, e.Message)
+}
+
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type RPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  Event  `json:"params"`
+}
+
+type ImportResult struct {
+	Imported int    `json:"imported"`
+	Skipped  int    `json:"skipped"`
+	Height   int    `json:"height"`
+	TipHash  string `json:"tip_hash"`
+}
+
+type ChainState struct {
+	Balances     map[string]float64 `json:"balances"`
+	Nonces       map[string]int     `json:"nonces"`
+	OpenProblems []ProblemSummary   `json:"open_problems"`
+	Escrow       map[string]float64 `json:"escrow"`
+}
+
+type StateSnapshot struct {
+	Height    int        `json:"height"`
+	BlockHash string     `json:"block_hash"`
+	StateRoot string     `json:"state_root"`
+	State     ChainState `json:"state"`
+	Blocks    []Block    `json:"blocks"`
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Fork choice: the work chains do, and the switches of Reorganize to branches doing more work,
+// with the balances and submissions they lead to
+
+// addSolvedProblem adds a problem of poster solved by solver, and blocks up to its payout
+func addSolvedProblem(t *testing.T, bc *Blockchain, ledger *Ledger, clock *SimClock, poster string, solver string) int {
+	t.Helper()
+	problem := testProblem(poster, 30, WinnerTakesAllPayout)
+	height := addTestProblem(t, bc, ledger, clock, problem)
+	addTestSolution(t, bc, ledger, clock, testSolution(problem, height, solver, 0, 1, 2))
+	for bc.GetHead().Height <= problemExpiryHeight(height) {
+		addTestProblem(t, bc, ledger, clock, testProblem("filler", 30+float64(bc.GetHead().Height), WinnerTakesAllPayout))
+	}
+	payoutOf(t, bc, height)
+	return height
+}
+
+func TestWorkCountsPaidOutSolutions(t *testing.T) {
+	clock := NewSimClock(simStart)
+	bc, ledger := newTestChain(t, clock)
+	addSolvedProblem(t, bc, ledger, clock, "poster", "alice")
+	problem := testProblem("poster", 30, WinnerTakesAllPayout)
+	hardness := EstimateHardness(problem).Score
+	expected := float64(GetTotalSolutionValue(problem, testSolution(problem, 0, "", 0, 1, 2))) / float64(DantzigBound(problem)) * hardness
+	if work := bc.GetHead().Work; work < expected-1e-9 || work > expected+1e-9 {
+		t.Errorf("the chain does %v work, not %v", work, expected)
+	}
+
+	// the bounty of a problem solved by its poster is paid back to it
+	before := bc.GetHead().Work
+	addSolvedProblem(t, bc, ledger, clock, "poster", "poster")
+	if work := bc.GetHead().Work; work != before {
+		t.Errorf("a problem solved by its poster adds %v work", work-before)
+	}
+}
+
+func TestWorkIsBoundedByTime(t *testing.T) {
+	index := NewWorkIndex()
+	problem := testProblem("poster", 30, WinnerTakesAllPayout)
+	solution := testSolution(problem, 1, "alice", optimalItems(problem)...)
+	payout := Block{Data: BlockData{Type: BountyPayoutSubmission, Payout: &BountyPayout{
+		ProblemBlockHeight: 1,
+		Transactions:       []Transaction{{From: "poster", To: "alice", Amount: 30}},
+	}}}
+
+	// the optimum gains most of the hardness score of the problem, but blocks a tenth of a
+	// second apart only gain one unit of work
+	start := simStart.UnixMilli()
+	index.add(Block{Height: 1, Timestamp: start}, nil)
+	index.add(Block{Height: 2, Timestamp: start + 50, Data: BlockData{Type: KnapsackProposedSolutionSubmission, Solution: &solution}}, &problem)
+	payout.Height, payout.Timestamp = 3, start+100
+	index.add(payout, nil)
+	if limit := 0.1 * MAX_WORK_PER_SECOND; index.total < limit-1e-9 || index.total > limit+1e-9 {
+		t.Errorf("blocks 0.1s apart gain %v work, not %v", index.total, limit)
+	}
+}
+
+func TestReorganize(t *testing.T) {
+	clock := NewSimClock(simStart)
+	bc, ledger := newTestChain(t, clock)
+	other, otherLedger := newTestChain(t, clock)
+
+	// the chain holds a problem nobody solves, the other chain a problem paid out to alice
+	carol := testProblem("carol", 40, WinnerTakesAllPayout)
+	addTestProblem(t, bc, ledger, clock, carol)
+	addTestProblem(t, bc, ledger, clock, testProblem("dave", 50, WinnerTakesAllPayout))
+	addSolvedProblem(t, other, otherLedger, clock, "poster", "alice")
+	addTestProblem(t, other, otherLedger, clock, testProblem("dave", 50, WinnerTakesAllPayout))
+	branch := other.GetAllBlocks()[1:]
+
+	// the chain does no work, so it does not replace the other one
+	switched, head, err := other.Reorganize(bc.GetAllBlocks()[1:], otherLedger)
+	if err != nil || switched || head.Work != 0 {
+		t.Fatalf("the other chain switched %v to a chain doing %+v work: %v", switched, head, err)
+	}
+
+	switched, head, err = bc.Reorganize(branch, ledger)
+	if err != nil || !switched {
+		t.Fatalf("the chain did not switch: %v", err)
+	}
+	if expected := other.GetHead(); head.Hash != expected.Hash || head.Work != expected.Work || head.Work == 0 {
+		t.Errorf("the branch leads to %+v, not %+v", head, expected)
+	}
+	for _, block := range branch {
+		if held, exists := bc.GetBlockByHeight(block.Height); !exists || held.Hash != block.Hash {
+			t.Fatalf("block %v of the branch is not held", block.Height)
+		}
+	}
+	for address, balance := range otherLedger.Balances() {
+		checkBalance(t, ledger, address, balance)
+	}
+	checkBalance(t, ledger, "alice", ADDRESS_INITIAL_BALANCE+30)
+	checkBalance(t, ledger, "poster", ADDRESS_INITIAL_BALANCE-30)
+
+	// the problem of carol is added again at the tip, the one of dave is held by the branch
+	added := bc.GetAllBlocks()[len(branch)+1:]
+	if len(added) != 1 || added[0].Data.Type != KnapsackProblemSubmission || added[0].Data.Problem.Address != "carol" {
+		t.Errorf("%v blocks are added again after the branch: %+v", len(added), added)
+	}
+	if _, err := ReplayBlocks(bc.GetAllBlocks(), NewLedger()); err != nil {
+		t.Errorf("the chain does not replay: %v", err)
+	}
+}
+
+func TestReorganizeRejectsAnInvalidBranch(t *testing.T) {
+	clock := NewSimClock(simStart)
+	bc, ledger := newTestChain(t, clock)
+	other, otherLedger := newTestChain(t, clock)
+	addSolvedProblem(t, other, otherLedger, clock, "poster", "alice")
+	tip := bc.GetHead()
+
+	// a solution claiming more than its items are worth, hashed again so that it is chained
+	branch := other.GetAllBlocks()[1:]
+	solution := *branch[1].Data.Solution
+	solution.Value++
+	branch[1].Data.Solution = &solution
+	for i := 1; i < len(branch); i++ {
+		branch[i].PrevHash = branch[i-1].Hash
+		hash, err := calculateHash(branch[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		branch[i].Hash = hash
+	}
+
+	switched, _, err := bc.Reorganize(branch, ledger)
+	if switched || !errors.Is(err, ErrInvalidBranch) {
+		t.Fatalf("switched %v to the invalid branch: %v", switched, err)
+	}
+	if head := bc.GetHead(); head.Hash != tip.Hash {
+		t.Errorf("the chain moved to %v", head.Hash)
+	}
+}
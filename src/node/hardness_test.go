@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Hardness scores, and the minimums of the network they enforce
+
+// itemsFollowing returns count items whose weights go up by 2, and whose values are the
+// weights run through value
+func itemsFollowing(count int, value func(weight int) int) []Item {
+	items := make([]Item, count)
+	for i := range items {
+		weight := 2 * (i + 1)
+		items[i] = Item{Weight: weight, Value: value(weight)}
+	}
+	return items
+}
+
+func TestEstimateHardness(t *testing.T) {
+	// 10 items weighing 110 in total
+	correlated := itemsFollowing(10, func(weight int) int { return weight })
+	reversed := itemsFollowing(10, func(weight int) int { return 30 - weight })
+	constant := itemsFollowing(10, func(weight int) int { return 5 })
+	tests := []struct {
+		name     string
+		problem  KnapsackProblem
+		items    int
+		ratio    float64
+		score    float64
+		minimums error
+	}{
+		{"values following weights, half of them fit", KnapsackProblem{Items: correlated, Capacity: 55}, 10, 0.5, 10, nil},
+		{"values against weights", KnapsackProblem{Items: reversed, Capacity: 55}, 10, 0.5, 5, ErrProblemTooEasy},
+		{"values unrelated to weights", KnapsackProblem{Items: constant, Capacity: 55}, 10, 0.5, 5, ErrProblemTooEasy},
+		{"every item fits", KnapsackProblem{Items: correlated, Capacity: 110}, 10, 1, 0, ErrProblemTooEasy},
+		{"items heavier than the knapsack", KnapsackProblem{Items: correlated, Capacity: 11}, 5, 0.1, 5 * 4 * 0.1 * 0.9, ErrProblemTooEasy},
+		{"too few items", KnapsackProblem{Items: correlated[:9], Capacity: 45}, 9, 0.5, 9, ErrTooFewItems},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hardness := EstimateHardness(test.problem)
+			if hardness.Items != test.items || math.Abs(hardness.CapacityRatio-test.ratio) > 1e-9 || math.Abs(hardness.Score-test.score) > 1e-9 {
+				t.Errorf("the hardness is %+v, expected %v items, a capacity ratio of %v and a score of %v", hardness, test.items, test.ratio, test.score)
+			}
+			if err := checkHardness(test.problem); !errors.Is(err, test.minimums) {
+				t.Errorf("the minimums return %v, not %v", err, test.minimums)
+			}
+		})
+	}
+}
+
+func TestItemsCorrelation(t *testing.T) {
+	if correlation := itemsCorrelation(itemsFollowing(10, func(weight int) int { return 3*weight + 1 })); math.Abs(correlation-1) > 1e-9 {
+		t.Errorf("values following weights correlate by %v", correlation)
+	}
+	if correlation := itemsCorrelation(itemsFollowing(10, func(weight int) int { return 100 - weight })); math.Abs(correlation+1) > 1e-9 {
+		t.Errorf("values against weights correlate by %v", correlation)
+	}
+	if correlation := itemsCorrelation(itemsFollowing(1, func(weight int) int { return weight })); correlation != 0 {
+		t.Errorf("a single item correlates by %v", correlation)
+	}
+}
+
+func TestMinProblemBounty(t *testing.T) {
+	for items, expected := range map[int]float64{10: MIN_PROBLEM_BOUNTY, 100: 100 * MIN_PROBLEM_BOUNTY_PER_ITEM} {
+		problem := KnapsackProblem{Items: make([]Item, items)}
+		if bounty := MinProblemBounty(problem); math.Abs(bounty-expected) > 1e-9 {
+			t.Errorf("%v items require a bounty of %v, not %v", items, bounty, expected)
+		}
+	}
+}
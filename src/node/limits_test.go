@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Rate limits of the client IPs, on a virtual clock
+
+func TestRateLimiter(t *testing.T) {
+	clock := NewSimClock(simStart)
+	limiter := newRateLimiter(2, 3, clock)
+
+	// a burst of 3, then a token every half second
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.allow("10.0.0.1"); !allowed {
+			t.Fatalf("request %v of the burst is refused", i+1)
+		}
+	}
+	if allowed, wait := limiter.allow("10.0.0.1"); allowed || wait != 500*time.Millisecond {
+		t.Errorf("the request after the burst is allowed %v, to retry in %v", allowed, wait)
+	}
+	if allowed, _ := limiter.allow("10.0.0.2"); !allowed {
+		t.Error("another IP is refused")
+	}
+
+	clock.Sleep(250 * time.Millisecond)
+	if allowed, wait := limiter.allow("10.0.0.1"); allowed || wait != 250*time.Millisecond {
+		t.Errorf("the request half a token later is allowed %v, to retry in %v", allowed, wait)
+	}
+	clock.Sleep(250 * time.Millisecond)
+	if allowed, _ := limiter.allow("10.0.0.1"); !allowed {
+		t.Error("the request a token later is refused")
+	}
+
+	// buckets idle long enough are full again, and dropped
+	clock.Sleep(RATE_LIMIT_IDLE)
+	limiter.allow("10.0.0.3")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("%v buckets are kept after %v idle", len(limiter.buckets), RATE_LIMIT_IDLE)
+	}
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.allow("10.0.0.1"); !allowed {
+			t.Fatalf("request %v of a new burst is refused", i+1)
+		}
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	clock := NewSimClock(simStart)
+	handler := limitRequests(Limits{RateLimit: 0.5, RateBurst: 1}, clock, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(remoteAddress string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/head", nil)
+		r.RemoteAddr = remoteAddress
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	if response := request("10.0.0.1:4000"); response.Code != http.StatusNoContent {
+		t.Fatalf("the first request answers %v", response.Code)
+	}
+	// the port of the client does not matter
+	response := request("10.0.0.1:4001")
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") != "2" {
+		t.Fatalf("the second request answers %v, to retry after %q", response.Code, response.Header().Get("Retry-After"))
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || body.Error.Code != "rate_limited" {
+		t.Errorf("the error is %+v: %v", body, err)
+	}
+	if response := request("10.0.0.2:4000"); response.Code != http.StatusNoContent {
+		t.Errorf("another IP gets %v", response.Code)
+	}
+	clock.Sleep(2 * time.Second)
+	if response := request("10.0.0.1:4000"); response.Code != http.StatusNoContent {
+		t.Errorf("the request after the wait answers %v", response.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// The chain log: records torn by a crash, corrupt records, compaction and resets, each
+// checked again once the log is reopened
+
+// testBlocks returns blocks from height from to height to, chained by hashes tagged by branch
+func testBlocks(branch string, from int, to int) []Block {
+	blocks := make([]Block, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		blocks = append(blocks, Block{
+			Height:   height,
+			Hash:     branch + strconv.Itoa(height),
+			PrevHash: branch + strconv.Itoa(height-1),
+			Data:     BlockData{Type: MonetaryTransaction, Transaction: &Transaction{From: "alice", To: "bob", Amount: 1}},
+		})
+	}
+	return blocks
+}
+
+func openTestLog(t *testing.T, path string, readOnly bool) *LogStore {
+	t.Helper()
+	SetLogLevel("error")
+	store, err := OpenLogStore(path, readOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func writeTestBatch(t *testing.T, store *LogStore, batch Batch) {
+	t.Helper()
+	if err := store.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkBodies checks the store holds the bodies of branch from height from to height to
+func checkBodies(t *testing.T, store *LogStore, branch string, from int, to int) {
+	t.Helper()
+	if store.Base() != from || store.Tip() != to {
+		t.Fatalf("the store holds the bodies %v to %v, not %v to %v", store.Base(), store.Tip(), from, to)
+	}
+	for height := from; height <= to; height++ {
+		if block, held := store.Block(height); !held || block.Hash != branch+strconv.Itoa(height) {
+			t.Fatalf("block %v is %v (held %v)", height, block.Hash, held)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestLogStoreDropsATornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	store := openTestLog(t, path, false)
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 0, 4), Balances: map[string]float64{"alice": 995}})
+	size := fileSize(t, path)
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 5, 5), Balances: map[string]float64{"alice": 994}})
+	store.Close()
+
+	// a crash while the last record was written leaves part of it
+	if err := os.Truncate(path, fileSize(t, path)-3); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := openTestLog(t, path, true)
+	checkBodies(t, readOnly, "a", 0, 4)
+	readOnly.Close()
+	if fileSize(t, path) == size {
+		t.Fatal("the read-only store cut the torn record off")
+	}
+
+	store = openTestLog(t, path, false)
+	checkBodies(t, store, "a", 0, 4)
+	if balance, _ := store.Balance("alice"); balance != 995 {
+		t.Errorf("the balance is %v, not the one of the last record whole", balance)
+	}
+	if fileSize(t, path) != size {
+		t.Errorf("the log is %v bytes, not %v without the torn record", fileSize(t, path), size)
+	}
+	// the next records follow the last whole one
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 5, 6)})
+	store.Close()
+	checkBodies(t, openTestLog(t, path, false), "a", 0, 6)
+}
+
+func TestLogStoreRejectsACorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	store := openTestLog(t, path, false)
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 0, 4)})
+	size := fileSize(t, path)
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 5, 9)})
+	store.Close()
+
+	// a record failing its checksum before the end of the log was not torn by a crash
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[size/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLogStore(path, false); err == nil {
+		t.Fatal("the corrupt log is opened")
+	}
+}
+
+func TestLogStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	store := openTestLog(t, path, false)
+	for height := 0; height < 20; height++ {
+		writeTestBatch(t, store, Batch{Blocks: testBlocks("a", height, height), Nonces: map[string]int{"alice": height}})
+	}
+	size := fileSize(t, path)
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 20, 20), Snapshot: &StateSnapshot{Height: 20}, PruneBelow: 15})
+
+	check := func(store *LogStore) {
+		t.Helper()
+		checkBodies(t, store, "a", 15, 20)
+		if store.HeaderBase() != 0 {
+			t.Errorf("the headers start at %v", store.HeaderBase())
+		}
+		for height := 0; height < 15; height++ {
+			if _, held := store.Block(height); held {
+				t.Errorf("the pruned body %v is held", height)
+			}
+			if header, held := store.Header(height); !held || header.Hash != "a"+strconv.Itoa(height) {
+				t.Errorf("the header of block %v is %v (held %v)", height, header.Hash, held)
+			}
+			if at, held := store.HeightOf("a" + strconv.Itoa(height)); !held || at != height {
+				t.Errorf("the hash of block %v is at %v (held %v)", height, at, held)
+			}
+		}
+		if store.Nonce("alice") != 19 || store.Snapshot() == nil || store.Snapshot().Height != 20 {
+			t.Errorf("the state is not kept: nonce %v, snapshot %+v", store.Nonce("alice"), store.Snapshot())
+		}
+	}
+	check(store)
+	if fileSize(t, path) >= size {
+		t.Errorf("the compacted log is %v bytes, %v before", fileSize(t, path), size)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the compaction left its temporary log: %v", err)
+	}
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 21, 21)})
+	store.Close()
+
+	store = openTestLog(t, path, false)
+	if store.Tip() != 21 {
+		t.Fatalf("the tip is %v after reopening", store.Tip())
+	}
+	writeTestBatch(t, store, Batch{Reset: true, Blocks: testBlocks("a", 0, 20), Nonces: map[string]int{"alice": 19}, Snapshot: &StateSnapshot{Height: 20}, PruneBelow: 15})
+	check(store)
+}
+
+func TestLogStoreReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.log")
+	store := openTestLog(t, path, false)
+	consensus := &ConsensusRecord{Height: 3, LockedRound: -1, ValidRound: -1}
+	writeTestBatch(t, store, Batch{Blocks: testBlocks("a", 0, 9), Balances: map[string]float64{"alice": 990}, Consensus: consensus})
+
+	// the chain switches to another branch, longer than the bodies cached
+	branch := testBlocks("b", 0, 2*LOG_STORE_CACHE_SIZE)
+	writeTestBatch(t, store, Batch{Reset: true, Blocks: branch, Balances: map[string]float64{"bob": 1010}})
+
+	check := func(store *LogStore) {
+		t.Helper()
+		checkBodies(t, store, "b", 0, 2*LOG_STORE_CACHE_SIZE)
+		if _, held := store.HeightOf("a9"); held {
+			t.Error("a block of the branch dropped is held")
+		}
+		if _, changed := store.Balance("alice"); changed {
+			t.Error("a balance of the branch dropped is held")
+		}
+		if balance, _ := store.Balance("bob"); balance != 1010 {
+			t.Errorf("the balance of bob is %v", balance)
+		}
+		if store.Consensus() == nil || store.Consensus().Height != 3 {
+			t.Errorf("the record of the validator is %+v", store.Consensus())
+		}
+		// every body is read from a record of its own
+		for _, location := range store.locations {
+			if location.index != 0 {
+				t.Fatalf("a body is read from a record of several blocks: %+v", location)
+			}
+		}
+	}
+	check(store)
+	store.Close()
+	check(openTestLog(t, path, false))
+}
@@ -1,51 +1,23 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"fmt"
 	"os"
 
-	"github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal(err)
+	// a .env file in the working directory is optional, its variables are loaded into the environment
+	godotenv.Load()
+
+	command, args := findCommand(os.Args[1:])
+	if command == nil {
+		if len(os.Args) > 1 {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		}
+		runHelp(nil, nil)
+		os.Exit(2)
 	}
-
-	var ledger *Ledger = NewLedger()
-	blockchain := CreateNewBlockchain(ledger)
-
-	port := os.Getenv("PORT") // Default port is set in .env file
-	if len(os.Args) > 1 {     // If a port is passed as an argument, use that instead
-		port = os.Args[1]
-	}
-
-	router := mux.NewRouter().StrictSlash(true)
-	router.HandleFunc("/api/heartbeat", HomeLink).Methods("GET")
-	router.HandleFunc("/api/home", HomeLink).Methods("GET")
-	router.HandleFunc("/api/get_blockchain", func(w http.ResponseWriter, r *http.Request) {
-		HandleGetBlockchain(w, r, blockchain)
-	}).Methods("GET")
-	router.HandleFunc("/api/get_ledger", func(w http.ResponseWriter, r *http.Request) {
-		HandleGetLedger(w, r, ledger)
-	}).Methods("GET")
-	router.HandleFunc("/api/send_problem", func(w http.ResponseWriter, r *http.Request) {
-		HandleWriteProblemBlock(w, r, blockchain, ledger)
-	}).Methods("POST")
-	router.HandleFunc("/api/send_proposed_solution", func(w http.ResponseWriter, r *http.Request) {
-		HandleWriteProposedSolutionBlock(w, r, blockchain, ledger)
-	}).Methods("POST")
-
-	headers := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
-	methods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "HEAD", "OPTIONS"})
-	origins := handlers.AllowedOrigins([]string{"*"})
-
-	log.Println("now serving on ", port)
-	node := InitNode(port)
-	go node.StartNode(blockchain, ledger)
-	log.Fatal(http.ListenAndServe(":"+port, handlers.CORS(headers, methods, origins)(router)))
+	os.Exit(runCommand(command, args))
 }
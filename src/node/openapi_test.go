@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+// The routes of the API and the types they exchange must match openapi.json, which the node
+// also checks on start
+func TestRouterMatchesOpenAPISpec(t *testing.T) {
+	ledger := NewLedger()
+	bc := CreateNewBlockchain(ledger)
+	if err := CheckRouterAgainstSpec(NewRouter(bc, ledger)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Bounty payouts: how ComputePayouts splits a bounty, and the payout blocks a chain appends
+// when a problem expires or a solution is certified optimal
+
+// newTestChain creates a chain on a virtual clock, which addTestBlock moves forward
+func newTestChain(t *testing.T, clock *SimClock) (*Blockchain, *Ledger) {
+	t.Helper()
+	SetLogLevel("error")
+	ledger := NewLedger()
+	bc := CreateNewBlockchain(ledger)
+	bc.SetClock(clock)
+	return bc, ledger
+}
+
+// testProblem returns a problem of 10 items meeting the minimums of the network, its values
+// following its weights and its capacity half of their total
+func testProblem(address string, bounty float64, policy PayoutPolicy) KnapsackProblem {
+	problem := KnapsackProblem{Capacity: 37, Bounty: bounty, Address: address, PayoutPolicy: policy}
+	for i := 0; i < 10; i++ {
+		problem.Items = append(problem.Items, Item{Weight: 3 + i, Value: 3 + i + i%3})
+	}
+	return problem
+}
+
+// testSolution returns the solution of address taking the given items of the problem at height
+func testSolution(problem KnapsackProblem, height int, address string, items ...int) KnapsackProposedSolution {
+	solution := KnapsackProposedSolution{ItemIndexes: items, ProblemBlockHeight: height, Address: address}
+	solution.Value = GetTotalSolutionValue(problem, solution)
+	return solution
+}
+
+// optimalItems returns the items of an optimal solution of a small problem, by brute force
+func optimalItems(problem KnapsackProblem) []int {
+	best, bestValue := []int{}, -1
+	for set := 0; set < 1<<len(problem.Items); set++ {
+		items, weight, value := []int{}, 0, 0
+		for i, item := range problem.Items {
+			if set&(1<<i) != 0 {
+				items = append(items, i)
+				weight += item.Weight
+				value += item.Value
+			}
+		}
+		if weight <= problem.Capacity && value > bestValue {
+			best, bestValue = items, value
+		}
+	}
+	return best
+}
+
+// addTestBlock adds a block holding data at the tip, ten seconds after the previous one
+func addTestBlock(t *testing.T, bc *Blockchain, ledger *Ledger, clock *SimClock, data BlockData) Block {
+	t.Helper()
+	clock.Sleep(10 * time.Second)
+	block, err := bc.generateNewBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(block, ledger); err != nil {
+		t.Fatalf("block %v: %v", block.Height, err)
+	}
+	return block
+}
+
+func addTestProblem(t *testing.T, bc *Blockchain, ledger *Ledger, clock *SimClock, problem KnapsackProblem) int {
+	t.Helper()
+	return addTestBlock(t, bc, ledger, clock, BlockData{Type: KnapsackProblemSubmission, Problem: &problem}).Height
+}
+
+func addTestSolution(t *testing.T, bc *Blockchain, ledger *Ledger, clock *SimClock, solution KnapsackProposedSolution) {
+	t.Helper()
+	addTestBlock(t, bc, ledger, clock, BlockData{Type: KnapsackProposedSolutionSubmission, Solution: &solution})
+}
+
+// payoutOf returns the payout block of the problem at height, failing when the chain holds none
+func payoutOf(t *testing.T, bc *Blockchain, height int) Block {
+	t.Helper()
+	for _, block := range bc.GetAllBlocks() {
+		if block.Data.Type == BountyPayoutSubmission && block.Data.Payout.ProblemBlockHeight == height {
+			return block
+		}
+	}
+	t.Fatalf("the problem at height %v is not paid out", height)
+	return Block{}
+}
+
+func checkBalance(t *testing.T, ledger *Ledger, address string, expected float64) {
+	t.Helper()
+	if balance := ledger.GetBalance(address); math.Abs(balance-expected) > 1e-9 {
+		t.Errorf("%v has a balance of %v, not %v", address, balance, expected)
+	}
+}
+
+func TestComputePayouts(t *testing.T) {
+	solution := func(address string, value int) KnapsackProposedSolution {
+		return KnapsackProposedSolution{Address: address, Value: value}
+	}
+	tests := []struct {
+		name      string
+		policy    PayoutPolicy
+		solutions []KnapsackProposedSolution
+		expected  map[string]float64
+	}{
+		{"no solution", WinnerTakesAllPayout, nil, map[string]float64{}},
+		{"winner takes all", WinnerTakesAllPayout, []KnapsackProposedSolution{solution("alice", 10), solution("bob", 30)}, map[string]float64{"bob": 30}},
+		{"default policy", "", []KnapsackProposedSolution{solution("alice", 10), solution("bob", 30)}, map[string]float64{"bob": 30}},
+		{"first to reach the best value", WinnerTakesAllPayout, []KnapsackProposedSolution{solution("alice", 30), solution("bob", 30)}, map[string]float64{"alice": 30}},
+		{"proportional to the improvements", ProportionalPayout, []KnapsackProposedSolution{solution("alice", 20), solution("bob", 30), solution("alice", 35)}, map[string]float64{"alice": 30 * 25.0 / 35, "bob": 30 * 10.0 / 35}},
+		{"solutions not improving count for nothing", ProportionalPayout, []KnapsackProposedSolution{solution("alice", 20), solution("bob", 20), solution("carol", 10)}, map[string]float64{"alice": 30}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problem := KnapsackProblem{Address: "poster", Bounty: 30, PayoutPolicy: test.policy}
+			transactions := ComputePayouts(7, problem, test.solutions)
+			if len(transactions) != len(test.expected) {
+				t.Fatalf("%v transactions, not %v: %+v", len(transactions), len(test.expected), transactions)
+			}
+			paid := 0.0
+			for _, tx := range transactions {
+				if tx.From != "poster" || tx.ProblemBlockHeight != 7 {
+					t.Errorf("transaction %+v is not from the poster of the problem at height 7", tx)
+				}
+				if math.Abs(tx.Amount-test.expected[tx.To]) > 1e-9 {
+					t.Errorf("%v is paid %v, not %v", tx.To, tx.Amount, test.expected[tx.To])
+				}
+				paid += tx.Amount
+			}
+			if len(transactions) > 0 && paid != problem.Bounty {
+				t.Errorf("the transactions pay %v of a bounty of %v", paid, problem.Bounty)
+			}
+		})
+	}
+}
+
+func TestExpiredProblemIsPaidOut(t *testing.T) {
+	for _, policy := range []PayoutPolicy{WinnerTakesAllPayout, ProportionalPayout} {
+		t.Run(string(policy), func(t *testing.T) {
+			clock := NewSimClock(simStart)
+			bc, ledger := newTestChain(t, clock)
+			problem := testProblem("poster", 30, policy)
+			height := addTestProblem(t, bc, ledger, clock, problem)
+			addTestSolution(t, bc, ledger, clock, testSolution(problem, height, "alice", 0, 1))
+			addTestSolution(t, bc, ledger, clock, testSolution(problem, height, "bob", 0, 1, 2))
+			// the problem is open until its expiry height
+			for bc.GetHead().Height < problemExpiryHeight(height) {
+				if bc.findPayoutBlock(height) != nil {
+					t.Fatalf("the problem is paid out at block %v, before its expiry", bc.GetHead().Height)
+				}
+				addTestProblem(t, bc, ledger, clock, testProblem("filler", 30+float64(bc.GetHead().Height), policy))
+			}
+
+			payout := payoutOf(t, bc, height)
+			if payout.Height != problemExpiryHeight(height)+1 || payout.Data.Payout.Early {
+				t.Errorf("payout at block %v (early %v), expected right after the expiry at %v", payout.Height, payout.Data.Payout.Early, problemExpiryHeight(height))
+			}
+			// alice takes items worth 8, bob improves it to 15
+			expected := map[string]float64{"alice": 0, "bob": 30}
+			if policy == ProportionalPayout {
+				expected = map[string]float64{"alice": 30 * 8.0 / 15, "bob": 30 * 7.0 / 15}
+			}
+			checkBalance(t, ledger, "poster", ADDRESS_INITIAL_BALANCE-30)
+			for address, amount := range expected {
+				checkBalance(t, ledger, address, ADDRESS_INITIAL_BALANCE+amount)
+			}
+			// the settled problem accepts no more solutions
+			if err := ValidateProposedSolution(testSolution(problem, height, "carol", optimalItems(problem)...), bc); err == nil {
+				t.Error("a solution to the settled problem is accepted")
+			}
+		})
+	}
+}
+
+func TestCertifiedSolutionSettlesEarly(t *testing.T) {
+	clock := NewSimClock(simStart)
+	bc, ledger := newTestChain(t, clock)
+	problem := testProblem("poster", 30, WinnerTakesAllPayout)
+	height := addTestProblem(t, bc, ledger, clock, problem)
+	addTestSolution(t, bc, ledger, clock, testSolution(problem, height, "alice", 0, 1))
+
+	// a Dantzig bound above the value proves no optimum
+	dantzig := testSolution(problem, height, "bob", 0, 1, 2)
+	dantzig.Certificate = &OptimalityCertificate{Type: DantzigCertificate, UpperBound: DantzigBound(problem)}
+	addTestSolution(t, bc, ledger, clock, dantzig)
+	if bc.findPayoutBlock(height) != nil {
+		t.Fatal("a solution below its certified bound settles the problem")
+	}
+
+	optimum, digest := SolveDP(problem)
+	certified := testSolution(problem, height, "carol", optimalItems(problem)...)
+	certified.Certificate = &OptimalityCertificate{Type: DPCertificate, UpperBound: optimum, Digest: digest}
+	if certified.Value != optimum {
+		t.Fatalf("brute force finds %v, DP %v", certified.Value, optimum)
+	}
+	addTestSolution(t, bc, ledger, clock, certified)
+
+	payout := payoutOf(t, bc, height)
+	if payout.Height != bc.GetHead().Height || payout.Height != height+4 || !payout.Data.Payout.Early {
+		t.Errorf("payout at block %v (early %v), expected right after the certified solution", payout.Height, payout.Data.Payout.Early)
+	}
+	checkBalance(t, ledger, "poster", ADDRESS_INITIAL_BALANCE-30)
+	checkBalance(t, ledger, "carol", ADDRESS_INITIAL_BALANCE+30)
+	checkBalance(t, ledger, "bob", ADDRESS_INITIAL_BALANCE)
+
+	// expiring later does not pay the bounty out again
+	for bc.GetHead().Height <= problemExpiryHeight(height)+1 {
+		addTestProblem(t, bc, ledger, clock, testProblem("filler", 30+float64(bc.GetHead().Height), WinnerTakesAllPayout))
+	}
+	checkBalance(t, ledger, "carol", ADDRESS_INITIAL_BALANCE+30)
+}
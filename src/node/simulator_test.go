@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Seeded simulations, for the fork choice of the nodes without a validator set and for the
+// consensus of validators. Whatever the messages lost or the partitions, once they heal the
+// chains must end up identical, pay out the same bounties and lead to the same balances
+
+func TestSimulatorConverges(t *testing.T) {
+	SetLogLevel("error")
+	partition := []SimPartition{{From: 5 * time.Minute, Until: 10 * time.Minute, Groups: [][]int{{0, 1}, {2, 3}}}}
+	scenarios := []struct {
+		name   string
+		config SimConfig
+	}{
+		{"fork choice", SimConfig{Seed: 1}},
+		{"fork choice with drops", SimConfig{Seed: 2, DropRate: 0.1}},
+		{"fork choice with a partition", SimConfig{Seed: 3, Partitions: partition}},
+		{"validators", SimConfig{Seed: 1, Validators: true}},
+		{"validators with drops", SimConfig{Seed: 2, DropRate: 0.1, Validators: true}},
+		{"validators with a partition", SimConfig{Seed: 3, Partitions: partition, Validators: true}},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			config := scenario.config
+			config.Nodes = 4
+			config.Duration = 20 * time.Minute
+			config.MinLatency = 20 * time.Millisecond
+			config.MaxLatency = 200 * time.Millisecond
+			simulator, err := NewSimulator(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := simulator.Run()
+
+			if config.DropRate > 0 && report.Messages.Dropped == 0 {
+				t.Error("no message was dropped")
+			}
+			if len(config.Partitions) > 0 && report.Messages.Partitioned == 0 {
+				t.Error("no message was stopped by the partition")
+			}
+			checkConverged(t, report)
+			for _, node := range simulator.nodes {
+				checkSettlements(t, node)
+				if node.producer != nil {
+					checkCommits(t, node)
+				}
+			}
+		})
+	}
+}
+
+// checkConverged checks the nodes of a simulation hold the same chain, holding payouts, and
+// agree on every balance
+func checkConverged(t *testing.T, report SimReport) {
+	t.Helper()
+	first := report.Nodes[0]
+	if first.Height < 20 || first.Payouts == 0 {
+		t.Fatalf("%v only reached block %v with %v payouts", first.Name, first.Height, first.Payouts)
+	}
+	for _, node := range report.Nodes[1:] {
+		if node.Height != first.Height || node.TipHash != first.TipHash {
+			t.Errorf("%v is at block %v (%v), %v at block %v (%v)", node.Name, node.Height, node.TipHash, first.Name, first.Height, first.TipHash)
+		}
+		if node.Payouts != first.Payouts || node.Solutions != first.Solutions {
+			t.Errorf("%v holds %v payouts of %v solutions, %v %v of %v", node.Name, node.Payouts, node.Solutions, first.Name, first.Payouts, first.Solutions)
+		}
+	}
+	if report.Agreement != first.Height+1 {
+		t.Errorf("the nodes agree on the first %v blocks of %v", report.Agreement, first.Height+1)
+	}
+	if len(report.Balances) > 0 {
+		t.Errorf("the nodes disagree on the balances of %v", report.Balances)
+	}
+	if len(report.Replay) > 0 {
+		t.Errorf("chains do not replay from genesis: %v", report.Replay)
+	}
+}
+
+// checkSettlements checks every problem of the chain of a node solved and expired is paid out
+// once, right after its expiry, to the solvers ComputePayouts names, and that the ledger of
+// the node holds the balances the transfers and payouts of its chain lead to
+func checkSettlements(t *testing.T, node *simNode) {
+	t.Helper()
+	blocks := node.bc.GetAllBlocks()
+	tip := blocks[len(blocks)-1].Height
+	balances := make(map[string]float64)
+	transfer := func(tx Transaction) {
+		for _, address := range []string{tx.From, tx.To} {
+			if _, exists := balances[address]; !exists {
+				balances[address] = ADDRESS_INITIAL_BALANCE
+			}
+		}
+		balances[tx.From] -= tx.Amount
+		balances[tx.To] += tx.Amount
+	}
+	solutions := func(height int, before int) []KnapsackProposedSolution {
+		found := make([]KnapsackProposedSolution, 0)
+		for _, block := range blocks[height+1 : min(before, problemExpiryHeight(height)+1)] {
+			if block.Data.Type == KnapsackProposedSolutionSubmission && block.Data.Solution.ProblemBlockHeight == height {
+				found = append(found, *block.Data.Solution)
+			}
+		}
+		return found
+	}
+
+	paid := make(map[int]bool)
+	for _, block := range blocks[1:] {
+		switch block.Data.Type {
+		case MonetaryTransaction:
+			transfer(*block.Data.Transaction)
+		case BountyPayoutSubmission:
+			payout := block.Data.Payout
+			height := payout.ProblemBlockHeight
+			if paid[height] {
+				t.Errorf("%v pays the problem at height %v out twice", node.name, height)
+			}
+			paid[height] = true
+			if !payout.Early && block.Height != problemExpiryHeight(height)+1 {
+				t.Errorf("%v pays the problem at height %v out at block %v, not right after its expiry", node.name, height, block.Height)
+			}
+			problem := *blocks[height].Data.Problem
+			expected := ComputePayouts(height, problem, solutions(height, block.Height))
+			if !reflect.DeepEqual(payout.Transactions, expected) {
+				t.Errorf("%v pays the problem at height %v out with %+v, not %+v", node.name, height, payout.Transactions, expected)
+			}
+			total := 0.0
+			for _, tx := range payout.Transactions {
+				total += tx.Amount
+				transfer(tx)
+			}
+			if math.Abs(total-problem.Bounty) > 1e-9 {
+				t.Errorf("%v pays %v of the bounty of %v of the problem at height %v", node.name, total, problem.Bounty, height)
+			}
+		}
+	}
+	for _, block := range blocks[1:] {
+		height := block.Height
+		if block.Data.Type == KnapsackProblemSubmission && problemExpiryHeight(height) < tip && len(solutions(height, tip+1)) > 0 && !paid[height] {
+			t.Errorf("%v does not pay the problem solved at height %v out", node.name, height)
+		}
+	}
+
+	for address, balance := range balances {
+		if math.Abs(node.ledger.GetBalance(address)-balance) > 1e-9 {
+			t.Errorf("%v holds a balance of %v for %v, its chain leads to %v", node.name, node.ledger.GetBalance(address), address, balance)
+		}
+	}
+	for address := range node.ledger.Balances() {
+		if _, exists := balances[address]; !exists {
+			t.Errorf("%v holds a balance for %v, which its chain does not touch", node.name, address)
+		}
+	}
+}
+
+// checkCommits checks every block of a validator but the payouts carries the precommits of a
+// quorum of validators for it
+func checkCommits(t *testing.T, node *simNode) {
+	t.Helper()
+	quorum := node.producer.validators.Quorum()
+	for _, block := range node.bc.GetAllBlocks()[1:] {
+		if block.Data.Type == BountyPayoutSubmission {
+			if block.Commit != nil {
+				t.Errorf("%v holds a commit for the payout block %v", node.name, block.Height)
+			}
+			continue
+		}
+		if block.Commit == nil {
+			t.Errorf("%v holds block %v without a commit", node.name, block.Height)
+			continue
+		}
+		voters := make(map[string]bool)
+		for _, vote := range block.Commit.Votes {
+			if vote.Type == Precommit && vote.Height == block.Height && vote.Round == block.Commit.Round && vote.BlockHash == block.Hash {
+				voters[vote.Validator] = true
+			}
+		}
+		if len(voters) < quorum {
+			t.Errorf("%v holds block %v committed by %v validators, %v are needed", node.name, block.Height, len(voters), quorum)
+		}
+	}
+}